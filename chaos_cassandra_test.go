@@ -0,0 +1,56 @@
+// +build cassandra
+
+package glock_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+	"gopkg.in/gbagnoli/glock.v1/glocktest"
+)
+
+func cassandraProxy(t *testing.T) *glocktest.Proxy {
+	host := glock.CassandraTestOptions().Hosts[0]
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "9042")
+	}
+	return newProxy(t, "tcp", host)
+}
+
+func cassandraChaosClient(t *testing.T, proxy *glocktest.Proxy) glock.Client {
+	opts := glock.CassandraTestOptions()
+	if proxy != nil {
+		opts.Dialer = proxy
+	}
+	c, err := glock.NewCassandraLockClient(opts)
+	if err != nil {
+		t.Fatalf("Cannot create cassandra client: %s", err)
+	}
+	return c
+}
+
+func TestCassandraChaosPartition(t *testing.T) {
+	proxy := cassandraProxy(t)
+	defer proxy.Close()
+	testChaosPartition(t, proxy, cassandraChaosClient, time.Second)
+}
+
+func TestCassandraChaosDrop(t *testing.T) {
+	proxy := cassandraProxy(t)
+	defer proxy.Close()
+	testChaosDrop(t, proxy, cassandraChaosClient, time.Second)
+}
+
+func TestCassandraChaosKeeperStall(t *testing.T) {
+	proxy := cassandraProxy(t)
+	defer proxy.Close()
+	testChaosKeeperStall(t, proxy, cassandraChaosClient, time.Second)
+}
+
+func TestCassandraChaosKeeperDrop(t *testing.T) {
+	proxy := cassandraProxy(t)
+	defer proxy.Close()
+	testChaosKeeperDrop(t, proxy, cassandraChaosClient, time.Second)
+}
@@ -0,0 +1,55 @@
+// +build redis
+
+package glock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"gopkg.in/gbagnoli/glock.v1"
+	"gopkg.in/gbagnoli/glock.v1/glocktest"
+)
+
+func redisProxy(t *testing.T) *glocktest.Proxy {
+	opts := glock.RedisTestOptions()
+	return newProxy(t, opts.Network, opts.Address)
+}
+
+func redisChaosClient(t *testing.T, proxy *glocktest.Proxy) glock.Client {
+	opts := glock.RedisTestOptions()
+	if proxy != nil {
+		opts.DialFunc = func(network, address string, options ...redis.DialOption) (redis.Conn, error) {
+			return redis.Dial("tcp", proxy.Addr(), options...)
+		}
+	}
+	c, err := glock.NewRedisClient(opts)
+	if err != nil {
+		t.Fatalf("Cannot create redis client: %s", err)
+	}
+	return c
+}
+
+func TestRedisChaosPartition(t *testing.T) {
+	proxy := redisProxy(t)
+	defer proxy.Close()
+	testChaosPartition(t, proxy, redisChaosClient, time.Millisecond)
+}
+
+func TestRedisChaosDrop(t *testing.T) {
+	proxy := redisProxy(t)
+	defer proxy.Close()
+	testChaosDrop(t, proxy, redisChaosClient, time.Millisecond)
+}
+
+func TestRedisChaosKeeperStall(t *testing.T) {
+	proxy := redisProxy(t)
+	defer proxy.Close()
+	testChaosKeeperStall(t, proxy, redisChaosClient, time.Millisecond)
+}
+
+func TestRedisChaosKeeperDrop(t *testing.T) {
+	proxy := redisProxy(t)
+	defer proxy.Close()
+	testChaosKeeperDrop(t, proxy, redisChaosClient, time.Millisecond)
+}
@@ -0,0 +1,228 @@
+package glock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+	"gopkg.in/gbagnoli/glock.v1/glocktest"
+)
+
+const ttlLength = 30
+
+const chaosLock = "chaos"
+
+// chaosClientFunc returns a new client of the store under test, connecting
+// through the given proxy if not nil
+type chaosClientFunc func(t *testing.T, proxy *glocktest.Proxy) glock.Client
+
+func newProxy(t *testing.T, network, address string) *glocktest.Proxy {
+	proxy, err := glocktest.NewProxy(network, address)
+	if err != nil {
+		t.Fatalf("Cannot start proxy: %s", err)
+	}
+	return proxy
+}
+
+func checkHistory(t *testing.T, h *glocktest.History) {
+	if err := h.Check(); err != nil {
+		t.Error(err)
+	}
+}
+
+// retry calls f until it succeeds or fails with an error of the lock, e.g.
+// once reconnected after a dropped connection, or until timeout
+func retry(timeout, interval time.Duration, f func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := f()
+		switch err {
+		case nil, glock.ErrLockHeldByOtherClient, glock.ErrLockNotOwned, glock.ErrLockStolen:
+			return err
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(interval)
+	}
+}
+
+// nextLease waits for the next event of the keeper with the given type,
+// failing if the lease is lost meanwhile
+func nextLease(t *testing.T, events <-chan glock.LeaseEvent, typ glock.LeaseEventType, timeout time.Duration) glock.LeaseEvent {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == typ {
+				return ev
+			}
+			if ev.Type == glock.LeaseLost {
+				t.Fatalf("Lease lost while waiting for event '%s': %s", typ, ev.Err)
+			}
+		case <-deadline:
+			t.Fatalf("Event '%s' not received after %v", typ, timeout)
+		}
+	}
+}
+
+// testChaosPartition cuts the holder of a lock off the store
+func testChaosPartition(t *testing.T, proxy *glocktest.Proxy, cfun chaosClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	h := &glocktest.History{}
+	holder := h.Client(cfun(t, proxy)).NewLock(chaosLock)
+	other := h.Client(cfun(t, nil)).NewLock(chaosLock)
+
+	if err := holder.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+
+	// The calls of the holder time out, and the lock is held until it expires
+	proxy.Partition()
+	ctx, cancel := context.WithTimeout(context.Background(), ttl/4)
+	err := holder.RefreshContext(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("Refresh while partitioned should return '%s', got '%v'", context.DeadlineExceeded, err)
+	}
+	if err = other.Acquire(ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%v'", glock.ErrLockHeldByOtherClient, err)
+	}
+	// some stores expire locks lazily, after their TTL
+	time.Sleep(ttl)
+	for deadline := time.Now().Add(ttl); ; time.Sleep(scale) {
+		err = other.Acquire(ttl)
+		if err != glock.ErrLockHeldByOtherClient || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("Cannot acquire lock expired while its holder was partitioned: %s", err)
+	}
+	defer other.Release()
+
+	// Once healed, the holder finds out it lost the lock
+	proxy.Heal()
+	if err = holder.Refresh(); err != glock.ErrLockNotOwned {
+		t.Errorf("Refreshing a lost lock should return '%s', got '%v'", glock.ErrLockNotOwned, err)
+	}
+	if err = holder.Release(); err != glock.ErrLockNotOwned {
+		t.Errorf("Releasing a lost lock should return '%s', got '%v'", glock.ErrLockNotOwned, err)
+	}
+	if other.Token() <= holder.Token() {
+		t.Errorf("Tokens should increase: %d <= %d", other.Token(), holder.Token())
+	}
+	checkHistory(t, h)
+}
+
+// testChaosDrop resets the connections of the holder of a lock
+func testChaosDrop(t *testing.T, proxy *glocktest.Proxy, cfun chaosClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	h := &glocktest.History{}
+	holder := h.Client(cfun(t, proxy)).NewLock(chaosLock)
+	other := h.Client(cfun(t, nil)).NewLock(chaosLock)
+
+	if err := holder.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+
+	// The lock outlives the connection: the holder reconnects and keeps it
+	proxy.Drop()
+	if err := retry(ttl/2, scale, holder.Refresh); err != nil {
+		t.Fatalf("Cannot refresh lock after its connection was dropped: %s", err)
+	}
+	if err := other.Acquire(ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%v'", glock.ErrLockHeldByOtherClient, err)
+	}
+	if err := holder.Release(); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+	checkHistory(t, h)
+}
+
+// keep acquires the lock with m, and keeps its lease until the test ends
+func keep(t *testing.T, m *glock.LockManager, ttl time.Duration) <-chan glock.LeaseEvent {
+	events := make(chan glock.LeaseEvent, 100)
+	if err := m.Acquire(chaosLock, glock.AcquireOptions{TTL: ttl}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	_, err := m.KeepLease(chaosLock, glock.KeeperOptions{
+		Interval: ttl / 4,
+		OnEvent: func(ev glock.LeaseEvent) {
+			select {
+			case events <- ev:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Cannot keep lease: %s", err)
+	}
+	return events
+}
+
+// testChaosKeeperStall hangs the connection of a lease keeper mid-heartbeat
+func testChaosKeeperStall(t *testing.T, proxy *glocktest.Proxy, cfun chaosClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	h := &glocktest.History{}
+	m1 := glock.NewLockManager(h.Client(cfun(t, proxy)), glock.AcquireOptions{})
+	m2 := glock.NewLockManager(h.Client(cfun(t, nil)), glock.AcquireOptions{})
+	defer m1.ReleaseAll()
+	defer m2.ReleaseAll()
+
+	events := keep(t, m1, ttl)
+	ctx, err := m1.Context(chaosLock)
+	if err != nil {
+		t.Fatalf("Cannot get lease context: %s", err)
+	}
+	nextLease(t, events, glock.LeaseRefreshed, ttl)
+
+	// The keeper gives the lease up by the time it expires
+	proxy.Stall()
+	defer proxy.Resume()
+	lost := nextLease(t, events, glock.LeaseLost, ttl*2)
+	select {
+	case <-ctx.Done():
+	case <-time.After(ttl):
+		t.Errorf("Lease context not done after the lease was lost")
+	}
+
+	// Nobody else gets the lock before the keeper lost it
+	if err = m2.Acquire(chaosLock, glock.AcquireOptions{TTL: ttl, MaxWait: ttl * 2}); err != nil {
+		t.Fatalf("Cannot acquire lock lost by the keeper: %s", err)
+	}
+	if acquired := time.Now(); lost.Expires.After(acquired) {
+		t.Errorf("Lock acquired at %s, while the keeper held it until %s", acquired, lost.Expires)
+	}
+	checkHistory(t, h)
+}
+
+// testChaosKeeperDrop resets the connection of a lease keeper mid-heartbeat
+func testChaosKeeperDrop(t *testing.T, proxy *glocktest.Proxy, cfun chaosClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	h := &glocktest.History{}
+	m1 := glock.NewLockManager(h.Client(cfun(t, proxy)), glock.AcquireOptions{})
+	m2 := glock.NewLockManager(h.Client(cfun(t, nil)), glock.AcquireOptions{})
+	defer m2.ReleaseAll()
+
+	events := keep(t, m1, ttl)
+	nextLease(t, events, glock.LeaseRefreshed, ttl)
+
+	// The keeper reconnects, and keeps the lease
+	proxy.Drop()
+	nextLease(t, events, glock.LeaseRefreshed, ttl)
+	nextLease(t, events, glock.LeaseRefreshed, ttl)
+	if err := m2.Acquire(chaosLock, glock.AcquireOptions{TTL: ttl}); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%v'", glock.ErrLockHeldByOtherClient, err)
+	}
+	// the first call of the manager itself fails, if on the connection dropped
+	refresh := func() error { return m1.Refresh(chaosLock) }
+	if err := retry(ttl/2, scale, refresh); err != nil {
+		t.Fatalf("Cannot refresh lock after its connection was dropped: %s", err)
+	}
+	if err := m1.Release(chaosLock); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+	checkHistory(t, h)
+}
@@ -1,6 +1,10 @@
 package glock
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+	"time"
+)
 
 func testClient(t *testing.T, cfun newClientFunc) {
 	c1 := cfun(t)
@@ -36,3 +40,152 @@ func testClient(t *testing.T, cfun newClientFunc) {
 		t.Errorf("Clone should have copied client ids '%s' != '%s'", c1.ID(), c3.ID())
 	}
 }
+
+func testClientList(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	c1, c2 := cfun(t), cfun(t)
+	client := c1.(ListClient)
+
+	a := c1.NewLock("list/a")
+	a.SetData("a")
+	if err := a.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	defer a.Release()
+	b := c2.(RWClient).NewRWLock("list/b")
+	if err := b.AcquireShared(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	defer b.Release()
+	other := c2.NewLock("other")
+	if err := other.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	defer other.Release()
+	released := c2.NewLock("list/released")
+	if err := released.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	if err := released.Release(); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+
+	// Only the live locks with the prefix are listed, sorted by name
+	infos, err := client.List("list/")
+	if err != nil {
+		t.Fatalf("Cannot list locks: %s", err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("Expected locks list/a and list/b, got %+v", infos)
+	}
+	if i := infos[0]; i.Name != "list/a" || !i.Acquired || i.Owner != c1.ID() || i.Data != "a" || i.Token != a.Token() {
+		t.Errorf("Expected list/a held by %s with token %d, got %+v", c1.ID(), a.Token(), i)
+	}
+	if i := infos[1]; i.Name != "list/b" || !i.Shared || fmt.Sprint(i.Holders) != fmt.Sprint([]string{c2.ID()}) {
+		t.Errorf("Expected list/b shared by %s, got %+v", c2.ID(), i)
+	}
+
+	infos, err = client.List("")
+	if err != nil {
+		t.Fatalf("Cannot list locks: %s", err)
+	}
+	found := false
+	for _, i := range infos {
+		found = found || i.Name == "other"
+	}
+	if !found {
+		t.Errorf("Expected lock other to be listed, got %+v", infos)
+	}
+
+	// Released locks are not listed anymore
+	if err = a.Release(); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+	if infos, err = client.List("list/"); err != nil || len(infos) != 1 || infos[0].Name != "list/b" {
+		t.Errorf("Expected lock list/b only, got %+v (%v)", infos, err)
+	}
+}
+
+func testClientAdmin(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	c1, c2 := cfun(t), cfun(t)
+	admin := cfun(t).(AdminClient)
+
+	held := c1.NewLock(lockName)
+	if err := held.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+
+	// Stealing takes the lock over, and records who did it and why
+	stolen, err := admin.Steal(lockName, ttl, "wedged")
+	if err != nil {
+		t.Fatalf("Cannot steal lock: %s", err)
+	}
+	defer stolen.Release()
+	if stolen.Token() <= held.Token() {
+		t.Errorf("Tokens should increase: %d <= %d", stolen.Token(), held.Token())
+	}
+	info, err := c2.NewLock(lockName).Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	record := "stolen by " + admin.ID() + ": wedged"
+	if !info.Acquired || info.Owner != admin.ID() || info.Data != record {
+		t.Errorf("info: %+v -- expected Owner: %s, Data: %s", info, admin.ID(), record)
+	}
+	if err = c2.NewLock(lockName).Acquire(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+
+	// The evicted holder is told its lock was stolen
+	if err = held.Refresh(); err != ErrLockStolen {
+		t.Errorf("Refreshing a stolen lock should return '%s', got '%s'", ErrLockStolen, err)
+	}
+	if err = held.Release(); err != ErrLockStolen {
+		t.Errorf("Releasing a stolen lock should return '%s', got '%s'", ErrLockStolen, err)
+	}
+
+	// Force releasing frees the lock, whoever holds it
+	if err = admin.ForceRelease(lockName, "maintenance"); err != nil {
+		t.Fatalf("Cannot force release lock: %s", err)
+	}
+	if err = stolen.Refresh(); err != ErrLockStolen {
+		t.Errorf("Refreshing a released lock should return '%s', got '%s'", ErrLockStolen, err)
+	}
+	info, err = c2.NewLock(lockName).Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	record = "released by " + admin.ID() + ": maintenance"
+	if info.Acquired || info.Data != record {
+		t.Errorf("info: %+v -- expected Acquired: false, Data: %s", info, record)
+	}
+
+	// Shared holders are evicted too
+	if rwclient, ok := c2.(RWClient); ok {
+		shared := rwclient.NewRWLock(lockName)
+		if err = shared.AcquireShared(ttl); err != nil {
+			t.Fatalf("Cannot acquire shared lock: %s", err)
+		}
+		if err = admin.ForceRelease(lockName, "maintenance"); err != nil {
+			t.Fatalf("Cannot force release lock: %s", err)
+		}
+		if err = shared.Refresh(); err != ErrLockStolen {
+			t.Errorf("Refreshing a released shared lock should return '%s', got '%s'", ErrLockStolen, err)
+		}
+	}
+
+	// Evicted holders can acquire the lock again
+	if err = held.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	if err = held.Refresh(); err != nil {
+		t.Errorf("Cannot refresh lock: %s", err)
+	}
+	if err = held.Release(); err != nil {
+		t.Errorf("Cannot release lock: %s", err)
+	}
+	if err = held.Refresh(); err != ErrLockNotOwned {
+		t.Errorf("Refreshing a released lock should return '%s', got '%s'", ErrLockNotOwned, err)
+	}
+}
@@ -0,0 +1,82 @@
+package glock
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the time and runs timers for the memory driver and the
+// LockManager, so that tests can control the passing of time. SystemClock
+// is used by default; glocktest.FakeClock is a clock advanced by hand.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a timer which sends the current time on its channel
+	// after d
+	NewTimer(d time.Duration) Timer
+	// AfterFunc calls f in its own goroutine after d
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a single event of a Clock, like time.Timer
+type Timer interface {
+	// C returns the channel the time is sent on, nil for timers returned
+	// by AfterFunc
+	C() <-chan time.Time
+	// Stop stops the timer, and returns false if it fired or was stopped
+	// already
+	Stop() bool
+	// Reset changes the timer to fire after d, and returns false if it
+	// fired or was stopped already
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the clock of the system, backed by package time
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+func (t systemTimer) Reset(d time.Duration) bool {
+	return t.t.Reset(d)
+}
+
+// clockOrSystem returns c, or SystemClock if c is nil
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// withDeadline is like context.WithDeadline, for a deadline of the given
+// clock: the context is done once as much time passed on the system clock
+// as there is left until the deadline on c.
+func withDeadline(ctx context.Context, c Clock, deadline time.Time) (context.Context, context.CancelFunc) {
+	if c == SystemClock {
+		return context.WithDeadline(ctx, deadline)
+	}
+	return context.WithTimeout(ctx, deadline.Sub(c.Now()))
+}
@@ -0,0 +1,55 @@
+// +build consul
+
+package glock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"gopkg.in/gbagnoli/glock.v1"
+	"gopkg.in/gbagnoli/glock.v1/glocktest"
+)
+
+// newFakeConsulClient returns a new client of the fake consul at address
+func newFakeConsulClient(t *testing.T, address string) *glock.ConsulClient {
+	c, err := glock.NewConsulClient(glock.ConsulOptions{
+		Address:  address,
+		Scheme:   "http",
+		ClientID: gocql.TimeUUID().String(),
+	})
+	if err != nil {
+		t.Fatalf("Cannot create consul client: %s", err)
+	}
+	return c
+}
+
+func TestConsulSessionExpiry(t *testing.T) {
+	clock := glocktest.NewFakeClock(time.Now())
+	server := glock.StartFakeConsul(clock)
+	defer server.Close()
+	address := server.Listener.Addr().String()
+	lock1 := newFakeConsulClient(t, address).NewLock("session-expiry")
+	lock2 := newFakeConsulClient(t, address).NewLock("session-expiry")
+
+	ttl := 10 * time.Second
+	if err := lock1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	clock.Advance(ttl - time.Second)
+	if err := lock2.Acquire(ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Fatalf("Acquire of a held lock, expected '%s', got '%v'", glock.ErrLockHeldByOtherClient, err)
+	}
+
+	// The lock expires with the session of lock1, as invalidated by consul
+	clock.Advance(2 * time.Second)
+	if err := lock2.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire expired lock: %s", err)
+	}
+	if err := lock1.Release(); err != glock.ErrLockNotOwned {
+		t.Errorf("Release of an expired lock, expected '%s', got '%v'", glock.ErrLockNotOwned, err)
+	}
+	if err := lock2.Release(); err != nil {
+		t.Errorf("Cannot release lock: %s", err)
+	}
+}
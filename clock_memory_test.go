@@ -0,0 +1,135 @@
+// +build memory
+
+package glock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"gopkg.in/gbagnoli/glock.v1"
+	"gopkg.in/gbagnoli/glock.v1/glocktest"
+)
+
+// newFakeClient returns a new client of store
+func newFakeClient(store *glock.MemoryStore) *glock.MemoryClient {
+	return store.NewClient(gocql.TimeUUID().String())
+}
+
+func TestMemoryFakeClockExpiry(t *testing.T) {
+	clock := glocktest.NewFakeClock(time.Now())
+	store := glock.NewMemoryStoreWithClock(clock)
+	lock1 := newFakeClient(store).NewLock("fakeclock-expiry")
+	lock2 := newFakeClient(store).NewLock("fakeclock-expiry")
+
+	if err := lock1.Acquire(time.Hour); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	clock.Advance(time.Hour - time.Minute)
+	info, err := lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if !info.Acquired || info.TTL != time.Minute {
+		t.Errorf("info: %+v -- expected Acquired: true and TTL %v", info, time.Minute)
+	}
+	if err = lock2.Acquire(time.Hour); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%v'", glock.ErrLockHeldByOtherClient, err)
+	}
+
+	// the lease is extended from the time of the refresh
+	if err = lock1.Refresh(); err != nil {
+		t.Fatalf("Cannot refresh lock: %s", err)
+	}
+	clock.Advance(time.Hour - time.Nanosecond)
+	if err = lock2.Acquire(time.Hour); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%v'", glock.ErrLockHeldByOtherClient, err)
+	}
+	clock.Advance(time.Nanosecond)
+	if err = lock2.Acquire(time.Hour); err != nil {
+		t.Fatalf("Cannot acquire expired lock: %s", err)
+	}
+	if err = lock1.Refresh(); err != glock.ErrLockNotOwned {
+		t.Errorf("Expected error '%s', got '%v'", glock.ErrLockNotOwned, err)
+	}
+	lock2.Release()
+}
+
+func TestMemoryFakeClockManagerMaxWait(t *testing.T) {
+	const lockName = "fakeclock-maxwait"
+	clock := glocktest.NewFakeClock(time.Now())
+	store := glock.NewMemoryStoreWithClock(clock)
+	m1 := glock.NewLockManager(newFakeClient(store), glock.AcquireOptions{TTL: time.Hour})
+	m2 := glock.NewLockManagerWithClock(newFakeClient(store), glock.AcquireOptions{TTL: time.Hour}, clock)
+	if err := m1.Acquire(lockName, glock.AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	defer m1.ReleaseAll()
+
+	acquire := func(maxWait time.Duration) <-chan error {
+		done := make(chan error, 1)
+		go func() { done <- m2.Acquire(lockName, glock.AcquireOptions{MaxWait: maxWait}) }()
+		// the expiry of the lock, the watch of m2 and its wait
+		clock.BlockUntil(3)
+		return done
+	}
+
+	// m2 gives up after MaxWait, however long it takes in real time
+	done := acquire(10 * time.Minute)
+	clock.Advance(10*time.Minute - time.Nanosecond)
+	select {
+	case err := <-done:
+		t.Fatalf("Acquisition returned before MaxWait: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Nanosecond)
+	if err := <-done; err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%v'", glock.ErrLockHeldByOtherClient, err)
+	}
+
+	// m2 gets the lock once it expires
+	done = acquire(time.Hour)
+	clock.Advance(50 * time.Minute)
+	if err := <-done; err != nil {
+		t.Fatalf("Cannot acquire lock after it expired: %s", err)
+	}
+	m2.ReleaseAll()
+}
+
+func TestMemoryFakeClockKeeper(t *testing.T) {
+	const lockName = "fakeclock-keeper"
+	clock := glocktest.NewFakeClock(time.Now())
+	store := glock.NewMemoryStoreWithClock(clock)
+	m1 := glock.NewLockManagerWithClock(newFakeClient(store), glock.AcquireOptions{TTL: time.Hour}, clock)
+	lock2 := newFakeClient(store).NewLock(lockName)
+	if err := m1.Acquire(lockName, glock.AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	defer m1.ReleaseAll()
+
+	events := make(chan glock.LeaseEvent, 10)
+	_, err := m1.KeepLease(lockName, glock.KeeperOptions{
+		Interval: 20 * time.Minute,
+		OnEvent:  func(ev glock.LeaseEvent) { events <- ev },
+	})
+	if err != nil {
+		t.Fatalf("Cannot keep lease: %s", err)
+	}
+
+	// the lease outlives the TTL, refreshed every interval
+	for i := 0; i < 6; i++ {
+		// the expiry of the lock and the sleep of the keeper
+		clock.BlockUntil(2)
+		clock.Advance(20 * time.Minute)
+		ev := <-events
+		if ev.Type != glock.LeaseRefreshed {
+			t.Fatalf("Expected event '%s', got '%s': %v", glock.LeaseRefreshed, ev.Type, ev.Err)
+		}
+		if expires := clock.Now().Add(time.Hour); !ev.Expires.Equal(expires) {
+			t.Errorf("Lease expires at %s, expected %s", ev.Expires, expires)
+		}
+	}
+	if err = lock2.Acquire(time.Hour); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%v'", glock.ErrLockHeldByOtherClient, err)
+	}
+}
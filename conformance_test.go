@@ -0,0 +1,23 @@
+package glock_test
+
+import (
+	"sort"
+	"testing"
+
+	"gopkg.in/gbagnoli/glock.v1"
+	"gopkg.in/gbagnoli/glock.v1/glocktest"
+)
+
+func TestConformance(t *testing.T) {
+	var names []string
+	for name := range glock.Drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		driver := glock.Drivers[name]
+		t.Run(name, func(t *testing.T) {
+			glocktest.Run(t, driver.NewClient, driver.Scale)
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package glock
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gocql/gocql"
@@ -9,13 +12,37 @@ import (
 
 const (
 	createKs    = `CREATE KEYSPACE IF NOT EXISTS %s WITH REPLICATION = { 'class' : 'SimpleStrategy', 'replication_factor' : %d } AND DURABLE_WRITES=true`
-	createTable = `CREATE TABLE IF NOT EXISTS %s.%s (name text PRIMARY KEY, owner text, data text)`
-	acquireQ    = `INSERT INTO %s.%s (name, owner, data) VALUES (?, ?, ?) IF NOT EXISTS USING TTL %d`
-	releaseQ    = `DELETE FROM %s.%s WHERE name = ? IF owner = ?`
+	createTable = `CREATE TABLE IF NOT EXISTS %s.%s (name text PRIMARY KEY, owner text, data text, token bigint, readers map<text, timestamp>, slots map<text, timestamp>)`
+	addColumnQ  = `ALTER TABLE %s.%s ADD %s %s`
+	tokenQ      = `SELECT token FROM %s.%s WHERE name = ?`
+	acquireQ    = `UPDATE %s.%s USING TTL %d SET owner = ?, data = ? WHERE name = ? IF owner = null AND readers = null`
+	setTokenQ   = `UPDATE %s.%s SET token = ? WHERE name = ? IF token = ?`
+	releaseQ    = `DELETE owner, data FROM %s.%s WHERE name = ? IF owner = ?`
 	refreshQ    = `UPDATE %s.%s USING TTL %d set owner = ?, data = ? WHERE name = ? IF owner = ?`
-	infoQ       = `SELECT owner, TTL(owner), data FROM %s.%s WHERE name = ?`
+	infoQ       = `SELECT owner, TTL(owner), data, token, readers FROM %s.%s WHERE name = ?`
+	listQ       = `SELECT name, owner, TTL(owner), data, token, readers FROM %s.%s`
+
+	// Shared holders are kept in the readers map, each element with its own TTL
+	// and the expiration time as value.
+	acquireSharedQ = `UPDATE %s.%s USING TTL %d SET readers[?] = ? WHERE name = ? IF owner = null`
+	refreshSharedQ = `UPDATE %s.%s USING TTL %d SET readers[?] = ? WHERE name = ? IF readers[?] != null`
+	releaseSharedQ = `DELETE readers[?] FROM %s.%s WHERE name = ? IF readers[?] != null`
+
+	// Semaphore slots are kept in the slots map, in the same way as shared holders.
+	semAcquireQ = `UPDATE %s.%s USING TTL %d SET slots[?] = ? WHERE name = ? IF slots = ?`
+	semRefreshQ = `UPDATE %s.%s USING TTL %d SET slots[?] = ? WHERE name = ? IF slots[?] != null`
+	semReleaseQ = `DELETE slots[?] FROM %s.%s WHERE name = ? IF slots[?] != null`
+	semInfoQ    = `SELECT slots FROM %s.%s WHERE name = ?`
 )
 
+// columns added to the locks table after its first release, with their type.
+// They are added to existing tables when the client is created.
+var addedColumns = [][2]string{
+	{"token", "bigint"},
+	{"readers", "map<text, timestamp>"},
+	{"slots", "map<text, timestamp>"},
+}
+
 // CassandraOptions represents options for connecting to cassandra
 type CassandraOptions struct {
 	Hosts             []string
@@ -24,9 +51,14 @@ type CassandraOptions struct {
 	Password          string
 	TableName         string
 	ReplicationFactor int
+	// Dialer, if set, opens the connections to the hosts, e.g. through a proxy
+	Dialer gocql.Dialer
 }
 
-// CassandraClient is the Client implementation for cassandra
+// CassandraClient is the Client implementation for cassandra.
+// It does not implement MultiClient: each lock is a partition of its own,
+// keyed by name, and conditional batches cannot span several partitions, so
+// LockManager.AcquireAll acquires the locks one at a time.
 type CassandraClient struct {
 	cluster      *gocql.ClusterConfig
 	hosts        []string
@@ -36,6 +68,7 @@ type CassandraClient struct {
 	session      *gocql.Session
 	protoVersion int
 	consistency  gocql.Consistency
+	dialer       gocql.Dialer
 }
 
 // CassandraLock is the Lock implementation for cassandra
@@ -45,6 +78,21 @@ type CassandraLock struct {
 	ttl    time.Duration
 	client *CassandraClient
 	data   string
+	token  uint64
+}
+
+// CassandraRWLock is the RWLock implementation for cassandra
+type CassandraRWLock struct {
+	*CassandraLock
+}
+
+// CassandraSemaphore is the Semaphore implementation for cassandra
+type CassandraSemaphore struct {
+	name   string
+	owner  string
+	slots  int
+	ttl    time.Duration
+	client *CassandraClient
 }
 
 // NewCassandraLockClient creates a new client from options
@@ -62,9 +110,10 @@ func NewCassandraLockClient(opts CassandraOptions) (*CassandraClient, error) {
 	var err error
 	var c CassandraClient
 	for proto := 4; proto > 1; proto-- {
-		c = CassandraClient{nil, opts.Hosts, "", "", "", nil, proto, consistency}
+		c = CassandraClient{nil, opts.Hosts, "", "", "", nil, proto, consistency, opts.Dialer}
 		c.cluster = gocql.NewCluster(opts.Hosts...)
 		c.cluster.ProtoVersion = proto
+		c.cluster.Dialer = opts.Dialer
 		session, err = c.cluster.CreateSession()
 		if err == nil {
 			break
@@ -87,6 +136,22 @@ func NewCassandraLockClient(opts CassandraOptions) (*CassandraClient, error) {
 		return nil, err
 	}
 
+	ks, err := session.KeyspaceMetadata(opts.KeySpace)
+	if err != nil {
+		return nil, err
+	}
+	if table, ok := ks.Tables[opts.TableName]; ok {
+		for _, column := range addedColumns {
+			if _, ok := table.Columns[column[0]]; ok {
+				continue
+			}
+			err = session.Query(fmt.Sprintf(addColumnQ, opts.KeySpace, opts.TableName, column[0], column[1])).Exec()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	id, err := gocql.RandomUUID()
 	if err != nil {
 		return nil, err
@@ -111,22 +176,57 @@ func (c *CassandraClient) Clone() Client {
 		clientID:    c.clientID,
 		session:     nil,
 		consistency: c.consistency,
+		dialer:      c.dialer,
 	}
 }
 
 // Reconnect reconnects to cassandra, or connects if not connected
 func (c *CassandraClient) Reconnect() error {
+	return c.ReconnectContext(context.Background())
+}
+
+// ReconnectContext reconnects to cassandra, or connects if not connected.
+// It returns as soon as ctx is done, even if the session is still being created.
+func (c *CassandraClient) ReconnectContext(ctx context.Context) error {
+	type sessionResult struct {
+		session *gocql.Session
+		err     error
+	}
+
 	c.Close()
+	c.session = nil
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	c.cluster = gocql.NewCluster(c.hosts...)
 	c.cluster.Keyspace = c.keyspace
 	c.cluster.Consistency = c.consistency
 	c.cluster.ProtoVersion = c.protoVersion
-	session, err := c.cluster.CreateSession()
-	if err != nil {
-		return err
+	c.cluster.Dialer = c.dialer
+	cluster := c.cluster
+	res := make(chan sessionResult, 1)
+	go func() {
+		session, err := cluster.CreateSession()
+		res <- sessionResult{session, err}
+	}()
+
+	select {
+	case r := <-res:
+		if r.err != nil {
+			return r.err
+		}
+		c.session = r.session
+		return nil
+
+	case <-ctx.Done():
+		// do not leak the session if it gets created later on
+		go func() {
+			if r := <-res; r.err == nil {
+				r.session.Close()
+			}
+		}()
+		return ctx.Err()
 	}
-	c.session = session
-	return nil
 }
 
 // SetID sets the ID for the current client
@@ -146,6 +246,38 @@ func (c *CassandraClient) Close() {
 	}
 }
 
+// List returns the information of all the live locks whose name starts with
+// prefix, sorted by name.
+func (c *CassandraClient) List(prefix string) ([]LockInfo, error) {
+	return c.ListContext(context.Background(), prefix)
+}
+
+// ListContext is like List, but aborts the query when ctx is done.
+// Lock names are the partition keys of the table, so the whole table is
+// scanned and the names are matched by the client.
+func (c *CassandraClient) ListContext(ctx context.Context, prefix string) ([]LockInfo, error) {
+	var ttl int
+	var token int64
+	var name, owner, data string
+	var readers map[string]time.Time
+
+	var infos []LockInfo
+	iter := c.session.Query(fmt.Sprintf(listQ, c.keyspace, c.table)).WithContext(ctx).Iter()
+	for iter.Scan(&name, &owner, &ttl, &data, &token, &readers) {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if info := cassandraInfo(name, owner, ttl, data, token, readers); info.Acquired {
+			infos = append(infos, *info)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
 // NewLock creates a new Lock. Lock is not automatically acquired.
 func (c *CassandraClient) NewLock(name string) Lock {
 	return &CassandraLock{
@@ -156,34 +288,113 @@ func (c *CassandraClient) NewLock(name string) Lock {
 	}
 }
 
+// NewRWLock creates a new RWLock. Lock is not automatically acquired.
+func (c *CassandraClient) NewRWLock(name string) RWLock {
+	return &CassandraRWLock{&CassandraLock{
+		name:   name,
+		owner:  c.clientID,
+		ttl:    time.Duration(0),
+		client: c,
+	}}
+}
+
+// NewSemaphore creates a new Semaphore with the given number of slots.
+// No slot is automatically acquired.
+func (c *CassandraClient) NewSemaphore(name string, slots int) Semaphore {
+	return &CassandraSemaphore{
+		name:   name,
+		owner:  c.clientID,
+		slots:  slots,
+		ttl:    time.Duration(0),
+		client: c,
+	}
+}
+
 // Acquire acquires the lock for the specified time lentgh (ttl).
 // It returns immadiately if the lock cannot be acquired
 func (l *CassandraLock) Acquire(ttl time.Duration) error {
-	var name, owner, data string
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext is like Acquire, but aborts the query when ctx is done.
+//
+// The owner and data columns expire with the lock, while the token column is
+// written without a TTL. Both are updated in a single conditional batch, so the
+// fencing token is bumped if and only if the lock is acquired.
+func (l *CassandraLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
 	if ttl < time.Second {
 		return ErrInvalidTTL
 	}
 	l.ttl = ttl
-	query := fmt.Sprintf(acquireQ, l.client.keyspace, l.client.table, int(ttl.Seconds()))
-	applied, err := l.client.session.Query(query, l.name, l.owner, l.data).ScanCAS(&name, &owner, &data)
-	if err != nil {
-		return err
-	}
-	if !applied && owner != l.owner {
-		return ErrLockHeldByOtherClient
-	}
+	for {
+		var current int64
+		query := fmt.Sprintf(tokenQ, l.client.keyspace, l.client.table)
+		err := l.client.session.Query(query, l.name).SerialConsistency(gocql.Serial).WithContext(ctx).Scan(&current)
+		if err != nil && err != gocql.ErrNotFound {
+			return contextError(ctx, err)
+		}
+		var expected interface{}
+		if current > 0 {
+			expected = current
+		}
 
-	return nil
+		batch := l.client.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+		batch.Query(fmt.Sprintf(acquireQ, l.client.keyspace, l.client.table, int(ttl.Seconds())),
+			l.owner, l.data, l.name)
+		batch.Query(fmt.Sprintf(setTokenQ, l.client.keyspace, l.client.table),
+			current+1, l.name, expected)
+		res := make(map[string]interface{})
+		applied, _, err := l.client.session.MapExecuteBatchCAS(batch, res)
+		if err != nil {
+			return contextError(ctx, err)
+		}
+		if applied {
+			l.token = uint64(current + 1)
+			return nil
+		}
+
+		owner, _ := res["owner"].(string)
+		readers, _ := res["readers"].(map[string]time.Time)
+		switch {
+		case owner == "" && len(readers) > 0:
+			return ErrLockHeldByOtherClient
+		case owner == "":
+			// the lock is free, but someone else bumped the token in the meantime
+			continue
+		case owner == l.owner:
+			return nil
+		default:
+			return ErrLockHeldByOtherClient
+		}
+	}
 }
 
 // Release releases the lock if owned. Returns an error if the lock is not owned by this client
 func (l *CassandraLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext is like Release, but aborts the query when ctx is done.
+func (l *CassandraLock) ReleaseContext(ctx context.Context) error {
 	var res string
 	query := fmt.Sprintf(releaseQ, l.client.keyspace,
 		l.client.table)
-	applied, err := l.client.session.Query(query, l.name, l.owner).ScanCAS(&res)
+	applied, err := l.client.session.Query(query, l.name, l.owner).WithContext(ctx).ScanCAS(&res)
 	if err != nil {
-		return err
+		return contextError(ctx, err)
+	}
+	if !applied {
+		return l.releaseShared(ctx)
+	}
+	return nil
+}
+
+// releaseShared releases the lock if held in shared mode by this client.
+func (l *CassandraLock) releaseShared(ctx context.Context) error {
+	query := fmt.Sprintf(releaseSharedQ, l.client.keyspace, l.client.table)
+	applied, err := l.client.session.Query(query, l.owner, l.name, l.owner).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return contextError(ctx, err)
 	}
 	if !applied {
 		return ErrLockNotOwned
@@ -193,24 +404,51 @@ func (l *CassandraLock) Release() error {
 
 // Info returns information about the lock.
 func (l *CassandraLock) Info() (*LockInfo, error) {
+	return l.InfoContext(context.Background())
+}
+
+// InfoContext is like Info, but aborts the query when ctx is done.
+func (l *CassandraLock) InfoContext(ctx context.Context) (*LockInfo, error) {
 	var ttl int
+	var token int64
 	var owner, data string
+	var readers map[string]time.Time
 
 	query := fmt.Sprintf(infoQ, l.client.keyspace, l.client.table)
-	err := l.client.session.Query(query, l.name).SerialConsistency(gocql.Serial).Scan(&owner, &ttl, &data)
+	err := l.client.session.Query(query, l.name).SerialConsistency(gocql.Serial).WithContext(ctx).Scan(&owner, &ttl, &data, &token, &readers)
 	if err == gocql.ErrNotFound {
-		return &LockInfo{l.name, false, "", time.Duration(0), ""}, nil
+		return &LockInfo{Name: l.name}, nil
 	}
 	if err != nil {
-		return nil, err
+		return nil, contextError(ctx, err)
+	}
+	return cassandraInfo(l.name, owner, ttl, data, token, readers), nil
+}
+
+// cassandraInfo returns the information of a lock from its row
+func cassandraInfo(name, owner string, ttl int, data string, token int64, readers map[string]time.Time) *LockInfo {
+	if owner == "" && len(readers) > 0 {
+		info := &LockInfo{Name: name, Acquired: true, Shared: true}
+		for id, expire := range readers {
+			info.Holders = append(info.Holders, id)
+			if ttl := expire.Sub(time.Now()); ttl > info.TTL {
+				info.TTL = ttl
+			}
+		}
+		sort.Strings(info.Holders)
+		return info
+	}
+	if ttl <= 0 {
+		token = 0
 	}
 	return &LockInfo{
-		Name:     l.name,
+		Name:     name,
 		Acquired: ttl > 0,
 		Owner:    owner,
 		TTL:      time.Duration(ttl) * time.Second,
 		Data:     data,
-	}, nil
+		Token:    uint64(token),
+	}
 }
 
 // RefreshTTL Extends the lock, if owned, for the specified TTL.
@@ -218,21 +456,44 @@ func (l *CassandraLock) Info() (*LockInfo, error) {
 // will use this ttl
 // It returns an error if the lock is not owned by the current client
 func (l *CassandraLock) RefreshTTL(ttl time.Duration) error {
+	return l.RefreshTTLContext(context.Background(), ttl)
+}
+
+// RefreshTTLContext is like RefreshTTL, but aborts the query when ctx is done.
+func (l *CassandraLock) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
 	l.ttl = ttl
-	return l.Refresh()
+	return l.RefreshContext(ctx)
 }
 
 // Refresh extends the lock by extending the TTL in the store.
 // It returns an error if the lock is not owned by the current client
 func (l *CassandraLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, but aborts the query when ctx is done.
+func (l *CassandraLock) RefreshContext(ctx context.Context) error {
 	var name string
 	if l.ttl < time.Second {
 		return ErrInvalidTTL
 	}
 	query := fmt.Sprintf(refreshQ, l.client.keyspace, l.client.table, int(l.ttl.Seconds()))
-	applied, err := l.client.session.Query(query, l.owner, l.data, l.name, l.owner).ScanCAS(&name)
+	applied, err := l.client.session.Query(query, l.owner, l.data, l.name, l.owner).WithContext(ctx).ScanCAS(&name)
 	if err != nil {
-		return err
+		return contextError(ctx, err)
+	}
+	if !applied {
+		return l.refreshShared(ctx)
+	}
+	return nil
+}
+
+// refreshShared extends the lease of this client if it holds the lock in shared mode.
+func (l *CassandraLock) refreshShared(ctx context.Context) error {
+	query := fmt.Sprintf(refreshSharedQ, l.client.keyspace, l.client.table, int(l.ttl.Seconds()))
+	applied, err := l.client.session.Query(query, l.owner, time.Now().Add(l.ttl), l.name, l.owner).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return contextError(ctx, err)
 	}
 	if !applied {
 		return ErrLockNotOwned
@@ -246,3 +507,180 @@ func (l *CassandraLock) Refresh() error {
 func (l *CassandraLock) SetData(data string) {
 	l.data = data
 }
+
+// Token returns the fencing token obtained by the last successful Acquire
+func (l *CassandraLock) Token() uint64 {
+	return l.token
+}
+
+// AcquireExclusive is the same as Acquire
+func (l *CassandraRWLock) AcquireExclusive(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireExclusiveContext is the same as AcquireContext
+func (l *CassandraRWLock) AcquireExclusiveContext(ctx context.Context, ttl time.Duration) error {
+	return l.AcquireContext(ctx, ttl)
+}
+
+// AcquireShared acquires the lock in shared mode for the specified time length (ttl).
+// It returns immediately if the lock cannot be acquired
+func (l *CassandraRWLock) AcquireShared(ttl time.Duration) error {
+	return l.AcquireSharedContext(context.Background(), ttl)
+}
+
+// AcquireSharedContext is like AcquireShared, but aborts the query when ctx is done.
+func (l *CassandraRWLock) AcquireSharedContext(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Second {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	query := fmt.Sprintf(acquireSharedQ, l.client.keyspace, l.client.table, int(ttl.Seconds()))
+	applied, err := l.client.session.Query(query, l.owner, time.Now().Add(ttl), l.name).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	if !applied {
+		return ErrLockHeldByOtherClient
+	}
+	l.token = 0
+	return nil
+}
+
+// Slots returns the number of slots of the semaphore
+func (s *CassandraSemaphore) Slots() int {
+	return s.slots
+}
+
+// Acquire acquires a slot for the specified time length (ttl).
+// It returns immediately if all the slots are taken
+func (s *CassandraSemaphore) Acquire(ttl time.Duration) error {
+	return s.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext is like Acquire, but aborts the query when ctx is done.
+//
+// The slots are counted with a serial read, and the new slot is written only
+// if the map did not change in the meantime, retrying otherwise.
+func (s *CassandraSemaphore) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if s.slots <= 0 {
+		return ErrInvalidSlots
+	}
+	if ttl < time.Second {
+		return ErrInvalidTTL
+	}
+	s.ttl = ttl
+	for {
+		var slots map[string]time.Time
+		query := fmt.Sprintf(semInfoQ, s.client.keyspace, s.client.table)
+		err := s.client.session.Query(query, s.name).SerialConsistency(gocql.Serial).WithContext(ctx).Scan(&slots)
+		if err != nil && err != gocql.ErrNotFound {
+			return contextError(ctx, err)
+		}
+		if _, ok := slots[s.owner]; !ok && len(slots) >= s.slots {
+			return ErrLockHeldByOtherClient
+		}
+		var expected interface{}
+		if len(slots) > 0 {
+			expected = slots
+		}
+
+		query = fmt.Sprintf(semAcquireQ, s.client.keyspace, s.client.table, int(ttl.Seconds()))
+		applied, err := s.client.session.Query(query, s.owner, time.Now().Add(ttl), s.name, expected).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+		if err != nil {
+			return contextError(ctx, err)
+		}
+		if applied {
+			return nil
+		}
+	}
+}
+
+// Release releases the slot if owned. Returns an error if no slot is owned by this client
+func (s *CassandraSemaphore) Release() error {
+	return s.ReleaseContext(context.Background())
+}
+
+// ReleaseContext is like Release, but aborts the query when ctx is done.
+func (s *CassandraSemaphore) ReleaseContext(ctx context.Context) error {
+	query := fmt.Sprintf(semReleaseQ, s.client.keyspace, s.client.table)
+	applied, err := s.client.session.Query(query, s.owner, s.name, s.owner).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	if !applied {
+		return ErrLockNotOwned
+	}
+	return nil
+}
+
+// RefreshTTL extends the slot, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the slot: successive calls to Refresh()
+// will use this ttl
+func (s *CassandraSemaphore) RefreshTTL(ttl time.Duration) error {
+	return s.RefreshTTLContext(context.Background(), ttl)
+}
+
+// RefreshTTLContext is like RefreshTTL, but aborts the query when ctx is done.
+func (s *CassandraSemaphore) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
+	s.ttl = ttl
+	return s.RefreshContext(ctx)
+}
+
+// Refresh extends the slot by extending its TTL in the store.
+// It returns an error if no slot is owned by the current client
+func (s *CassandraSemaphore) Refresh() error {
+	return s.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, but aborts the query when ctx is done.
+func (s *CassandraSemaphore) RefreshContext(ctx context.Context) error {
+	if s.ttl < time.Second {
+		return ErrInvalidTTL
+	}
+	query := fmt.Sprintf(semRefreshQ, s.client.keyspace, s.client.table, int(s.ttl.Seconds()))
+	applied, err := s.client.session.Query(query, s.owner, time.Now().Add(s.ttl), s.name, s.owner).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	if !applied {
+		return ErrLockNotOwned
+	}
+	return nil
+}
+
+// Info returns information about the semaphore.
+func (s *CassandraSemaphore) Info() (*LockInfo, error) {
+	return s.InfoContext(context.Background())
+}
+
+// InfoContext is like Info, but aborts the query when ctx is done.
+func (s *CassandraSemaphore) InfoContext(ctx context.Context) (*LockInfo, error) {
+	var slots map[string]time.Time
+
+	query := fmt.Sprintf(semInfoQ, s.client.keyspace, s.client.table)
+	err := s.client.session.Query(query, s.name).SerialConsistency(gocql.Serial).WithContext(ctx).Scan(&slots)
+	if err != nil && err != gocql.ErrNotFound {
+		return nil, contextError(ctx, err)
+	}
+	info := &LockInfo{Name: s.name}
+	for id, expire := range slots {
+		info.Holders = append(info.Holders, id)
+		if ttl := expire.Sub(time.Now()); info.TTL == 0 || ttl < info.TTL {
+			info.TTL = ttl
+		}
+	}
+	sort.Strings(info.Holders)
+	info.Acquired = len(info.Holders) > 0
+	info.Shared = info.Acquired
+	return info, nil
+}
+
+// SetData is a no-op, as slots carry no data.
+func (s *CassandraSemaphore) SetData(data string) {
+}
+
+// Token always returns 0, as slots get no fencing token.
+func (s *CassandraSemaphore) Token() uint64 {
+	return 0
+}
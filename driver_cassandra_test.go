@@ -13,8 +13,10 @@ var keyspace = flag.String("keyspace", "glock_test", "Cassandra keyspace")
 var username = flag.String("username", "", "Username to use when connecting")
 var password = flag.String("password", "", "Password to use when connecting")
 
-func cassandraClient(t *testing.T) Client {
-	opts := CassandraOptions{
+// CassandraTestOptions returns the options of the clients of the cassandra
+// tests, for the tests of package glock_test
+func CassandraTestOptions() CassandraOptions {
+	return CassandraOptions{
 		Hosts:             []string{*host},
 		KeySpace:          *keyspace,
 		Username:          *username,
@@ -22,17 +24,52 @@ func cassandraClient(t *testing.T) Client {
 		TableName:         "locks",
 		ReplicationFactor: 1,
 	}
-	c, err := NewCassandraLockClient(opts)
+}
+
+func cassandraClient(t *testing.T) Client {
+	c, err := NewCassandraLockClient(CassandraTestOptions())
 	if err != nil {
 		t.Fatalf("Cannot create cassandra client: %s", err)
 	}
 	return c
 }
 
+func init() {
+	Drivers["cassandra"] = Driver{cassandraClient, time.Second}
+}
+
 func TestCassandraClient(t *testing.T) {
 	testClient(t, cassandraClient)
 }
 
+func TestCassandraClientList(t *testing.T) {
+	testClientList(t, cassandraClient, time.Second)
+}
+
 func TestCassandraLock(t *testing.T) {
 	testLock(t, cassandraClient, time.Second)
 }
+
+func TestCassandraLockContext(t *testing.T) {
+	testLockContext(t, cassandraClient, time.Second)
+}
+
+func TestCassandraLockToken(t *testing.T) {
+	testLockToken(t, cassandraClient, time.Second)
+}
+
+func TestCassandraRWLock(t *testing.T) {
+	testRWLock(t, cassandraClient, time.Second)
+}
+
+func TestCassandraManagerShared(t *testing.T) {
+	testManagerShared(t, cassandraClient, time.Second)
+}
+
+func TestCassandraSemaphore(t *testing.T) {
+	testSemaphore(t, cassandraClient, time.Second)
+}
+
+func TestCassandraManagerSemaphore(t *testing.T) {
+	testManagerSemaphore(t, cassandraClient, time.Second)
+}
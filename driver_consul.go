@@ -0,0 +1,439 @@
+package glock
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/hashicorp/consul/api"
+)
+
+// ConsulOptions represents options for connecting to consul
+type ConsulOptions struct {
+	// Address of the consul agent, i.e. 'localhost:8500'.
+	// If not set, the consul default is used
+	Address string
+	// Scheme is the URI scheme of the agent, 'http' or 'https'.
+	// If not set, the consul default is used
+	Scheme string
+	// Datacenter to use. If not set, the datacenter of the agent is used
+	Datacenter string
+	// Token is the ACL token used for the requests
+	Token string
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+	// Namespace is an optional prefix for all the keys that will be created.
+	// It must contain the separator (if any). If not set, the default value
+	// is used "glock/"
+	Namespace string
+	// LockDelay is how long consul prevents a lock from being acquired again
+	// after the session holding it is invalidated. If not set, it defaults to
+	// 1ms, the smallest delay accepted by consul.
+	LockDelay time.Duration
+}
+
+// ConsulClient implements the Client interface to manage locks in consul.
+// It can be used by multiple goroutines.
+type ConsulClient struct {
+	client *api.Client
+	opts   ConsulOptions
+	// mtx protects client
+	mtx sync.Mutex
+}
+
+// ConsulLock implements the Lock interface for locks in consul.
+//
+// Every acquisition creates a session with the lock TTL, named after the
+// client ID, and acquires the lock key with it. The key holds the data of the
+// lock. The lock expires with its session: consul releases the key when the
+// session is not renewed in time, which might take up to twice the TTL, and
+// then keeps it from being acquired again for the LockDelay of the session.
+// Info reports the TTL of the session, as consul does not tell how much of
+// it is left, so watchers might report an expired lock as released.
+// The fencing token is the lock index of the key.
+//
+// Consul does not accept session TTLs shorter than 10 seconds: shorter TTLs
+// are rejected with ErrInvalidTTL.
+type ConsulLock struct {
+	name   string
+	ttl    time.Duration
+	client *ConsulClient
+	data   string
+	token  uint64
+}
+
+// NewConsulClient returns a new ConsulClient given the provided ConsulOptions
+func NewConsulClient(opts ConsulOptions) (*ConsulClient, error) {
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "glock/"
+	}
+	if opts.LockDelay <= 0 {
+		opts.LockDelay = time.Millisecond
+	}
+	c := &ConsulClient{opts: opts}
+	err := c.Reconnect()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Clone returns a disconnected copy of the currenct client
+func (c *ConsulClient) Clone() Client {
+	return &ConsulClient{
+		client: nil,
+		opts:   c.opts,
+	}
+}
+
+// Close drops the consul client. Sessions are not destroyed, so the
+// locks held by the client are kept until they expire.
+func (c *ConsulClient) Close() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.close()
+}
+
+func (c *ConsulClient) close() {
+	c.client = nil
+}
+
+// Reconnect reconnects to consul, or connects if not connected
+func (c *ConsulClient) Reconnect() error {
+	return c.ReconnectContext(context.Background())
+}
+
+// ReconnectContext reconnects to consul, or connects if not connected.
+// Consul is accessed over HTTP, so no connection is established until the
+// first request: ctx is only checked before creating the client.
+func (c *ConsulClient) ReconnectContext(ctx context.Context) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.reconnect(ctx)
+}
+
+func (c *ConsulClient) reconnect(ctx context.Context) error {
+	c.close()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	config := api.DefaultConfig()
+	if c.opts.Address != "" {
+		config.Address = c.opts.Address
+	}
+	if c.opts.Scheme != "" {
+		config.Scheme = c.opts.Scheme
+	}
+	if c.opts.Datacenter != "" {
+		config.Datacenter = c.opts.Datacenter
+	}
+	if c.opts.Token != "" {
+		config.Token = c.opts.Token
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		return err
+	}
+	c.client = client
+	return nil
+}
+
+// conn returns the consul client, connecting if not connected
+func (c *ConsulClient) conn(ctx context.Context) (*api.Client, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.client == nil {
+		if err := c.reconnect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c.client, nil
+}
+
+// newSession creates a session for a lock with the given ttl.
+// ErrInvalidTTL is returned if consul rejects the ttl.
+func (c *ConsulClient) newSession(ctx context.Context, cli *api.Client, ttl time.Duration) (string, error) {
+	entry := &api.SessionEntry{
+		Name:      c.opts.ClientID,
+		TTL:       ttl.String(),
+		Behavior:  api.SessionBehaviorRelease,
+		LockDelay: c.opts.LockDelay,
+	}
+	id, _, err := cli.Session().Create(entry, consulWrite(ctx))
+	if serr, ok := err.(api.StatusError); ok && serr.Code == http.StatusBadRequest &&
+		strings.Contains(serr.Body, "Session TTL") {
+		return "", ErrInvalidTTL
+	}
+	return id, contextError(ctx, err)
+}
+
+// consulQuery returns the options for consistent reads bound to ctx
+func consulQuery(ctx context.Context) *api.QueryOptions {
+	return (&api.QueryOptions{RequireConsistent: true}).WithContext(ctx)
+}
+
+// consulWrite returns the options for writes bound to ctx
+func consulWrite(ctx context.Context) *api.WriteOptions {
+	return (&api.WriteOptions{}).WithContext(ctx)
+}
+
+// SetID sets the ID for the current client
+func (c *ConsulClient) SetID(id string) {
+	c.opts.ClientID = id
+}
+
+// ID returns the current client ID
+func (c *ConsulClient) ID() string {
+	return c.opts.ClientID
+}
+
+// NewLock creates a new Lock. Lock is not automatically acquired.
+func (c *ConsulClient) NewLock(name string) Lock {
+	return &ConsulLock{
+		name:   name,
+		ttl:    time.Duration(0),
+		client: c,
+	}
+}
+
+func (l *ConsulLock) key() string {
+	return l.client.opts.Namespace + l.name
+}
+
+// pair returns the content of the lock key when held by session
+func (l *ConsulLock) pair(session string) *api.KVPair {
+	return &api.KVPair{
+		Key:     l.key(),
+		Value:   []byte(l.data),
+		Session: session,
+	}
+}
+
+// holder returns the lock key and the session holding it,
+// or nil if the lock is not held
+func (l *ConsulLock) holder(ctx context.Context, cli *api.Client) (*api.KVPair, *api.SessionEntry, error) {
+	kv, _, err := cli.KV().Get(l.key(), consulQuery(ctx))
+	if err != nil {
+		return nil, nil, contextError(ctx, err)
+	}
+	if kv == nil || kv.Session == "" {
+		return nil, nil, nil
+	}
+	session, _, err := cli.Session().Info(kv.Session, consulQuery(ctx))
+	if err != nil {
+		return nil, nil, contextError(ctx, err)
+	}
+	if session == nil {
+		// the session has just been invalidated, the key is about to be released
+		return nil, nil, nil
+	}
+	return kv, session, nil
+}
+
+// Acquire acquires the lock for the specified time lentgh (ttl).
+// It returns immadiately if the lock cannot be acquired
+func (l *ConsulLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext is like Acquire, but aborts the requests to consul when ctx is done.
+func (l *ConsulLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	l.ttl = ttl
+	cli, err := l.client.conn(ctx)
+	if err != nil {
+		return err
+	}
+	// do not create a session for a lock held already
+	held, _, err := l.holder(ctx, cli)
+	if err != nil {
+		return err
+	}
+	if held != nil {
+		return ErrLockHeldByOtherClient
+	}
+	session, err := l.client.newSession(ctx, cli, ttl)
+	if err != nil {
+		return err
+	}
+	kv := l.pair(session)
+	ok, _, err := cli.KV().Acquire(kv, consulWrite(ctx))
+	if err == nil && !ok {
+		err = ErrLockHeldByOtherClient
+	}
+	if err == nil {
+		// the lock index is not returned by the acquisition, read it back
+		kv, _, err = cli.KV().Get(l.key(), consulQuery(ctx))
+		if err == nil && (kv == nil || kv.Session != session) {
+			err = ErrLockHeldByOtherClient
+		}
+	}
+	if err != nil {
+		// destroying the session releases the lock, if it was acquired anyway
+		cli.Session().Destroy(session, consulWrite(ctx))
+		return contextError(ctx, err)
+	}
+	l.token = kv.LockIndex
+	return nil
+}
+
+// Release releases the lock if owned. Returns an error if the lock is not owned by this client
+func (l *ConsulLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext is like Release, but aborts the requests to consul when ctx is done.
+func (l *ConsulLock) ReleaseContext(ctx context.Context) error {
+	cli, err := l.client.conn(ctx)
+	if err != nil {
+		return err
+	}
+	kv, session, err := l.holder(ctx, cli)
+	if err != nil {
+		return err
+	}
+	if kv == nil || session.Name != l.client.ID() {
+		return ErrLockNotOwned
+	}
+	ok, _, err := cli.KV().Release(&api.KVPair{Key: l.key(), Session: session.ID}, consulWrite(ctx))
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	if !ok {
+		return ErrLockNotOwned
+	}
+	// the lock is released already, destroying the session is just housekeeping
+	cli.Session().Destroy(session.ID, consulWrite(ctx))
+	return nil
+}
+
+// RefreshTTL extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
+func (l *ConsulLock) RefreshTTL(ttl time.Duration) error {
+	return l.RefreshTTLContext(context.Background(), ttl)
+}
+
+// RefreshTTLContext is like RefreshTTL, but aborts the requests to consul when ctx is done.
+func (l *ConsulLock) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
+	l.ttl = ttl
+	return l.RefreshContext(ctx)
+}
+
+// Refresh extends the lock by renewing its session, and updates its data.
+// The TTL of a session cannot be changed, so if the TTL changed the lock is
+// handed over to a new session instead.
+// It returns an error if the lock is not owned by the current client
+func (l *ConsulLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, but aborts the requests to consul when ctx is done.
+func (l *ConsulLock) RefreshContext(ctx context.Context) error {
+	cli, err := l.client.conn(ctx)
+	if err != nil {
+		return err
+	}
+	kv, session, err := l.holder(ctx, cli)
+	if err != nil {
+		return err
+	}
+	if kv == nil || session.Name != l.client.ID() {
+		return ErrLockNotOwned
+	}
+
+	if session.TTL == l.ttl.String() {
+		renewed, _, err := cli.Session().Renew(session.ID, consulWrite(ctx))
+		if err != nil {
+			return contextError(ctx, err)
+		}
+		if renewed == nil {
+			return ErrLockNotOwned
+		}
+		ok, _, err := cli.KV().Acquire(l.pair(session.ID), consulWrite(ctx))
+		if err == nil && !ok {
+			err = ErrLockNotOwned
+		}
+		return contextError(ctx, err)
+	}
+
+	newSession, err := l.client.newSession(ctx, cli, l.ttl)
+	if err != nil {
+		return err
+	}
+	ops := api.TxnOps{
+		{KV: &api.KVTxnOp{Verb: api.KVCheckSession, Key: l.key(), Session: session.ID}},
+		{KV: &api.KVTxnOp{Verb: api.KVUnlock, Key: l.key(), Session: session.ID}},
+		{KV: &api.KVTxnOp{Verb: api.KVLock, Key: l.key(), Value: []byte(l.data), Session: newSession}},
+	}
+	ok, resp, _, err := cli.Txn().Txn(ops, consulQuery(ctx))
+	if err == nil && !ok {
+		err = ErrLockNotOwned
+	}
+	if err != nil {
+		cli.Session().Destroy(newSession, consulWrite(ctx))
+		return contextError(ctx, err)
+	}
+	cli.Session().Destroy(session.ID, consulWrite(ctx))
+	// locking the key with another session bumps its lock index
+	for _, r := range resp.Results {
+		if r.KV != nil && r.KV.Session == newSession {
+			l.token = r.KV.LockIndex
+		}
+	}
+	return nil
+}
+
+// Info returns information about the lock.
+func (l *ConsulLock) Info() (*LockInfo, error) {
+	return l.InfoContext(context.Background())
+}
+
+// InfoContext is like Info, but aborts the requests to consul when ctx is done.
+func (l *ConsulLock) InfoContext(ctx context.Context) (*LockInfo, error) {
+	cli, err := l.client.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kv, session, err := l.holder(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+	if kv == nil {
+		return &LockInfo{Name: l.name, Acquired: false}, nil
+	}
+	ttl, err := time.ParseDuration(session.TTL)
+	if err != nil {
+		return nil, err
+	}
+	return &LockInfo{
+		Name:     l.name,
+		Acquired: true,
+		Owner:    session.Name,
+		Data:     string(kv.Value),
+		TTL:      ttl,
+		Token:    kv.LockIndex,
+	}, nil
+}
+
+// SetData sets the data payload for the lock.
+// The data is set into the backend only when the lock is acquired,
+// so any call to this method after acquisition won't update the value.
+func (l *ConsulLock) SetData(data string) {
+	l.data = data
+}
+
+// Token returns the fencing token obtained by the last successful Acquire
+func (l *ConsulLock) Token() uint64 {
+	return l.token
+}
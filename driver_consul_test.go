@@ -0,0 +1,369 @@
+// +build consul
+
+package glock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeConsul is an in-process stand-in for the consul HTTP API, implementing
+// the subset of the session, KV and transaction endpoints used by the driver.
+// Like consul, it rejects session TTLs shorter than its minimum TTL. Consul
+// invalidates sessions between one and two TTLs after they were last renewed:
+// the fake does as soon as their TTL expires, as measured by its clock.
+type fakeConsul struct {
+	mtx      sync.Mutex
+	clock    Clock
+	minTTL   time.Duration
+	index    uint64
+	kvs      map[string]*api.KVPair
+	sessions map[string]*fakeSession
+}
+
+type fakeSession struct {
+	entry  api.SessionEntry
+	ttl    time.Duration
+	expire time.Time
+}
+
+func newFakeConsul(clock Clock, minTTL time.Duration) *fakeConsul {
+	return &fakeConsul{
+		clock:    clock,
+		minTTL:   minTTL,
+		kvs:      make(map[string]*api.KVPair),
+		sessions: make(map[string]*fakeSession),
+	}
+}
+
+// invalidate destroys the session, releasing or deleting its keys. f.mtx must be held
+func (f *fakeConsul) invalidate(id string) {
+	s, ok := f.sessions[id]
+	if !ok {
+		return
+	}
+	delete(f.sessions, id)
+	f.index++
+	for k, kv := range f.kvs {
+		if kv.Session != id {
+			continue
+		}
+		if s.entry.Behavior == api.SessionBehaviorDelete {
+			delete(f.kvs, k)
+		} else {
+			kv.Session = ""
+			kv.ModifyIndex = f.index
+		}
+	}
+}
+
+// expire invalidates the sessions whose TTL expired. f.mtx must be held
+func (f *fakeConsul) expire() {
+	now := f.clock.Now()
+	for id, s := range f.sessions {
+		if now.After(s.expire) {
+			f.invalidate(id)
+		}
+	}
+}
+
+// lock locks key with session, as the acquire parameter and the lock verb do.
+// f.mtx must be held
+func (f *fakeConsul) lock(key string, value []byte, flags uint64, session string) (*api.KVPair, bool) {
+	if _, ok := f.sessions[session]; !ok {
+		return nil, false
+	}
+	kv, ok := f.kvs[key]
+	if ok && kv.Session != "" && kv.Session != session {
+		return nil, false
+	}
+	f.index++
+	if !ok {
+		kv = &api.KVPair{Key: key, CreateIndex: f.index}
+		f.kvs[key] = kv
+	}
+	if kv.Session != session {
+		kv.LockIndex++
+	}
+	kv.Value, kv.Flags, kv.Session, kv.ModifyIndex = value, flags, session, f.index
+	return kv, true
+}
+
+// unlock unlocks key if held by session. f.mtx must be held
+func (f *fakeConsul) unlock(key string, value []byte, flags uint64, session string) (*api.KVPair, bool) {
+	kv, ok := f.kvs[key]
+	if !ok || kv.Session != session {
+		return nil, false
+	}
+	f.index++
+	kv.Value, kv.Flags, kv.Session, kv.ModifyIndex = value, flags, "", f.index
+	return kv, true
+}
+
+func (f *fakeConsul) reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.expire()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	switch path := r.URL.Path; {
+	case path == "/v1/session/create":
+		var entry struct {
+			Name, Behavior, TTL, LockDelay string
+		}
+		json.Unmarshal(body, &entry)
+		ttl, err := time.ParseDuration(entry.TTL)
+		if err == nil && (ttl < f.minTTL || ttl > 24*time.Hour) {
+			err = fmt.Errorf("Invalid Session TTL '%d', must be between [%s=24h0m0s]", ttl, f.minTTL)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id := gocql.TimeUUID().String()
+		f.index++
+		f.sessions[id] = &fakeSession{
+			entry:  api.SessionEntry{ID: id, Name: entry.Name, Behavior: entry.Behavior, TTL: entry.TTL, CreateIndex: f.index},
+			ttl:    ttl,
+			expire: f.clock.Now().Add(ttl),
+		}
+		f.reply(w, http.StatusOK, map[string]string{"ID": id})
+
+	case strings.HasPrefix(path, "/v1/session/destroy/"):
+		f.invalidate(strings.TrimPrefix(path, "/v1/session/destroy/"))
+		f.reply(w, http.StatusOK, true)
+
+	case strings.HasPrefix(path, "/v1/session/renew/"):
+		s, ok := f.sessions[strings.TrimPrefix(path, "/v1/session/renew/")]
+		if !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		s.expire = f.clock.Now().Add(s.ttl)
+		f.reply(w, http.StatusOK, []api.SessionEntry{s.entry})
+
+	case strings.HasPrefix(path, "/v1/session/info/"):
+		entries := []api.SessionEntry{}
+		if s, ok := f.sessions[strings.TrimPrefix(path, "/v1/session/info/")]; ok {
+			entries = append(entries, s.entry)
+		}
+		f.reply(w, http.StatusOK, entries)
+
+	case strings.HasPrefix(path, "/v1/kv/"):
+		f.serveKV(w, r, strings.TrimPrefix(path, "/v1/kv/"), body)
+
+	case path == "/v1/txn":
+		f.serveTxn(w, body)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeConsul) serveKV(w http.ResponseWriter, r *http.Request, key string, body []byte) {
+	q := r.URL.Query()
+	flags, _ := strconv.ParseUint(q.Get("flags"), 10, 64)
+	switch {
+	case r.Method == "GET":
+		kv, ok := f.kvs[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		f.reply(w, http.StatusOK, []*api.KVPair{kv})
+
+	case r.Method == "PUT" && q.Get("acquire") != "":
+		_, ok := f.lock(key, body, flags, q.Get("acquire"))
+		f.reply(w, http.StatusOK, ok)
+
+	case r.Method == "PUT" && q.Get("release") != "":
+		_, ok := f.unlock(key, body, flags, q.Get("release"))
+		f.reply(w, http.StatusOK, ok)
+
+	default:
+		http.Error(w, "unsupported kv operation", http.StatusBadRequest)
+	}
+}
+
+// serveTxn runs the transaction on a copy of the keys, so that nothing is
+// applied if one of the operations fails
+func (f *fakeConsul) serveTxn(w http.ResponseWriter, body []byte) {
+	var ops api.TxnOps
+	if err := json.Unmarshal(body, &ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	kvs, index := make(map[string]*api.KVPair, len(f.kvs)), f.index
+	for k, kv := range f.kvs {
+		c := *kv
+		kvs[k] = &c
+	}
+
+	var resp api.TxnResponse
+	for i, op := range ops {
+		var kv *api.KVPair
+		ok := false
+		switch op.KV.Verb {
+		case api.KVCheckIndex:
+			kv, ok = f.kvs[op.KV.Key]
+			ok = ok && kv.ModifyIndex == op.KV.Index
+		case api.KVCheckSession:
+			kv, ok = f.kvs[op.KV.Key]
+			ok = ok && kv.Session == op.KV.Session
+		case api.KVLock:
+			kv, ok = f.lock(op.KV.Key, op.KV.Value, op.KV.Flags, op.KV.Session)
+		case api.KVUnlock:
+			kv, ok = f.unlock(op.KV.Key, op.KV.Value, op.KV.Flags, op.KV.Session)
+		}
+		if !ok {
+			resp.Errors = append(resp.Errors, &api.TxnError{OpIndex: i, What: "failed " + string(op.KV.Verb)})
+			break
+		}
+		c := *kv
+		resp.Results = append(resp.Results, &api.TxnResult{KV: &c})
+	}
+	if len(resp.Errors) > 0 {
+		f.kvs, f.index = kvs, index
+		f.reply(w, http.StatusConflict, api.TxnResponse{Errors: resp.Errors})
+		return
+	}
+	f.reply(w, http.StatusOK, resp)
+}
+
+var consulServer *httptest.Server
+
+// consulMinTTL is the shortest session TTL accepted by consul
+const consulMinTTL = 10 * time.Second
+
+// StartFakeConsul starts a fake consul measuring time with clock, for the
+// tests of package glock_test. The server must be closed when done.
+func StartFakeConsul(clock Clock) *httptest.Server {
+	return httptest.NewServer(newFakeConsul(clock, consulMinTTL))
+}
+
+func TestMain(m *testing.M) {
+	consulServer = httptest.NewServer(newFakeConsul(SystemClock, consulScale))
+	result := m.Run()
+	consulServer.Close()
+	os.Exit(result)
+}
+
+func consulClient(t *testing.T) Client {
+	opts := ConsulOptions{
+		Address:   consulServer.Listener.Addr().String(),
+		Scheme:    "http",
+		Namespace: "glock/tests/",
+	}
+	c, err := NewConsulClient(opts)
+	if err != nil {
+		t.Fatalf("Cannot create consul client: %s", err)
+	}
+	return c
+}
+
+// consulScale is the scale of the driver suites, and the shortest TTL the
+// fake consul of the suites accepts in place of consulMinTTL: at the scale of
+// consul, the suites would take longer than the default test timeout.
+// Consul is not registered in Drivers, as it only runs against the fake.
+var consulScale = time.Second
+
+func TestConsulLockMinTTL(t *testing.T) {
+	server := httptest.NewServer(newFakeConsul(SystemClock, consulMinTTL))
+	defer server.Close()
+	c, err := NewConsulClient(ConsulOptions{Address: server.Listener.Addr().String(), Scheme: "http"})
+	if err != nil {
+		t.Fatalf("Cannot create consul client: %s", err)
+	}
+	l := c.NewLock(lockName)
+	if err = l.Acquire(consulMinTTL - time.Millisecond); err != ErrInvalidTTL {
+		t.Fatalf("Acquire with a TTL shorter than consul accepts, expected '%s', got '%v'", ErrInvalidTTL, err)
+	}
+	if err = l.Acquire(consulMinTTL); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	if err = l.RefreshTTL(time.Second); err != ErrInvalidTTL {
+		t.Fatalf("Refresh with a TTL shorter than consul accepts, expected '%s', got '%v'", ErrInvalidTTL, err)
+	}
+	if err = l.Release(); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+}
+
+func TestConsulClient(t *testing.T) {
+	testClient(t, consulClient)
+}
+
+func TestConsulLock(t *testing.T) {
+	testLock(t, consulClient, consulScale)
+}
+
+func TestConsulLockContext(t *testing.T) {
+	testLockContext(t, consulClient, consulScale)
+}
+
+func TestConsulLockToken(t *testing.T) {
+	testLockToken(t, consulClient, consulScale)
+}
+
+func TestConsulManagerAcquire(t *testing.T) {
+	testManagerAcquire(t, consulClient, consulScale)
+}
+
+func TestConsulManagerAcquireWait(t *testing.T) {
+	testManagerAcquireWait(t, consulClient, consulScale)
+}
+
+func TestConsulManagerFailReleaseAll(t *testing.T) {
+	testManagerFailReleaseAll(t, consulClient, consulScale)
+}
+
+func TestConsulManagerAcquireContext(t *testing.T) {
+	testManagerAcquireContext(t, consulClient, consulScale)
+}
+
+func TestConsulManagerConcurrent(t *testing.T) {
+	testManagerConcurrent(t, consulClient, consulScale)
+}
+
+func TestConsulManagerAcquireAll(t *testing.T) {
+	testManagerAcquireAll(t, consulClient, consulScale)
+}
+
+func TestConsulManagerWatch(t *testing.T) {
+	testManagerWatch(t, consulClient, consulScale)
+}
+
+func TestConsulManagerKeeper(t *testing.T) {
+	testManagerKeeper(t, consulClient, consulScale)
+}
+
+func TestConsulManagerLease(t *testing.T) {
+	testManagerLease(t, consulClient, consulScale)
+}
+
+func TestConsulManagerReentrant(t *testing.T) {
+	testManagerReentrant(t, consulClient, consulScale)
+}
+
+func TestConsulElection(t *testing.T) {
+	testElection(t, consulClient, consulScale)
+}
@@ -0,0 +1,444 @@
+package glock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdOptions represents options for connecting to etcd
+type EtcdOptions struct {
+	// Endpoints, i.e. 'localhost:2379'
+	Endpoints []string
+	// DialTimeout is how long to wait for the connection to be established.
+	// If not set, it defaults to 5 seconds
+	DialTimeout time.Duration
+	Username    string
+	Password    string
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+	// Namespace is an optional prefix for all the keys that will be created.
+	// It must contain the separator (if any). If not set, the default value
+	// is used "glock/"
+	Namespace string
+}
+
+// EtcdClient implements the Client interface to manage locks in etcd.
+// It can be used by multiple goroutines.
+type EtcdClient struct {
+	client *clientv3.Client
+	opts   EtcdOptions
+	// mtx protects client
+	mtx sync.Mutex
+}
+
+// EtcdLock implements the Lock interface for locks in etcd.
+//
+// The lock is a key holding the owner id, with its data in a sibling key.
+// Both keys are attached to a lease with the lock TTL, so they are deleted by
+// etcd when the lease expires. The fencing token is the revision the lock key
+// was created at.
+type EtcdLock struct {
+	name   string
+	ttl    time.Duration
+	client *EtcdClient
+	data   string
+	token  uint64
+	// lease is the lease granted by this lock object, for granted seconds
+	lease   clientv3.LeaseID
+	granted int64
+}
+
+// NewEtcdClient returns a new EtcdClient given the provided EtcdOptions
+func NewEtcdClient(opts EtcdOptions) (*EtcdClient, error) {
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "glock/"
+	}
+	c := &EtcdClient{opts: opts}
+	err := c.Reconnect()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Clone returns a disconnected copy of the currenct client
+func (c *EtcdClient) Clone() Client {
+	return &EtcdClient{
+		client: nil,
+		opts:   c.opts,
+	}
+}
+
+// Close closes the connection to etcd. Leases are not revoked, so the
+// locks held by the client are kept until they expire.
+func (c *EtcdClient) Close() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.close()
+}
+
+func (c *EtcdClient) close() {
+	if c.client != nil {
+		c.client.Close()
+		c.client = nil
+	}
+}
+
+// Reconnect reconnects to etcd, or connects if not connected
+func (c *EtcdClient) Reconnect() error {
+	return c.ReconnectContext(context.Background())
+}
+
+// ReconnectContext reconnects to etcd, or connects if not connected.
+// It returns as soon as ctx is done, even if the dial is still in progress.
+func (c *EtcdClient) ReconnectContext(ctx context.Context) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.reconnect(ctx)
+}
+
+func (c *EtcdClient) reconnect(ctx context.Context) error {
+	type dialResult struct {
+		client *clientv3.Client
+		err    error
+	}
+
+	c.close()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	config := clientv3.Config{
+		Endpoints:   c.opts.Endpoints,
+		DialTimeout: c.opts.DialTimeout,
+		Username:    c.opts.Username,
+		Password:    c.opts.Password,
+	}
+	res := make(chan dialResult, 1)
+	go func() {
+		client, err := clientv3.New(config)
+		res <- dialResult{client, err}
+	}()
+
+	select {
+	case r := <-res:
+		if r.err != nil {
+			return r.err
+		}
+		c.client = r.client
+		return nil
+
+	case <-ctx.Done():
+		// do not leak the client if the dial completes later on
+		go func() {
+			if r := <-res; r.err == nil {
+				r.client.Close()
+			}
+		}()
+		return ctx.Err()
+	}
+}
+
+// conn returns the etcd client, connecting if not connected
+func (c *EtcdClient) conn(ctx context.Context) (*clientv3.Client, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.client == nil {
+		if err := c.reconnect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c.client, nil
+}
+
+// SetID sets the ID for the current client
+func (c *EtcdClient) SetID(id string) {
+	c.opts.ClientID = id
+}
+
+// ID returns the current client ID
+func (c *EtcdClient) ID() string {
+	return c.opts.ClientID
+}
+
+// Notify watches the key of the named lock, which changes when the lock is
+// acquired, refreshed, released or expires.
+func (c *EtcdClient) Notify(ctx context.Context, name string) (<-chan struct{}, error) {
+	cli, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lock := EtcdLock{name: name, client: c}
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	wch := cli.Watch(wctx, lock.key(), clientv3.WithCreatedNotify())
+
+	// wait for the watch to be created, not to miss events meanwhile
+	resp, ok := <-wch
+	if !ok || resp.Err() != nil {
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !ok {
+			return nil, rpctypes.ErrNoLeader
+		}
+		return nil, resp.Err()
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer cancel()
+		defer close(ch)
+		for resp := range wch {
+			if resp.Err() != nil {
+				return
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// NewLock creates a new Lock. Lock is not automatically acquired.
+func (c *EtcdClient) NewLock(name string) Lock {
+	return &EtcdLock{
+		name:   name,
+		ttl:    time.Duration(0),
+		client: c,
+	}
+}
+
+func (l *EtcdLock) key() string {
+	return l.client.opts.Namespace + l.name
+}
+
+func (l *EtcdLock) dataKey() string {
+	return l.key() + ":data"
+}
+
+// leaseTTL returns the ttl in seconds, the granularity of etcd leases,
+// rounded up so that the lease never expires before the ttl
+func leaseTTL(ttl time.Duration) int64 {
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+// Acquire acquires the lock for the specified time lentgh (ttl).
+// It returns immadiately if the lock cannot be acquired
+func (l *EtcdLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext is like Acquire, but aborts the requests to etcd when ctx is done.
+func (l *EtcdLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Second {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	cli, err := l.client.conn(ctx)
+	if err != nil {
+		return err
+	}
+	lease, err := cli.Grant(ctx, leaseTTL(ttl))
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	resp, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(l.key()), "=", 0)).
+		Then(
+			clientv3.OpPut(l.key(), l.client.ID(), clientv3.WithLease(lease.ID)),
+			clientv3.OpPut(l.dataKey(), l.data, clientv3.WithLease(lease.ID)),
+		).Commit()
+	if err == nil && !resp.Succeeded {
+		err = ErrLockHeldByOtherClient
+	}
+	if err != nil {
+		// the lease would expire anyway, no need to keep it around until then
+		cli.Revoke(ctx, lease.ID)
+		return contextError(ctx, err)
+	}
+	l.token = uint64(resp.Header.Revision)
+	l.lease, l.granted = lease.ID, leaseTTL(ttl)
+	return nil
+}
+
+// Release releases the lock if owned. Returns an error if the lock is not owned by this client
+func (l *EtcdLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext is like Release, but aborts the requests to etcd when ctx is done.
+func (l *EtcdLock) ReleaseContext(ctx context.Context) error {
+	cli, err := l.client.conn(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(l.key()), "=", l.client.ID())).
+		Then(
+			clientv3.OpGet(l.key()),
+			clientv3.OpDelete(l.key()),
+			clientv3.OpDelete(l.dataKey()),
+		).Commit()
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	if !resp.Succeeded {
+		return ErrLockNotOwned
+	}
+	// the lock is gone already, revoking the lease is just housekeeping
+	if kvs := resp.Responses[0].GetResponseRange().Kvs; len(kvs) > 0 {
+		cli.Revoke(ctx, clientv3.LeaseID(kvs[0].Lease))
+	}
+	return nil
+}
+
+// RefreshTTL extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
+func (l *EtcdLock) RefreshTTL(ttl time.Duration) error {
+	return l.RefreshTTLContext(context.Background(), ttl)
+}
+
+// RefreshTTLContext is like RefreshTTL, but aborts the requests to etcd when ctx is done.
+func (l *EtcdLock) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
+	l.ttl = ttl
+	return l.RefreshContext(ctx)
+}
+
+// Refresh extends the lock by sending a keepalive for its lease, and updates
+// its data. If the TTL changed, the lock is moved to a new lease instead.
+// It returns an error if the lock is not owned by the current client
+func (l *EtcdLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, but aborts the requests to etcd when ctx is done.
+func (l *EtcdLock) RefreshContext(ctx context.Context) error {
+	if l.ttl < time.Second {
+		return ErrInvalidTTL
+	}
+	cli, err := l.client.conn(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := cli.Get(ctx, l.key())
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	if len(resp.Kvs) == 0 || string(resp.Kvs[0].Value) != l.client.ID() {
+		return ErrLockNotOwned
+	}
+	kv := resp.Kvs[0]
+	lease := clientv3.LeaseID(kv.Lease)
+
+	// etcd might grant more than the requested seconds, so the lease is
+	// compared with the one granted by this lock rather than with its TTL
+	newLease := lease
+	if lease != l.lease || l.granted != leaseTTL(l.ttl) {
+		granted, err := cli.Grant(ctx, leaseTTL(l.ttl))
+		if err != nil {
+			return contextError(ctx, err)
+		}
+		newLease = granted.ID
+	}
+	txn, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(l.key()), "=", kv.ModRevision)).
+		Then(
+			clientv3.OpPut(l.key(), l.client.ID(), clientv3.WithLease(newLease)),
+			clientv3.OpPut(l.dataKey(), l.data, clientv3.WithLease(newLease)),
+		).Commit()
+	if err == nil && !txn.Succeeded {
+		err = ErrLockNotOwned
+	}
+	if err != nil {
+		if newLease != lease {
+			cli.Revoke(ctx, newLease)
+		}
+		return etcdLeaseError(ctx, err)
+	}
+	if newLease != lease {
+		l.lease, l.granted = newLease, leaseTTL(l.ttl)
+		cli.Revoke(ctx, lease)
+		return nil
+	}
+	_, err = cli.KeepAliveOnce(ctx, lease)
+	return etcdLeaseError(ctx, err)
+}
+
+// etcdLeaseError maps a lease that expired in the meantime to ErrLockNotOwned
+func etcdLeaseError(ctx context.Context, err error) error {
+	if err == rpctypes.ErrLeaseNotFound {
+		return ErrLockNotOwned
+	}
+	return contextError(ctx, err)
+}
+
+// Info returns information about the lock.
+func (l *EtcdLock) Info() (*LockInfo, error) {
+	return l.InfoContext(context.Background())
+}
+
+// InfoContext is like Info, but aborts the requests to etcd when ctx is done.
+func (l *EtcdLock) InfoContext(ctx context.Context) (*LockInfo, error) {
+	cli, err := l.client.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cli.Txn(ctx).Then(clientv3.OpGet(l.key()), clientv3.OpGet(l.dataKey())).Commit()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	owner := resp.Responses[0].GetResponseRange().Kvs
+	if len(owner) == 0 {
+		return &LockInfo{Name: l.name, Acquired: false}, nil
+	}
+	ttl, err := cli.TimeToLive(ctx, clientv3.LeaseID(owner[0].Lease))
+	if err != nil && err != rpctypes.ErrLeaseNotFound {
+		return nil, contextError(ctx, err)
+	}
+	if err != nil || ttl.TTL <= 0 {
+		// the lease expired, etcd is about to delete the keys
+		return &LockInfo{Name: l.name, Acquired: false}, nil
+	}
+	info := &LockInfo{
+		Name:     l.name,
+		Acquired: true,
+		Owner:    string(owner[0].Value),
+		TTL:      time.Duration(ttl.TTL) * time.Second,
+		Token:    uint64(owner[0].CreateRevision),
+	}
+	if data := resp.Responses[1].GetResponseRange().Kvs; len(data) > 0 {
+		info.Data = string(data[0].Value)
+	}
+	return info, nil
+}
+
+// SetData sets the data payload for the lock.
+// The data is set into the backend only when the lock is acquired,
+// so any call to this method after acquisition won't update the value.
+func (l *EtcdLock) SetData(data string) {
+	l.data = data
+}
+
+// Token returns the fencing token obtained by the last successful Acquire
+func (l *EtcdLock) Token() uint64 {
+	return l.token
+}
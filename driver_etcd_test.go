@@ -0,0 +1,156 @@
+// +build etcd
+
+package glock
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+var etcdServer *embed.Etcd
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "glock-etcd")
+	if err != nil {
+		panic(err)
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	// short ticks lower the minimum lease TTL to one second
+	cfg.TickMs = 10
+	cfg.ElectionMs = 100
+	client, _ := url.Parse("http://127.0.0.1:0")
+	peer, _ := url.Parse("http://127.0.0.1:0")
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{*client}, []url.URL{*client}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{*peer}, []url.URL{*peer}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	etcdServer, err = embed.StartEtcd(cfg)
+	if err != nil {
+		panic(err)
+	}
+	select {
+	case <-etcdServer.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		panic("embedded etcd took too long to start")
+	}
+	result := m.Run()
+	etcdServer.Close()
+	os.RemoveAll(dir)
+	os.Exit(result)
+}
+
+func etcdClient(t *testing.T) Client {
+	opts := EtcdOptions{
+		Endpoints: []string{etcdServer.Clients[0].Addr().String()},
+		Namespace: "glock/tests/",
+	}
+	c, err := NewEtcdClient(opts)
+	if err != nil {
+		t.Fatalf("Cannot create etcd client: %s", err)
+	}
+	return c
+}
+
+func init() {
+	Drivers["etcd"] = Driver{etcdClient, time.Second}
+}
+
+func TestEtcdClient(t *testing.T) {
+	testClient(t, etcdClient)
+}
+
+func TestEtcdLock(t *testing.T) {
+	testLock(t, etcdClient, time.Second)
+}
+
+func TestEtcdLockContext(t *testing.T) {
+	testLockContext(t, etcdClient, time.Second)
+}
+
+func TestEtcdLockToken(t *testing.T) {
+	testLockToken(t, etcdClient, time.Second)
+}
+
+func TestEtcdManagerAcquire(t *testing.T) {
+	testManagerAcquire(t, etcdClient, time.Second)
+}
+
+func TestEtcdManagerAcquireWait(t *testing.T) {
+	testManagerAcquireWait(t, etcdClient, time.Second)
+}
+
+func TestEtcdManagerFailReleaseAll(t *testing.T) {
+	testManagerFailReleaseAll(t, etcdClient, time.Second)
+}
+
+func TestEtcdManagerAcquireContext(t *testing.T) {
+	testManagerAcquireContext(t, etcdClient, time.Second)
+}
+
+func TestEtcdManagerConcurrent(t *testing.T) {
+	testManagerConcurrent(t, etcdClient, time.Second)
+}
+
+func TestEtcdManagerAcquireAll(t *testing.T) {
+	testManagerAcquireAll(t, etcdClient, time.Second)
+}
+
+func TestEtcdManagerWatch(t *testing.T) {
+	testManagerWatch(t, etcdClient, time.Second)
+}
+
+func TestEtcdManagerKeeper(t *testing.T) {
+	testManagerKeeper(t, etcdClient, time.Second)
+}
+
+func TestEtcdManagerLease(t *testing.T) {
+	testManagerLease(t, etcdClient, time.Second)
+}
+
+func TestEtcdManagerReentrant(t *testing.T) {
+	testManagerReentrant(t, etcdClient, time.Second)
+}
+
+func TestEtcdElection(t *testing.T) {
+	testElection(t, etcdClient, time.Second)
+}
+
+func TestEtcdLockLease(t *testing.T) {
+	if ttl := leaseTTL(1900 * time.Millisecond); ttl != 2 {
+		t.Fatalf("Expected a lease of 2s for a 1.9s lock, got %ds", ttl)
+	}
+
+	c := etcdClient(t).(*EtcdClient)
+	defer c.Close()
+	l := c.NewLock("lease").(*EtcdLock)
+	if err := l.Acquire(1500 * time.Millisecond); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	defer l.Release()
+	lease := l.lease
+	for i := 0; i < 2; i++ {
+		if err := l.Refresh(); err != nil {
+			t.Fatalf("Cannot refresh lock: %s", err)
+		}
+	}
+	cli, err := c.conn(context.Background())
+	if err != nil {
+		t.Fatalf("Cannot connect to etcd: %s", err)
+	}
+	resp, err := cli.Get(context.Background(), l.key())
+	if err != nil {
+		t.Fatalf("Cannot get lock key: %s", err)
+	}
+	if len(resp.Kvs) == 0 || clientv3.LeaseID(resp.Kvs[0].Lease) != lease {
+		t.Fatalf("Expected refresh to keep the lock on lease %x", lease)
+	}
+}
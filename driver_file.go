@@ -0,0 +1,293 @@
+package glock
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// FileOptions represents options for managing locks in a directory
+type FileOptions struct {
+	// Dir is the directory holding the lock files, created if it does not exist.
+	// If not set, the default value is used "$TMPDIR/glock"
+	Dir string
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+}
+
+// FileClient implements the Client interface to manage locks among the
+// processes of a single host, with one file per lock in a directory
+type FileClient struct {
+	opts FileOptions
+}
+
+// FileLock implements the Lock interface for locks stored in files.
+//
+// The lock file holds the owner, data and expiration time of the lock, and
+// is only read and written under an exclusive flock, which is held for the
+// duration of a single operation. Locks are held until they expire, so the
+// TTL is honoured even if the holder crashes without releasing them. The
+// token stored in the file counts the acquisitions of the lock and is the
+// fencing token.
+type FileLock struct {
+	name   string
+	ttl    time.Duration
+	client *FileClient
+	data   string
+	token  uint64
+}
+
+// fileState is the content of a lock file
+type fileState struct {
+	Owner string `json:"owner,omitempty"`
+	Data  string `json:"data,omitempty"`
+	// Expire is the expiration time, in nanoseconds since the epoch
+	Expire int64  `json:"expire,omitempty"`
+	Token  uint64 `json:"token"`
+}
+
+func (s *fileState) live(now time.Time) bool {
+	return s.Owner != "" && s.Expire > now.UnixNano()
+}
+
+// NewFileClient returns a new FileClient given the provided FileOptions
+func NewFileClient(opts FileOptions) (*FileClient, error) {
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.Dir == "" {
+		opts.Dir = filepath.Join(os.TempDir(), "glock")
+	}
+	c := FileClient{opts}
+	err := c.Reconnect()
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Clone returns a copy of the currenct client
+func (c *FileClient) Clone() Client {
+	return &FileClient{opts: c.opts}
+}
+
+// Close is a no-op, as lock files are only open during an operation
+func (c *FileClient) Close() {
+}
+
+// Reconnect creates the locks directory if it does not exist
+func (c *FileClient) Reconnect() error {
+	return c.ReconnectContext(context.Background())
+}
+
+// ReconnectContext is like Reconnect, but fails if ctx is done.
+func (c *FileClient) ReconnectContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.MkdirAll(c.opts.Dir, 0755)
+}
+
+// SetID sets the ID for the current client
+func (c *FileClient) SetID(id string) {
+	c.opts.ClientID = id
+}
+
+// ID returns the current client ID
+func (c *FileClient) ID() string {
+	return c.opts.ClientID
+}
+
+// NewLock creates a new Lock. Lock is not automatically acquired.
+func (c *FileClient) NewLock(name string) Lock {
+	return &FileLock{
+		name:   name,
+		ttl:    time.Duration(0),
+		client: c,
+	}
+}
+
+// path returns the path of the lock file. Names are escaped, so that they
+// cannot point outside of the locks directory.
+func (l *FileLock) path() string {
+	return filepath.Join(l.client.opts.Dir, url.PathEscape(l.name)+".lock")
+}
+
+// flockRetry is how often flock tries to lock a file held by somebody else
+const flockRetry = time.Millisecond
+
+// flock waits for an exclusive lock on file, or until ctx is done
+func flock(ctx context.Context, file *os.File) error {
+	for {
+		ok, err := tryFlock(file)
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(flockRetry):
+		}
+	}
+}
+
+// update runs f on the state of the lock while holding the lock file, and
+// writes the state back if f returns true
+func (l *FileLock) update(ctx context.Context, f func(s *fileState) (bool, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path(), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = flock(ctx, file); err != nil {
+		return err
+	}
+
+	// A decoder ignores what follows the state, left over by a shorter write
+	var s fileState
+	err = json.NewDecoder(file).Decode(&s)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	changed, err := f(&s)
+	if err != nil || !changed {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// Write before truncating, so that a crash never loses the token
+	if _, err = file.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return file.Truncate(int64(len(data)))
+}
+
+// Acquire acquires the lock for the specified time lentgh (ttl).
+// It returns immadiately if the lock cannot be acquired
+func (l *FileLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext is like Acquire, but fails if ctx is done.
+func (l *FileLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	return l.update(ctx, func(s *fileState) (bool, error) {
+		now := time.Now()
+		if s.live(now) {
+			return false, ErrLockHeldByOtherClient
+		}
+		s.Owner = l.client.ID()
+		s.Data = l.data
+		s.Expire = now.Add(ttl).UnixNano()
+		s.Token++
+		l.token = s.Token
+		return true, nil
+	})
+}
+
+// Release releases the lock if owned. Returns an error if the lock is not owned by this client
+func (l *FileLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext is like Release, but fails if ctx is done.
+func (l *FileLock) ReleaseContext(ctx context.Context) error {
+	return l.update(ctx, func(s *fileState) (bool, error) {
+		if !s.live(time.Now()) || s.Owner != l.client.ID() {
+			return false, ErrLockNotOwned
+		}
+		*s = fileState{Token: s.Token}
+		return true, nil
+	})
+}
+
+// RefreshTTL extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
+func (l *FileLock) RefreshTTL(ttl time.Duration) error {
+	return l.RefreshTTLContext(context.Background(), ttl)
+}
+
+// RefreshTTLContext is like RefreshTTL, but fails if ctx is done.
+func (l *FileLock) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
+	l.ttl = ttl
+	return l.RefreshContext(ctx)
+}
+
+// Refresh extends the lock by extending its expiration time, and updates its data.
+// It returns an error if the lock is not owned by the current client
+func (l *FileLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, but fails if ctx is done.
+func (l *FileLock) RefreshContext(ctx context.Context) error {
+	if l.ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	return l.update(ctx, func(s *fileState) (bool, error) {
+		now := time.Now()
+		if !s.live(now) || s.Owner != l.client.ID() {
+			return false, ErrLockNotOwned
+		}
+		s.Data = l.data
+		s.Expire = now.Add(l.ttl).UnixNano()
+		return true, nil
+	})
+}
+
+// Info returns information about the lock.
+func (l *FileLock) Info() (*LockInfo, error) {
+	return l.InfoContext(context.Background())
+}
+
+// InfoContext is like Info, but fails if ctx is done.
+func (l *FileLock) InfoContext(ctx context.Context) (*LockInfo, error) {
+	info := &LockInfo{Name: l.name, Acquired: false}
+	err := l.update(ctx, func(s *fileState) (bool, error) {
+		now := time.Now()
+		if s.live(now) {
+			info.Acquired = true
+			info.Owner = s.Owner
+			info.Data = s.Data
+			info.TTL = time.Duration(s.Expire - now.UnixNano())
+			info.Token = s.Token
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// SetData sets the data payload for the lock.
+// The data is set into the backend only when the lock is acquired,
+// so any call to this method after acquisition won't update the value.
+func (l *FileLock) SetData(data string) {
+	l.data = data
+}
+
+// Token returns the fencing token obtained by the last successful Acquire
+func (l *FileLock) Token() uint64 {
+	return l.token
+}
@@ -0,0 +1,183 @@
+// +build file
+
+package glock
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var fileScale = time.Millisecond
+
+var fileDir struct {
+	once sync.Once
+	path string
+}
+
+func fileOptions(t *testing.T) FileOptions {
+	fileDir.once.Do(func() {
+		dir, err := ioutil.TempDir("", "glock-tests")
+		if err != nil {
+			t.Fatalf("Cannot create locks directory: %s", err)
+		}
+		fileDir.path = dir
+	})
+	return FileOptions{Dir: filepath.Join(fileDir.path, "locks")}
+}
+
+func fileClient(t *testing.T) Client {
+	c, err := NewFileClient(fileOptions(t))
+	if err != nil {
+		t.Fatalf("Cannot create file client: %s", err)
+	}
+	return c
+}
+
+func init() {
+	Drivers["file"] = Driver{fileClient, fileScale}
+}
+
+func TestFileClient(t *testing.T) {
+	testClient(t, fileClient)
+}
+
+func TestFileLock(t *testing.T) {
+	testLock(t, fileClient, fileScale)
+}
+
+func TestFileLockContext(t *testing.T) {
+	testLockContext(t, fileClient, fileScale)
+}
+
+func TestFileLockToken(t *testing.T) {
+	testLockToken(t, fileClient, fileScale)
+}
+
+func TestFileManagerAcquire(t *testing.T) {
+	testManagerAcquire(t, fileClient, fileScale)
+}
+
+func TestFileManagerAcquireWait(t *testing.T) {
+	testManagerAcquireWait(t, fileClient, fileScale)
+}
+
+func TestFileManagerFailReleaseAll(t *testing.T) {
+	testManagerFailReleaseAll(t, fileClient, fileScale)
+}
+
+func TestFileManagerAcquireContext(t *testing.T) {
+	testManagerAcquireContext(t, fileClient, fileScale)
+}
+
+func TestFileManagerConcurrent(t *testing.T) {
+	testManagerConcurrent(t, fileClient, fileScale)
+}
+
+func TestFileManagerAcquireAll(t *testing.T) {
+	testManagerAcquireAll(t, fileClient, fileScale)
+}
+
+func TestFileManagerWatch(t *testing.T) {
+	testManagerWatch(t, fileClient, fileScale)
+}
+
+func TestFileManagerKeeper(t *testing.T) {
+	testManagerKeeper(t, fileClient, fileScale)
+}
+
+func TestFileManagerLease(t *testing.T) {
+	testManagerLease(t, fileClient, fileScale)
+}
+
+func TestFileManagerReentrant(t *testing.T) {
+	testManagerReentrant(t, fileClient, fileScale)
+}
+
+func TestFileElection(t *testing.T) {
+	testElection(t, fileClient, fileScale)
+}
+
+// TestFileCrash runs a copy of the test binary which acquires the lock and
+// exits without releasing it.
+func TestFileCrash(t *testing.T) {
+	// leave time to the holder to exit
+	ttl := time.Duration(ttlLength) * 10 * fileScale
+	opts := fileOptions(t)
+	name := "crash/" + lockName
+
+	if dir := os.Getenv("GLOCK_FILE_CRASH"); dir != "" {
+		opts = FileOptions{Dir: dir, ClientID: "crashed"}
+		c, err := NewFileClient(opts)
+		if err != nil {
+			t.Fatalf("Cannot create file client: %s", err)
+		}
+		lock := c.NewLock(name)
+		lock.SetData("crashed")
+		if err = lock.Acquire(ttl); err != nil {
+			t.Fatalf("Cannot acquire lock: %s", err)
+		}
+		os.Exit(3)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFileCrash$")
+	cmd.Env = append(os.Environ(), "GLOCK_FILE_CRASH="+opts.Dir)
+	err := cmd.Run()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.String() != "exit status 3" {
+		t.Fatalf("Holder should have exited with status 3, got '%v'", err)
+	}
+
+	// The lock is still held by the crashed process until it expires
+	lock := fileClient(t).NewLock(name)
+	info, err := lock.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if !info.Acquired || info.Owner != "crashed" || info.Data != "crashed" {
+		t.Fatalf("Lock should be held by the crashed process, got %+v", info)
+	}
+	if err = lock.Acquire(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+
+	time.Sleep(info.TTL + fileScale)
+	if err = lock.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock after it expired: %s", err)
+	}
+	if lock.Token() <= info.Token {
+		t.Errorf("Tokens should increase: %d <= %d", lock.Token(), info.Token)
+	}
+	if err = lock.Release(); err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", name, err)
+	}
+}
+
+// TestFileBusyContext checks that operations waiting for a lock file held by
+// somebody else give up once their context is done.
+func TestFileBusyContext(t *testing.T) {
+	lock := fileClient(t).NewLock("busy/" + lockName).(*FileLock)
+	file, err := os.OpenFile(lock.path(), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("Cannot open lock file: %s", err)
+	}
+	defer file.Close()
+	if err = flock(context.Background(), file); err != nil {
+		t.Fatalf("Cannot lock file: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*fileScale)
+	defer cancel()
+	if err = lock.AcquireContext(ctx, time.Duration(ttlLength)*fileScale); err != context.DeadlineExceeded {
+		t.Fatalf("Acquire of a busy lock file, expected '%s', got '%v'", context.DeadlineExceeded, err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*fileScale)
+	defer cancel()
+	if err = lock.ReleaseContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Release of a busy lock file, expected '%s', got '%v'", context.DeadlineExceeded, err)
+	}
+}
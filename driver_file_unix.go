@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package glock
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryFlock takes an exclusive lock on file without blocking, and returns
+// false if it is locked by somebody else. The lock is released when the file
+// is closed, even if the process crashes.
+func tryFlock(file *os.File) (bool, error) {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		switch err {
+		case nil:
+			return true, nil
+		case syscall.EWOULDBLOCK:
+			return false, nil
+		case syscall.EINTR:
+			continue
+		}
+		return false, err
+	}
+}
@@ -1,46 +1,350 @@
 package glock
 
 import (
+	"context"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
-type locksDB struct {
-	mtx   *sync.RWMutex
-	locks map[string]*MemoryLock
+// MemoryStore holds the locks of the memory driver. Clients share locks
+// only if attached to the same store, so that tests, or the nodes of a
+// simulated cluster, can be isolated from each other with stores of their
+// own. Clients are attached to DefaultMemoryStore by NewMemoryClient, and to
+// other stores by NewClient.
+type MemoryStore struct {
+	mtx        *sync.RWMutex
+	clock      Clock
+	locks      map[string]*MemoryLock
+	tokens     map[string]uint64
+	shared     map[string]map[string]time.Time
+	semaphores map[string]map[string]time.Time
+	watchers   map[string]map[chan struct{}]bool
+	queues     map[string][]*memoryWaiter
+	// stolen holds the clients evicted from a lock by an administrator,
+	// until their lease would have ended, and records the reason
+	stolen  map[string]map[string]time.Time
+	records map[string]string
 }
 
-var db *locksDB
+// memoryWaiter is a client waiting in the fair queue of a lock, until its
+// entry expires
+type memoryWaiter struct {
+	id     string
+	expire time.Time
+}
+
+// DefaultMemoryStore is the store of the clients returned by NewMemoryClient
+var DefaultMemoryStore = NewMemoryStore()
+
+// NewMemoryStore returns a new empty store, using the system clock
+func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreWithClock(SystemClock)
+}
+
+// NewMemoryStoreWithClock returns a new empty store, measuring the TTLs of
+// its locks with clock, e.g. a fake clock in tests. The clock is shared by
+// all the clients of the store, and by Snapshot and Inspect.
+func NewMemoryStoreWithClock(clock Clock) *MemoryStore {
+	return &MemoryStore{
+		mtx:        &sync.RWMutex{},
+		clock:      clockOrSystem(clock),
+		locks:      make(map[string]*MemoryLock),
+		tokens:     make(map[string]uint64),
+		shared:     make(map[string]map[string]time.Time),
+		semaphores: make(map[string]map[string]time.Time),
+		watchers:   make(map[string]map[chan struct{}]bool),
+		queues:     make(map[string][]*memoryWaiter),
+		stolen:     make(map[string]map[string]time.Time),
+		records:    make(map[string]string),
+	}
+}
+
+// NewClient returns a new client with the given id, attached to the store
+func (s *MemoryStore) NewClient(id string) *MemoryClient {
+	return &MemoryClient{id: id, store: s}
+}
+
+// lock returns the exclusive owner of the named lock at time now, if any.
+// Expired locks are forgotten even if their timer did not fire yet. s.mtx
+// must be held.
+func (s *MemoryStore) lock(name string, now time.Time) (*MemoryLock, bool) {
+	lock, ok := s.locks[name]
+	if ok && !lock.expire.After(now) {
+		delete(s.locks, name)
+		s.notify(name)
+		return nil, false
+	}
+	return lock, ok
+}
+
+// notify wakes up the watchers of the named lock. s.mtx must be held.
+func (s *MemoryStore) notify(name string) {
+	for ch := range s.watchers[name] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// holders returns the shared holders of the named lock with their expiration
+// time, forgetting the expired ones. s.mtx must be held.
+func (s *MemoryStore) holders(name string, now time.Time) map[string]time.Time {
+	return liveHolders(s.shared, name, now)
+}
+
+// slots returns the holders of the named semaphore with their expiration
+// time, forgetting the expired ones. s.mtx must be held.
+func (s *MemoryStore) slots(name string, now time.Time) map[string]time.Time {
+	return liveHolders(s.semaphores, name, now)
+}
+
+// queue returns the clients waiting for the named lock in arrival order,
+// forgetting the expired ones. s.mtx must be held.
+func (s *MemoryStore) queue(name string, now time.Time) []*memoryWaiter {
+	var live []*memoryWaiter
+	for _, w := range s.queues[name] {
+		if w.expire.After(now) {
+			live = append(live, w)
+		}
+	}
+	if len(live) == 0 {
+		delete(s.queues, name)
+		return nil
+	}
+	s.queues[name] = live
+	return live
+}
+
+// enqueue adds the client with the given id to the queue of the named lock
+// until ttl after now, or refreshes its entry if it is waiting already.
+// s.mtx must be held.
+func (s *MemoryStore) enqueue(name, id string, ttl time.Duration, now time.Time) {
+	expire := now.Add(ttl)
+	for _, w := range s.queue(name, now) {
+		if w.id == id {
+			w.expire = expire
+			return
+		}
+	}
+	s.queues[name] = append(s.queues[name], &memoryWaiter{id, expire})
+}
+
+// dequeue removes the client with the given id from the queue of the named
+// lock, waking up the waiters behind it. s.mtx must be held.
+func (s *MemoryStore) dequeue(name, id string, now time.Time) {
+	queue := s.queue(name, now)
+	for i, w := range queue {
+		if w.id == id {
+			s.queues[name] = append(queue[:i:i], queue[i+1:]...)
+			s.notify(name)
+			return
+		}
+	}
+}
+
+// turn tells if the client with the given id can take the named lock without
+// jumping the queue: nobody is waiting, or the client is the first waiter.
+// s.mtx must be held.
+func (s *MemoryStore) turn(name, id string, now time.Time) bool {
+	queue := s.queue(name, now)
+	return len(queue) == 0 || queue[0].id == id
+}
+
+// thefts returns the clients evicted from the named lock whose lease did not
+// end yet, forgetting the other ones. s.mtx must be held.
+func (s *MemoryStore) thefts(name string, now time.Time) map[string]time.Time {
+	thefts := liveHolders(s.stolen, name, now)
+	if thefts == nil {
+		delete(s.records, name)
+	}
+	return thefts
+}
+
+// evict releases the named lock whatever its holders, and records them as
+// evicted with the given record. s.mtx must be held.
+func (s *MemoryStore) evict(name, record string, now time.Time) {
+	evicted := make(map[string]time.Time)
+	if lock, ok := s.lock(name, now); ok {
+		evicted[lock.client.id] = lock.expire
+		lock.stop()
+		delete(s.locks, name)
+	}
+	for id, expire := range s.holders(name, now) {
+		evicted[id] = expire
+	}
+	delete(s.shared, name)
+	if len(evicted) == 0 {
+		return
+	}
+	thefts := s.thefts(name, now)
+	if thefts == nil {
+		thefts = make(map[string]time.Time)
+		s.stolen[name] = thefts
+	}
+	for id, expire := range evicted {
+		thefts[id] = expire
+	}
+	s.records[name] = record
+	s.notify(name)
+}
+
+// list returns the locks held in exclusive or shared mode whose name starts
+// with prefix, sorted by name, as seen by the given client. s.mtx must be
+// held.
+func (s *MemoryStore) list(prefix string, m *MemoryClient) []LockInfo {
+	seen := make(map[string]bool)
+	for name := range s.locks {
+		seen[name] = true
+	}
+	for name := range s.shared {
+		seen[name] = true
+	}
+	var names []string
+	for name := range seen {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var infos []LockInfo
+	for _, name := range names {
+		if info := (&MemoryLock{name: name, client: m}).info(); info.Acquired {
+			infos = append(infos, *info)
+		}
+	}
+	return infos
+}
+
+// MemorySnapshot is a copy of the contents of a MemoryStore, taken by
+// Snapshot. Expired locks, slots and waiters are left out.
+type MemorySnapshot struct {
+	// Time is when the snapshot was taken, on the clock of the store
+	Time time.Time
+	// Locks are the locks held in exclusive or shared mode, sorted by name
+	Locks []LockInfo
+	// Semaphores are the semaphores with slots held, sorted by name
+	Semaphores []LockInfo
+	// Tokens are the last fencing tokens issued, by lock name
+	Tokens map[string]uint64
+	// Queues are the IDs of the clients waiting in the fair queue of a lock,
+	// in arrival order, by lock name
+	Queues map[string][]string
+}
+
+// Lock returns the named lock of the snapshot, or nil if it was not held
+func (s *MemorySnapshot) Lock(name string) *LockInfo {
+	for i := range s.Locks {
+		if s.Locks[i].Name == name {
+			return &s.Locks[i]
+		}
+	}
+	return nil
+}
+
+// Snapshot returns a copy of the contents of the store, e.g. for tests to
+// assert on
+func (s *MemoryStore) Snapshot() *MemorySnapshot {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	m := s.inspector()
+	snap := &MemorySnapshot{
+		Time:   s.clock.Now(),
+		Locks:  s.list("", m),
+		Tokens: make(map[string]uint64),
+		Queues: make(map[string][]string),
+	}
+	var names []string
+	for name := range s.semaphores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if info := (&MemorySemaphore{name: name, client: m}).info(); info.Acquired {
+			snap.Semaphores = append(snap.Semaphores, *info)
+		}
+	}
+	for name, token := range s.tokens {
+		snap.Tokens[name] = token
+	}
+	for name := range s.queues {
+		for _, w := range s.queue(name, snap.Time) {
+			snap.Queues[name] = append(snap.Queues[name], w.id)
+		}
+	}
+	return snap
+}
 
-func initDB() {
-	if db == nil {
-		db = &locksDB{
-			mtx:   &sync.RWMutex{},
-			locks: make(map[string]*MemoryLock),
+// Inspect returns the information of the named lock, as seen by a client
+// which does not hold it nor waits for it
+func (s *MemoryStore) Inspect(name string) *LockInfo {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return (&MemoryLock{name: name, client: s.inspector()}).info()
+}
+
+// inspector returns a client of the store without ID, to read its locks.
+// s.mtx must be held.
+func (s *MemoryStore) inspector() *MemoryClient {
+	return &MemoryClient{store: s}
+}
+
+func liveHolders(all map[string]map[string]time.Time, name string, now time.Time) map[string]time.Time {
+	holders := all[name]
+	for id, expire := range holders {
+		if !expire.After(now) {
+			delete(holders, id)
 		}
 	}
+	if len(holders) == 0 {
+		delete(all, name)
+		return nil
+	}
+	return holders
 }
 
 type MemoryLock struct {
 	name   string
 	ttl    time.Duration
 	data   string
+	stored string // the data stored by the last acquisition or refresh
 	client *MemoryClient
-	timer  *time.Timer
+	timer  Timer
 	expire time.Time
+	token  uint64
+}
+
+type MemoryRWLock struct {
+	*MemoryLock
+}
+
+type MemorySemaphore struct {
+	name   string
+	slots  int
+	ttl    time.Duration
+	client *MemoryClient
 }
 
 type MemoryClient struct {
-	id string
+	id    string
+	store *MemoryStore
 }
 
+// NewMemoryClient returns a new client with the given id, attached to
+// DefaultMemoryStore
 func NewMemoryClient(id string) *MemoryClient {
-	initDB()
-	return &MemoryClient{id: id}
+	return DefaultMemoryStore.NewClient(id)
 }
 
 func (m *MemoryClient) Clone() Client {
-	return NewMemoryClient(m.id)
+	return m.store.NewClient(m.id)
+}
+
+// Store returns the store the client is attached to
+func (m *MemoryClient) Store() *MemoryStore {
+	return m.store
 }
 
 func (m *MemoryClient) Close() {
@@ -48,7 +352,11 @@ func (m *MemoryClient) Close() {
 }
 
 func (m *MemoryClient) Reconnect() error {
-	return nil
+	return m.ReconnectContext(context.Background())
+}
+
+func (m *MemoryClient) ReconnectContext(ctx context.Context) error {
+	return ctx.Err()
 }
 
 func (m *MemoryClient) SetID(id string) {
@@ -59,90 +367,420 @@ func (m *MemoryClient) ID() string {
 	return m.id
 }
 
+// Notify returns a channel which receives a value when the named lock is
+// acquired, released or expires.
+func (m *MemoryClient) Notify(ctx context.Context, name string) (<-chan struct{}, error) {
+	store := m.store
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ch := make(chan struct{}, 1)
+	store.mtx.Lock()
+	if store.watchers[name] == nil {
+		store.watchers[name] = make(map[chan struct{}]bool)
+	}
+	store.watchers[name][ch] = true
+	store.mtx.Unlock()
+	go func() {
+		<-ctx.Done()
+		store.mtx.Lock()
+		defer store.mtx.Unlock()
+		delete(store.watchers[name], ch)
+		if len(store.watchers[name]) == 0 {
+			delete(store.watchers, name)
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func (m *MemoryClient) List(prefix string) ([]LockInfo, error) {
+	return m.ListContext(context.Background(), prefix)
+}
+
+// ListContext returns the locks held in exclusive or shared mode whose name
+// starts with prefix, sorted by name.
+func (m *MemoryClient) ListContext(ctx context.Context, prefix string) ([]LockInfo, error) {
+	store := m.store
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	return store.list(prefix, m), nil
+}
+
+func (m *MemoryClient) ForceRelease(name, reason string) error {
+	return m.ForceReleaseContext(context.Background(), name, reason)
+}
+
+func (m *MemoryClient) ForceReleaseContext(ctx context.Context, name, reason string) error {
+	store := m.store
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	store.evict(name, adminRecord("released", m.id, reason), store.clock.Now())
+	return nil
+}
+
+func (m *MemoryClient) Steal(name string, ttl time.Duration, reason string) (Lock, error) {
+	return m.StealContext(context.Background(), name, ttl, reason)
+}
+
+func (m *MemoryClient) StealContext(ctx context.Context, name string, ttl time.Duration, reason string) (Lock, error) {
+	store := m.store
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if ttl <= time.Millisecond {
+		return nil, ErrInvalidTTL
+	}
+	l := &MemoryLock{name: name, client: m, ttl: ttl, data: adminRecord("stolen", m.id, reason)}
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	store.evict(name, l.data, store.clock.Now())
+	l.take()
+	return l, nil
+}
+
 func (m *MemoryClient) NewLock(name string) Lock {
 	return &MemoryLock{name: name, client: m}
 }
 
+func (m *MemoryClient) NewRWLock(name string) RWLock {
+	return &MemoryRWLock{&MemoryLock{name: name, client: m}}
+}
+
+func (m *MemoryClient) NewSemaphore(name string, slots int) Semaphore {
+	return &MemorySemaphore{name: name, slots: slots, client: m}
+}
+
 func (l *MemoryLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+func (l *MemoryLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	store := l.client.store
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if ttl <= time.Millisecond {
 		return ErrInvalidTTL
 	}
 	l.ttl = ttl
-	db.mtx.Lock()
-	defer db.mtx.Unlock()
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
 
-	_, ok := db.locks[l.name]
-	switch ok {
-	case true:
+	if !l.free() {
 		return ErrLockHeldByOtherClient
+	}
+	l.take()
+	return nil
+}
+
+// free tells if the lock is held by nobody, and no other client is waiting
+// for it before this one. The mutex of the store must be held.
+func (l *MemoryLock) free() bool {
+	store := l.client.store
+	now := store.clock.Now()
+	_, ok := store.lock(l.name, now)
+	return !ok && store.holders(l.name, now) == nil && store.turn(l.name, l.client.id, now)
+}
+
+// take acquires the free lock for its ttl, taking the client out of the
+// queue. The mutex of the store must be held.
+func (l *MemoryLock) take() {
+	store := l.client.store
+	now := store.clock.Now()
+	store.dequeue(l.name, l.client.id, now)
+	delete(store.thefts(l.name, now), l.client.id)
+	l.stored = l.data
+	l.expire = now.Add(l.ttl)
+	l.schedule(l.ttl)
+	store.tokens[l.name]++
+	l.token = store.tokens[l.name]
+	store.locks[l.name] = l
+	store.notify(l.name)
+}
 
-	case false:
-		l.timer = time.AfterFunc(l.ttl, func() {
-			db.mtx.Lock()
-			defer db.mtx.Unlock()
-			delete(db.locks, l.name)
+// schedule arms the timer forgetting the lock once it expires, after ttl.
+// The mutex of the store must be held.
+func (l *MemoryLock) schedule(ttl time.Duration) {
+	if l.timer != nil {
+		l.timer.Reset(ttl)
+		return
+	}
+	store := l.client.store
+	clock := store.clock
+	l.timer = clock.AfterFunc(ttl, func() {
+		store.mtx.Lock()
+		defer store.mtx.Unlock()
+		// the lock might have been released or taken again meanwhile, and
+		// refreshed after the timer fired
+		if store.locks[l.name] != l {
+			return
+		}
+		if _, ok := store.lock(l.name, clock.Now()); !ok {
 			l.timer = nil
-		})
-		l.expire = time.Now().Add(l.ttl)
-		db.locks[l.name] = l
+		}
+	})
+}
+
+// stop stops the timer of the lock, if any. The mutex of the store must be
+// held.
+func (l *MemoryLock) stop() {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+}
+
+func (l *MemoryLock) AcquireQueued(ttl time.Duration) error {
+	return l.AcquireQueuedContext(context.Background(), ttl)
+}
+
+// AcquireQueuedContext is like AcquireContext, but if the lock is held it
+// waits in the queue for its turn: waiters get the lock in arrival order.
+// While waiting, the entry of the client is refreshed every ttl/2, and the
+// client leaves the queue as soon as ctx is done.
+func (l *MemoryLock) AcquireQueuedContext(ctx context.Context, ttl time.Duration) error {
+	store := l.client.store
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ttl <= time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	notify, err := l.client.Notify(wctx, l.name)
+	if err != nil {
+		return err
+	}
+
+	for {
+		store.mtx.Lock()
+		if err := ctx.Err(); err != nil {
+			store.dequeue(l.name, l.client.id, store.clock.Now())
+			store.mtx.Unlock()
+			return err
+		}
+		store.enqueue(l.name, l.client.id, ttl, store.clock.Now())
+		if l.free() {
+			l.take()
+			store.mtx.Unlock()
+			return nil
+		}
+		timer := store.clock.NewTimer(l.wait(ttl / 2))
+		store.mtx.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C():
+		case <-ctx.Done():
+		}
+		timer.Stop()
+	}
+}
+
+// wait returns how long a waiter can sleep, up to max, before the holder
+// or the waiters ahead of it expire. The mutex of the store must be held.
+func (l *MemoryLock) wait(max time.Duration) time.Duration {
+	store := l.client.store
+	now := store.clock.Now()
+	var expire []time.Time
+	if lock, ok := store.lock(l.name, now); ok {
+		expire = append(expire, lock.expire)
+	}
+	for _, t := range store.holders(l.name, now) {
+		expire = append(expire, t)
+	}
+	for _, w := range store.queue(l.name, now) {
+		if w.id == l.client.id {
+			break
+		}
+		expire = append(expire, w.expire)
+	}
+	for _, t := range expire {
+		if left := t.Sub(now) + time.Millisecond; left < max {
+			max = left
+		}
+	}
+	return max
+}
+
+func (m *MemoryClient) AcquireAllContext(ctx context.Context, locks []Lock, ttl time.Duration) error {
+	store := m.store
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ttl <= time.Millisecond {
+		return ErrInvalidTTL
+	}
+	mlocks := make([]*MemoryLock, len(locks))
+	for i, lock := range locks {
+		l, ok := lock.(*MemoryLock)
+		if !ok {
+			return ErrNotSupported
+		}
+		mlocks[i] = l
+	}
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	for _, l := range mlocks {
+		if !l.free() {
+			return ErrLockHeldByOtherClient
+		}
+	}
+	for _, l := range mlocks {
+		l.ttl = ttl
+		l.take()
 	}
 	return nil
 }
 
 func (l *MemoryLock) Release() error {
-	db.mtx.Lock()
-	defer db.mtx.Unlock()
-	lock, ok := db.locks[l.name]
-	if !ok {
-		return ErrLockNotOwned
+	return l.ReleaseContext(context.Background())
+}
+
+func (l *MemoryLock) ReleaseContext(ctx context.Context) error {
+	store := l.client.store
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	if lock.client.id != l.client.id {
-		return ErrLockNotOwned
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	now := store.clock.Now()
+	if holders := store.holders(l.name, now); holders != nil {
+		if _, ok := holders[l.client.id]; !ok {
+			return l.notOwned()
+		}
+		delete(holders, l.client.id)
+		store.notify(l.name)
+		return nil
 	}
-	lock.timer.Stop()
-	delete(db.locks, l.name)
-	l.timer = nil
+	lock, ok := store.lock(l.name, now)
+	if !ok || lock.client.id != l.client.id {
+		return l.notOwned()
+	}
+	lock.stop()
+	delete(store.locks, l.name)
+	store.notify(l.name)
 	return nil
 }
 
+// notOwned returns ErrLockStolen if the client was evicted from the lock
+// during its lease, ErrLockNotOwned otherwise. The mutex of the store must
+// be held.
+func (l *MemoryLock) notOwned() error {
+	store := l.client.store
+	if _, ok := store.thefts(l.name, store.clock.Now())[l.client.id]; ok {
+		return ErrLockStolen
+	}
+	return ErrLockNotOwned
+}
+
 func (l *MemoryLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+func (l *MemoryLock) RefreshContext(ctx context.Context) error {
+	store := l.client.store
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if l.ttl <= time.Millisecond {
 		return ErrInvalidTTL
 	}
 
-	db.mtx.Lock()
-	defer db.mtx.Unlock()
-	lock, ok := db.locks[l.name]
-	if !ok {
-		return ErrLockNotOwned
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	now := store.clock.Now()
+	if holders := store.holders(l.name, now); holders != nil {
+		if _, ok := holders[l.client.id]; !ok {
+			return l.notOwned()
+		}
+		holders[l.client.id] = now.Add(l.ttl)
+		return nil
 	}
-	if lock.client.id != l.client.id {
-		return ErrLockNotOwned
+	lock, ok := store.lock(l.name, now)
+	if !ok || lock.client.id != l.client.id {
+		return l.notOwned()
 	}
-	lock.timer.Reset(lock.ttl)
-	lock.expire = time.Now().Add(lock.ttl)
+	// the lock might be refreshed through another object, e.g. by a clone
+	lock.stored = l.data
+	lock.expire = now.Add(l.ttl)
+	lock.schedule(l.ttl)
 	return nil
 }
 
 func (l *MemoryLock) RefreshTTL(ttl time.Duration) error {
+	return l.RefreshTTLContext(context.Background(), ttl)
+}
+
+func (l *MemoryLock) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
 	l.ttl = ttl
-	return l.Refresh()
+	return l.RefreshContext(ctx)
 }
 
 func (l *MemoryLock) Info() (*LockInfo, error) {
-	db.mtx.Lock()
-	defer db.mtx.Unlock()
-	lock, ok := db.locks[l.name]
-	if !ok {
-		return &LockInfo{Name: l.name, Acquired: false}, nil
+	return l.InfoContext(context.Background())
+}
+
+func (l *MemoryLock) InfoContext(ctx context.Context) (*LockInfo, error) {
+	store := l.client.store
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	return l.info(), nil
+}
+
+// info returns the information of the lock. The mutex of the store must be
+// held.
+func (l *MemoryLock) info() *LockInfo {
+	store := l.client.store
+	now := store.clock.Now()
+	info := &LockInfo{Name: l.name}
+	if holders := store.holders(l.name, now); holders != nil {
+		info.Acquired = true
+		info.Shared = true
+		for id, expire := range holders {
+			info.Holders = append(info.Holders, id)
+			if ttl := expire.Sub(now); ttl > info.TTL {
+				info.TTL = ttl
+			}
+		}
+		sort.Strings(info.Holders)
+	} else if lock, ok := store.lock(l.name, now); ok {
+		info.Acquired = true
+		info.Owner = lock.client.id
+		info.TTL = lock.expire.Sub(now)
+		info.Data = lock.stored
+		info.Token = lock.token
+	} else if store.thefts(l.name, now) != nil {
+		info.Data = store.records[l.name]
+	}
+	l.queueInfo(info)
+	return info
+}
+
+// queueInfo fills in the queue of the lock. The mutex of the store must be
+// held.
+func (l *MemoryLock) queueInfo(info *LockInfo) {
+	store := l.client.store
+	queue := store.queue(l.name, store.clock.Now())
+	info.Queued = len(queue)
+	for i, w := range queue {
+		if w.id == l.client.id {
+			info.Position = i + 1
+		}
 	}
-	return &LockInfo{
-		Name:     l.name,
-		Acquired: true,
-		Owner:    lock.client.id,
-		TTL:      lock.expire.Sub(time.Now()),
-		Data:     lock.data,
-	}, nil
 }
 
 // SetData sets the data payload for the lock.
@@ -151,3 +789,175 @@ func (l *MemoryLock) Info() (*LockInfo, error) {
 func (l *MemoryLock) SetData(data string) {
 	l.data = data
 }
+
+func (l *MemoryLock) Token() uint64 {
+	return l.token
+}
+
+func (l *MemoryRWLock) AcquireExclusive(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+func (l *MemoryRWLock) AcquireExclusiveContext(ctx context.Context, ttl time.Duration) error {
+	return l.AcquireContext(ctx, ttl)
+}
+
+func (l *MemoryRWLock) AcquireShared(ttl time.Duration) error {
+	return l.AcquireSharedContext(context.Background(), ttl)
+}
+
+func (l *MemoryRWLock) AcquireSharedContext(ctx context.Context, ttl time.Duration) error {
+	store := l.client.store
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ttl <= time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	now := store.clock.Now()
+	if _, ok := store.lock(l.name, now); ok || !store.turn(l.name, l.client.id, now) {
+		return ErrLockHeldByOtherClient
+	}
+	store.dequeue(l.name, l.client.id, now)
+	delete(store.thefts(l.name, now), l.client.id)
+	holders := store.holders(l.name, now)
+	if holders == nil {
+		holders = make(map[string]time.Time)
+		store.shared[l.name] = holders
+	}
+	holders[l.client.id] = now.Add(ttl)
+	l.token = 0
+	store.notify(l.name)
+	return nil
+}
+
+func (s *MemorySemaphore) Slots() int {
+	return s.slots
+}
+
+func (s *MemorySemaphore) Acquire(ttl time.Duration) error {
+	return s.AcquireContext(context.Background(), ttl)
+}
+
+func (s *MemorySemaphore) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	store := s.client.store
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.slots <= 0 {
+		return ErrInvalidSlots
+	}
+	if ttl <= time.Millisecond {
+		return ErrInvalidTTL
+	}
+	s.ttl = ttl
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	now := store.clock.Now()
+	slots := store.slots(s.name, now)
+	if slots == nil {
+		slots = make(map[string]time.Time)
+		store.semaphores[s.name] = slots
+	}
+	if _, ok := slots[s.client.id]; !ok && len(slots) >= s.slots {
+		return ErrLockHeldByOtherClient
+	}
+	slots[s.client.id] = now.Add(ttl)
+	return nil
+}
+
+func (s *MemorySemaphore) Release() error {
+	return s.ReleaseContext(context.Background())
+}
+
+func (s *MemorySemaphore) ReleaseContext(ctx context.Context) error {
+	store := s.client.store
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	slots := store.slots(s.name, store.clock.Now())
+	if _, ok := slots[s.client.id]; !ok {
+		return ErrLockNotOwned
+	}
+	delete(slots, s.client.id)
+	return nil
+}
+
+func (s *MemorySemaphore) Refresh() error {
+	return s.RefreshContext(context.Background())
+}
+
+func (s *MemorySemaphore) RefreshContext(ctx context.Context) error {
+	store := s.client.store
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.ttl <= time.Millisecond {
+		return ErrInvalidTTL
+	}
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	now := store.clock.Now()
+	slots := store.slots(s.name, now)
+	if _, ok := slots[s.client.id]; !ok {
+		return ErrLockNotOwned
+	}
+	slots[s.client.id] = now.Add(s.ttl)
+	return nil
+}
+
+func (s *MemorySemaphore) RefreshTTL(ttl time.Duration) error {
+	return s.RefreshTTLContext(context.Background(), ttl)
+}
+
+func (s *MemorySemaphore) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
+	s.ttl = ttl
+	return s.RefreshContext(ctx)
+}
+
+func (s *MemorySemaphore) Info() (*LockInfo, error) {
+	return s.InfoContext(context.Background())
+}
+
+func (s *MemorySemaphore) InfoContext(ctx context.Context) (*LockInfo, error) {
+	store := s.client.store
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	return s.info(), nil
+}
+
+// info returns the information of the semaphore. The mutex of the store
+// must be held.
+func (s *MemorySemaphore) info() *LockInfo {
+	store := s.client.store
+	now := store.clock.Now()
+	info := &LockInfo{Name: s.name}
+	for id, expire := range store.slots(s.name, now) {
+		info.Holders = append(info.Holders, id)
+		if ttl := expire.Sub(now); info.TTL == 0 || ttl < info.TTL {
+			info.TTL = ttl
+		}
+	}
+	sort.Strings(info.Holders)
+	info.Acquired = len(info.Holders) > 0
+	info.Shared = info.Acquired
+	return info
+}
+
+// SetData is a no-op, as slots carry no data.
+func (s *MemorySemaphore) SetData(data string) {
+}
+
+func (s *MemorySemaphore) Token() uint64 {
+	return 0
+}
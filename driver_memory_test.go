@@ -3,6 +3,10 @@
 package glock
 
 import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,6 +19,10 @@ func memoryClient(t *testing.T) Client {
 	return NewMemoryClient(gocql.TimeUUID().String())
 }
 
+func init() {
+	Drivers["memory"] = Driver{memoryClient, memoryScale}
+}
+
 func TestMemoryManagerAcquire(t *testing.T) {
 	testManagerAcquire(t, memoryClient, memoryScale)
 }
@@ -31,6 +39,283 @@ func TestMemoryClient(t *testing.T) {
 	testClient(t, memoryClient)
 }
 
+func TestMemoryClientList(t *testing.T) {
+	testClientList(t, memoryClient, memoryScale)
+}
+
+func TestMemoryClientAdmin(t *testing.T) {
+	testClientAdmin(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerStolen(t *testing.T) {
+	testManagerStolen(t, memoryClient, memoryScale)
+}
+
 func TestMemoryLock(t *testing.T) {
 	testLock(t, memoryClient, memoryScale)
 }
+
+func TestMemoryLockContext(t *testing.T) {
+	testLockContext(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerAcquireContext(t *testing.T) {
+	testManagerAcquireContext(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerConcurrent(t *testing.T) {
+	testManagerConcurrent(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerAcquireAll(t *testing.T) {
+	testManagerAcquireAll(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerWatch(t *testing.T) {
+	testManagerWatch(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerKeeper(t *testing.T) {
+	testManagerKeeper(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerLease(t *testing.T) {
+	testManagerLease(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerReentrant(t *testing.T) {
+	testManagerReentrant(t, memoryClient, memoryScale)
+}
+
+func TestMemoryElection(t *testing.T) {
+	testElection(t, memoryClient, memoryScale)
+}
+
+func TestMemoryLockToken(t *testing.T) {
+	testLockToken(t, memoryClient, memoryScale)
+}
+
+func TestMemoryLockFairQueue(t *testing.T) {
+	testLockFairQueue(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerFair(t *testing.T) {
+	testManagerFair(t, memoryClient, memoryScale)
+}
+
+func TestMemoryRWLock(t *testing.T) {
+	testRWLock(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerShared(t *testing.T) {
+	testManagerShared(t, memoryClient, memoryScale)
+}
+
+func TestMemorySemaphore(t *testing.T) {
+	testSemaphore(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerSemaphore(t *testing.T) {
+	testManagerSemaphore(t, memoryClient, memoryScale)
+}
+
+var errStoreDown = errors.New("store down")
+
+// flakyClient is a memory client whose locks cannot be refreshed while down is set
+type flakyClient struct {
+	*MemoryClient
+	down *int32
+}
+
+type flakyLock struct {
+	Lock
+	down *int32
+}
+
+func (c *flakyClient) Clone() Client {
+	return &flakyClient{c.MemoryClient.Clone().(*MemoryClient), c.down}
+}
+
+func (c *flakyClient) NewLock(name string) Lock {
+	return &flakyLock{c.MemoryClient.NewLock(name), c.down}
+}
+
+func (l *flakyLock) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
+	if atomic.LoadInt32(l.down) != 0 {
+		return errStoreDown
+	}
+	return l.Lock.RefreshTTLContext(ctx, ttl)
+}
+
+func TestMemoryKeeperDegraded(t *testing.T) {
+	scale := 10 * memoryScale
+	ttl := time.Duration(ttlLength) * scale
+	down := new(int32)
+	c := &flakyClient{NewMemoryClient(gocql.TimeUUID().String()), down}
+	m := NewLockManager(c, options(scale, ttlLength, 0, defData))
+	defer m.ReleaseAll()
+
+	if err := m.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	opts, events := leaseEvents(StopOnLoss, ttl)
+	opts.MinBackoff = scale
+	if _, err := m.KeepLease(lockName, opts); err != nil {
+		t.Fatalf("Cannot keep lease: %s", err)
+	}
+
+	// Failed refreshes are retried while the lease lasts
+	atomic.StoreInt32(down, 1)
+	ev := waitEvent(t, events, LeaseDegraded, ttl)
+	if ev.Err != errStoreDown || ev.Attempt != 1 {
+		t.Errorf("Invalid degraded event: %+v", ev)
+	}
+	ev = waitEvent(t, events, LeaseDegraded, ttl)
+	if ev.Attempt != 2 {
+		t.Errorf("Degraded events should count the attempts, got %+v", ev)
+	}
+	atomic.StoreInt32(down, 0)
+	waitEvent(t, events, LeaseRefreshed, ttl)
+	if res := info(t, m); !res.Acquired || res.Owner != c.ID() {
+		t.Errorf("info: %+v -- expected Acquired: true and Owner: %s", res, c.ID())
+	}
+
+	// The lease is lost when it expires before a refresh succeeds
+	atomic.StoreInt32(down, 1)
+	ev = waitEvent(t, events, LeaseLost, ttl*2)
+	if ev.Err != errStoreDown || ev.Expires.After(ev.Time) {
+		t.Errorf("Invalid lost event: %+v", ev)
+	}
+}
+
+func TestMemoryManagerAcquireAllWrapped(t *testing.T) {
+	// the wrapper inherits AcquireAllContext, but its locks are its own
+	c := &flakyClient{NewMemoryClient(gocql.TimeUUID().String()), new(int32)}
+	m := NewLockManager(c, options(memoryScale, ttlLength, 0, defData))
+	defer m.ReleaseAll()
+
+	names := []string{"wrapped-1", "wrapped-2"}
+	if err := m.AcquireAll(names, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire locks: %s", err)
+	}
+	for _, n := range names {
+		if res, err := m.Info(n); err != nil || !res.Acquired || res.Owner != c.ID() {
+			t.Errorf("info: %+v, %v -- expected Acquired: true and Owner: %s", res, err, c.ID())
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s1, s2 := NewMemoryStore(), NewMemoryStore()
+	c1, c2 := s1.NewClient("client1"), s2.NewClient("client2")
+	ttl := time.Duration(ttlLength) * memoryScale
+
+	// Stores do not share locks, nor with the default store
+	if err := c1.NewLock(lockName).Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	if err := c2.NewLock(lockName).Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock held in another store: %s", err)
+	}
+	if info := DefaultMemoryStore.Inspect(lockName); info.Acquired {
+		t.Errorf("Lock of another store held in the default store: %+v", info)
+	}
+	if clone := c1.Clone().(*MemoryClient); clone.Store() != s1 {
+		t.Errorf("Clone should be attached to the store of the client")
+	}
+
+	if err := c1.NewRWLock("shared").AcquireShared(ttl); err != nil {
+		t.Fatalf("Cannot acquire shared lock: %s", err)
+	}
+	if err := c1.NewSemaphore("semaphore", 2).Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire slot: %s", err)
+	}
+	info := s1.Inspect(lockName)
+	if !info.Acquired || info.Owner != "client1" || info.Token != 1 || info.TTL <= 0 {
+		t.Errorf("info: %+v -- expected Acquired: true, Owner: client1 and Token: 1", info)
+	}
+
+	// Clients waiting in the queue show up in snapshots
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s1.NewClient("client3").NewLock(lockName).(*MemoryLock).AcquireQueuedContext(ctx, ttl)
+	for start := time.Now(); s1.Inspect(lockName).Queued == 0; time.Sleep(memoryScale) {
+		if time.Since(start) > ttl {
+			t.Fatalf("Client not queued")
+		}
+	}
+
+	snap := s1.Snapshot()
+	if len(snap.Locks) != 2 || snap.Lock(lockName) == nil || snap.Lock("shared") == nil {
+		t.Fatalf("Expected locks '%s' and 'shared' in snapshot, got %+v", lockName, snap.Locks)
+	}
+	if shared := snap.Lock("shared"); !shared.Shared || !reflect.DeepEqual(shared.Holders, []string{"client1"}) {
+		t.Errorf("Expected 'shared' held in shared mode by client1, got %+v", shared)
+	}
+	if len(snap.Semaphores) != 1 || !reflect.DeepEqual(snap.Semaphores[0].Holders, []string{"client1"}) {
+		t.Errorf("Expected semaphore held by client1, got %+v", snap.Semaphores)
+	}
+	if snap.Tokens[lockName] != 1 {
+		t.Errorf("Expected token 1 for lock '%s', got %d", lockName, snap.Tokens[lockName])
+	}
+	if !reflect.DeepEqual(snap.Queues[lockName], []string{"client3"}) {
+		t.Errorf("Expected client3 in the queue of lock '%s', got %v", lockName, snap.Queues)
+	}
+
+	// Snapshots are copies, and leave expired locks out
+	cancel()
+	if err := c1.NewLock(lockName).Release(); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+	if snap.Lock(lockName) == nil {
+		t.Errorf("Snapshot changed after the store did")
+	}
+	time.Sleep(ttl + memoryScale)
+	if snap = s1.Snapshot(); len(snap.Locks) != 0 || len(snap.Semaphores) != 0 || len(snap.Queues) != 0 {
+		t.Errorf("Expected empty snapshot after expiration, got %+v", snap)
+	}
+}
+
+// manualClock is a clock whose AfterFunc timers never fire by themselves,
+// so that a test can call them at any point
+type manualClock struct {
+	now    time.Time
+	timers []func()
+}
+
+type manualTimer struct{}
+
+func (c *manualClock) Now() time.Time                 { return c.now }
+func (c *manualClock) NewTimer(d time.Duration) Timer { return manualTimer{} }
+func (manualTimer) C() <-chan time.Time               { return nil }
+func (manualTimer) Stop() bool                        { return true }
+func (manualTimer) Reset(d time.Duration) bool        { return true }
+
+func (c *manualClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.timers = append(c.timers, f)
+	return manualTimer{}
+}
+
+func TestMemoryLockLateTimer(t *testing.T) {
+	clock := &manualClock{now: time.Now()}
+	store := NewMemoryStoreWithClock(clock)
+	lock := store.NewClient(gocql.TimeUUID().String()).NewLock("late-timer")
+
+	if err := lock.Acquire(time.Hour); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	// the lock expires and is acquired again before its timer runs
+	clock.now = clock.now.Add(time.Hour)
+	if err := lock.Acquire(time.Hour); err != nil {
+		t.Fatalf("Cannot acquire expired lock: %s", err)
+	}
+	for _, f := range clock.timers {
+		f()
+	}
+	if err := lock.Refresh(); err != nil {
+		t.Fatalf("Cannot refresh lock: %s", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+}
@@ -0,0 +1,179 @@
+// +build postgres
+
+package glock
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+var postgresDSN = flag.String("postgres-dsn", "postgres://postgres@127.0.0.1/glock_test?sslmode=disable", "Postgres connection string")
+
+func postgresClient(t *testing.T) Client {
+	opts := PostgresOptions{
+		DSN:       *postgresDSN,
+		TableName: "locks",
+	}
+	c, err := NewPostgresClient(opts)
+	if err != nil {
+		t.Fatalf("Cannot create postgres client: %s", err)
+	}
+	return c
+}
+
+func postgresAdvisoryClient(t *testing.T) Client {
+	opts := PostgresOptions{
+		DSN:       *postgresDSN,
+		TableName: "advisory_locks",
+		Advisory:  true,
+	}
+	c, err := NewPostgresClient(opts)
+	if err != nil {
+		t.Fatalf("Cannot create postgres client: %s", err)
+	}
+	return c
+}
+
+func init() {
+	Drivers["postgres"] = Driver{postgresClient, time.Millisecond}
+	Drivers["postgres-advisory"] = Driver{postgresAdvisoryClient, time.Millisecond}
+}
+
+func TestPostgresClient(t *testing.T) {
+	testClient(t, postgresClient)
+}
+
+func TestPostgresLock(t *testing.T) {
+	testLock(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresLockContext(t *testing.T) {
+	testLockContext(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresLockToken(t *testing.T) {
+	testLockToken(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresManagerAcquire(t *testing.T) {
+	testManagerAcquire(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresManagerAcquireWait(t *testing.T) {
+	testManagerAcquireWait(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresManagerFailReleaseAll(t *testing.T) {
+	testManagerFailReleaseAll(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresManagerAcquireContext(t *testing.T) {
+	testManagerAcquireContext(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresManagerConcurrent(t *testing.T) {
+	testManagerConcurrent(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresManagerAcquireAll(t *testing.T) {
+	testManagerAcquireAll(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresManagerWatch(t *testing.T) {
+	testManagerWatch(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresManagerKeeper(t *testing.T) {
+	testManagerKeeper(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresManagerLease(t *testing.T) {
+	testManagerLease(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresManagerReentrant(t *testing.T) {
+	testManagerReentrant(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresElection(t *testing.T) {
+	testElection(t, postgresClient, time.Millisecond)
+}
+
+func TestPostgresAdvisoryClient(t *testing.T) {
+	testClient(t, postgresAdvisoryClient)
+}
+
+func TestPostgresAdvisoryLockContext(t *testing.T) {
+	testLockContext(t, postgresAdvisoryClient, time.Millisecond)
+}
+
+func TestPostgresAdvisory(t *testing.T) {
+	ttl := time.Duration(ttlLength) * time.Millisecond
+	c1 := postgresAdvisoryClient(t)
+	c2 := postgresAdvisoryClient(t)
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+	lock1.SetData("client1")
+
+	err := lock1.Acquire(ttl)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	token := lock1.Token()
+	if err = lock1.Acquire(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+	if err = lock2.Acquire(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+	if err = lock2.Refresh(); err != ErrLockNotOwned && err != ErrInvalidTTL {
+		t.Errorf("Refreshing a lock not held should fail, got '%s'", err)
+	}
+	if err = lock2.Release(); err != ErrLockNotOwned {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockNotOwned, err)
+	}
+	if err = lock1.Refresh(); err != nil {
+		t.Errorf("Error while refreshing lock: '%s'", err)
+	}
+
+	info, err := lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if !info.Acquired || info.Owner != c1.ID() || info.Data != "client1" || info.Token != token {
+		t.Errorf("Lock should be held by '%s' with token %d, got %+v", c1.ID(), token, info)
+	}
+
+	// The advisory lock outlives its TTL, which is reported as 0
+	time.Sleep(ttl * 2)
+	info, err = lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if !info.Acquired || info.TTL != 0 {
+		t.Errorf("Lock should be held with TTL 0 once expired, got %+v", info)
+	}
+
+	// The advisory lock goes away with the session of the holder
+	c1.Close()
+	info, err = lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Acquired {
+		t.Errorf("Lock should be released when the holder disconnects, got %+v", info)
+	}
+	if err = lock2.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock after the holder disconnected: %s", err)
+	}
+	if lock2.Token() <= token {
+		t.Errorf("Tokens should increase: %d <= %d", lock2.Token(), token)
+	}
+	if err = lock2.Release(); err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+	if err = lock2.Release(); err != ErrLockNotOwned {
+		t.Errorf("Releasing a lock twice should return '%s', got '%s'", ErrLockNotOwned, err)
+	}
+}
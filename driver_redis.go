@@ -1,34 +1,312 @@
 package glock
 
 import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/gocql/gocql"
 )
 
+// Shared holders of a lock are kept in a set, and each holder has its own
+// expiring key named after the set, i.e. "<set>:<client id>".
+// holdersLua returns the live holders with their ttl, pruning the expired ones.
+const holdersLua = `
+local function holders(set)
+	local live = {}
+	for _, id in ipairs(redis.call("smembers", set)) do
+		local ttl = redis.call("pttl", set .. ":" .. id)
+		if ttl > 0 then
+			table.insert(live, {id, ttl})
+		else
+			redis.call("srem", set, id)
+		end
+	end
+	return live
+end
+`
+
+// Clients waiting for a lock in fair mode are kept in a sorted set named
+// after the lock key, i.e. "<key>:queue", scored by arrival. Each waiter has
+// its own expiring key like shared holders, i.e. "<key>:queue:<client id>".
+// queue returns the live waiters in arrival order, pruning the expired ones.
+// turn tells if a client can take the lock without jumping the queue, and
+// dequeue takes a client out of it.
+const queueLua = `
+local function queue(key)
+	local zset = key .. ":queue"
+	local live = {}
+	for _, id in ipairs(redis.call("zrange", zset, 0, -1)) do
+		if redis.call("exists", zset .. ":" .. id) == 1 then
+			table.insert(live, id)
+		else
+			redis.call("zrem", zset, id)
+		end
+	end
+	return live
+end
+local function turn(key, id)
+	local live = queue(key)
+	return #live == 0 or live[1] == id
+end
+local function dequeue(key, id)
+	local zset = key .. ":queue"
+	if redis.call("zrem", zset, id) == 1 then
+		redis.call("del", zset .. ":" .. id)
+		redis.call("publish", key .. ":events", "dequeued")
+	end
+end
+`
+
+// Clients evicted from a lock by an administrator are kept like shared
+// holders in a set named after the lock key, i.e. "<key>:stolen", and the
+// expiring key of each of them, i.e. "<key>:stolen:<client id>", holds the
+// record of the eviction until the lease of the client would have ended.
+// The record of the last eviction is kept in "<key>:record" as long as any
+// of them. evict releases a lock whatever its holders, stolen returns -1 if a client
+// was evicted and 0 otherwise, and pardon forgets a client when it acquires
+// the lock again.
+const stolenLua = `
+local function evict(key, shared, record)
+	local evicted = {}
+	local owner = redis.call("get", key)
+	if owner then
+		table.insert(evicted, {owner, redis.call("pttl", key)})
+		redis.call("del", key, key .. ":data")
+	end
+	for _, h in ipairs(holders(shared)) do
+		table.insert(evicted, h)
+		redis.call("del", shared .. ":" .. h[1])
+	end
+	redis.call("del", shared)
+	local stolen = key .. ":stolen"
+	for _, h in ipairs(evicted) do
+		local ttl = math.max(h[2], 1)
+		redis.call("sadd", stolen, h[1])
+		redis.call("set", stolen .. ":" .. h[1], record, "PX", ttl)
+		if redis.call("pttl", stolen) < ttl then
+			redis.call("pexpire", stolen, ttl)
+		end
+	end
+	if #evicted > 0 then
+		redis.call("set", key .. ":record", record, "PX", redis.call("pttl", stolen))
+		redis.call("publish", key .. ":events", "released")
+	end
+end
+local function stolen(key, id)
+	if redis.call("exists", key .. ":stolen:" .. id) == 1 then
+		return -1
+	end
+	return 0
+end
+local function pardon(key, id)
+	if redis.call("srem", key .. ":stolen", id) == 1 then
+		redis.call("del", key .. ":stolen:" .. id)
+	end
+end
+`
+
+// Scripts which acquire or release a lock publish an event on the channel
+// named after the lock key, i.e. "<key>:events", for the watchers of the lock.
 const (
-	releaseScriptText = `
+	acquireScriptText = holdersLua + queueLua + stolenLua + acquireLua
+	acquireLua        = `
+if redis.call("exists", KEYS[1]) == 1 or #holders(KEYS[4]) > 0 or not turn(KEYS[1], ARGV[1]) then
+	return 0
+end
+dequeue(KEYS[1], ARGV[1])
+pardon(KEYS[1], ARGV[1])
+redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2])
+redis.call("set", KEYS[2], ARGV[3])
+redis.call("publish", KEYS[1] .. ":events", "acquired")
+return redis.call("incr", KEYS[3])
+`
+	// acquireQueuedScriptText adds the client to the queue, or refreshes its
+	// entry, before trying to acquire the lock like acquireScriptText
+	acquireQueuedScriptText = holdersLua + queueLua + stolenLua + `
+local zset = KEYS[1] .. ":queue"
+if not redis.call("zscore", zset, ARGV[1]) then
+	local last = redis.call("zrange", zset, -1, -1, "withscores")
+	redis.call("zadd", zset, (tonumber(last[2]) or 0) + 1, ARGV[1])
+end
+redis.call("set", zset .. ":" .. ARGV[1], ARGV[1], "PX", ARGV[2])
+if redis.call("pttl", zset) < tonumber(ARGV[2]) then
+	redis.call("pexpire", zset, ARGV[2])
+end
+` + acquireLua
+	dequeueScriptText = queueLua + `
+dequeue(KEYS[1], ARGV[1])
+return 1
+`
+	// acquireAllScriptText takes the keys of acquireScriptText for every lock,
+	// and the data of every lock after owner and ttl.
+	acquireAllScriptText = holdersLua + queueLua + stolenLua + `
+for i = 1, #KEYS, 4 do
+	if redis.call("exists", KEYS[i]) == 1 or #holders(KEYS[i + 3]) > 0 or not turn(KEYS[i], ARGV[1]) then
+		return {}
+	end
+end
+local tokens = {}
+for i = 1, #KEYS, 4 do
+	dequeue(KEYS[i], ARGV[1])
+	pardon(KEYS[i], ARGV[1])
+	redis.call("set", KEYS[i], ARGV[1], "PX", ARGV[2])
+	redis.call("set", KEYS[i + 1], ARGV[3 + (i - 1) / 4])
+	table.insert(tokens, redis.call("incr", KEYS[i + 2]))
+	redis.call("publish", KEYS[i] .. ":events", "acquired")
+end
+return tokens
+`
+	acquireSharedScriptText = holdersLua + queueLua + stolenLua + `
+if redis.call("exists", KEYS[1]) == 1 or not turn(KEYS[1], ARGV[1]) then
+	return 0
+end
+dequeue(KEYS[1], ARGV[1])
+pardon(KEYS[1], ARGV[1])
+redis.call("sadd", KEYS[2], ARGV[1])
+redis.call("set", KEYS[2] .. ":" .. ARGV[1], ARGV[1], "PX", ARGV[2])
+if redis.call("pttl", KEYS[2]) < tonumber(ARGV[2]) then
+	redis.call("pexpire", KEYS[2], ARGV[2])
+end
+redis.call("publish", KEYS[1] .. ":events", "acquired")
+return 1
+`
+	// releaseScriptText and refreshScriptText return 1 if the lock is owned,
+	// otherwise what stolen returns
+	releaseScriptText = holdersLua + stolenLua + `
 if redis.call("get", KEYS[1]) == ARGV[1] then
   redis.call("del", KEYS[1])
 	redis.call("del", KEYS[2])
+	redis.call("publish", KEYS[1] .. ":events", "released")
 	return 1
 end
-return 0
+if redis.call("del", KEYS[3] .. ":" .. ARGV[1]) == 1 then
+	redis.call("srem", KEYS[3], ARGV[1])
+	redis.call("publish", KEYS[1] .. ":events", "released")
+	return 1
+end
+return stolen(KEYS[1], ARGV[1])
 `
-	refreshScriptText = `
+	refreshScriptText = holdersLua + stolenLua + `
 if redis.call("get", KEYS[1]) == ARGV[1] then
   redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2])
 	redis.call("set", KEYS[2], ARGV[3])
 	return 1
 end
+if redis.call("pexpire", KEYS[3] .. ":" .. ARGV[1], ARGV[2]) == 1 then
+	if redis.call("pttl", KEYS[3]) < tonumber(ARGV[2]) then
+		redis.call("pexpire", KEYS[3], ARGV[2])
+	end
+	return 1
+end
+return stolen(KEYS[1], ARGV[1])
+`
+	forceReleaseScriptText = holdersLua + stolenLua + `
+evict(KEYS[1], KEYS[2], ARGV[1])
+return 1
+`
+	// stealScriptText takes the keys and arguments of acquireScriptText, with
+	// the record of the eviction as data
+	stealScriptText = holdersLua + queueLua + stolenLua + `
+evict(KEYS[1], KEYS[4], ARGV[3])
+dequeue(KEYS[1], ARGV[1])
+pardon(KEYS[1], ARGV[1])
+redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2])
+redis.call("set", KEYS[2], ARGV[3])
+redis.call("publish", KEYS[1] .. ":events", "acquired")
+return redis.call("incr", KEYS[3])
+`
+	// infoScriptText returns the queue length, and the position of the client
+	// in it, after the owner, ttl, data and token. The data of a lock which is
+	// not held is the record of the eviction of its holders, if any.
+	infoScriptText = holdersLua + queueLua + `
+local waiting = queue(KEYS[1])
+local position = 0
+for i, id in ipairs(waiting) do
+	if id == ARGV[1] then
+		position = i
+	end
+end
+local owner = redis.call("get", KEYS[1])
+if owner then
+	return {owner, redis.call("pttl", KEYS[1]), redis.call("get", KEYS[2]) or "", redis.call("get", KEYS[3]) or "0", #waiting, position}
+end
+local reply = {"", 0, "", "0", #waiting, position}
+for _, h in ipairs(holders(KEYS[4])) do
+	table.insert(reply, h[1])
+	if h[2] > reply[2] then
+		reply[2] = h[2]
+	end
+end
+local evicted = holders(KEYS[1] .. ":stolen")
+if #reply == 6 and #evicted > 0 then
+	reply[3] = redis.call("get", KEYS[1] .. ":record") or ""
+end
+return reply
+`
+)
+
+// Semaphore slots are kept like shared holders of a lock
+const (
+	semAcquireScriptText = holdersLua + `
+local live = holders(KEYS[1])
+if redis.call("exists", KEYS[1] .. ":" .. ARGV[1]) == 0 and #live >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call("sadd", KEYS[1], ARGV[1])
+redis.call("set", KEYS[1] .. ":" .. ARGV[1], ARGV[1], "PX", ARGV[2])
+if redis.call("pttl", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 1
+`
+	semReleaseScriptText = `
+if redis.call("del", KEYS[1] .. ":" .. ARGV[1]) == 1 then
+	redis.call("srem", KEYS[1], ARGV[1])
+	return 1
+end
 return 0
+`
+	semRefreshScriptText = `
+if redis.call("pexpire", KEYS[1] .. ":" .. ARGV[1], ARGV[2]) == 1 then
+	if redis.call("pttl", KEYS[1]) < tonumber(ARGV[2]) then
+		redis.call("pexpire", KEYS[1], ARGV[2])
+	end
+	return 1
+end
+return 0
+`
+	semInfoScriptText = holdersLua + `
+local reply = {0}
+for _, h in ipairs(holders(KEYS[1])) do
+	table.insert(reply, h[1])
+	if reply[1] == 0 or h[2] < reply[1] then
+		reply[1] = h[2]
+	end
+end
+return reply
 `
 )
 
 var (
-	releaseScript = redis.NewScript(2, releaseScriptText)
-	refreshScript = redis.NewScript(2, refreshScriptText)
+	acquireScript       = redis.NewScript(4, acquireScriptText)
+	acquireQueuedScript = redis.NewScript(4, acquireQueuedScriptText)
+	dequeueScript       = redis.NewScript(1, dequeueScriptText)
+	acquireAllScript    = redis.NewScript(-1, acquireAllScriptText)
+	acquireSharedScript = redis.NewScript(2, acquireSharedScriptText)
+	releaseScript       = redis.NewScript(3, releaseScriptText)
+	refreshScript       = redis.NewScript(3, refreshScriptText)
+	infoScript          = redis.NewScript(4, infoScriptText)
+	forceReleaseScript  = redis.NewScript(2, forceReleaseScriptText)
+	stealScript         = redis.NewScript(4, stealScriptText)
+	semAcquireScript    = redis.NewScript(1, semAcquireScriptText)
+	semReleaseScript    = redis.NewScript(1, semReleaseScriptText)
+	semRefreshScript    = redis.NewScript(1, semRefreshScriptText)
+	semInfoScript       = redis.NewScript(1, semInfoScriptText)
 )
 
 // DialFunc is a function prototype that matches redigo/redis.Dial signature.
@@ -52,10 +330,13 @@ type RedisOptions struct {
 	DialFunc DialFunc
 }
 
-// RedisClient implements the Client interface to manage locks in redis
+// RedisClient implements the Client interface to manage locks in redis.
+// It can be used by multiple goroutines: the calls to redis are serialized
+// on a single connection.
 type RedisClient struct {
 	conn redis.Conn
 	opts RedisOptions
+	mtx  sync.Mutex
 }
 
 // RedisLock implements the Lock interface for locks in the redis store
@@ -64,10 +345,38 @@ type RedisLock struct {
 	ttl    time.Duration
 	client *RedisClient
 	data   string
+	token  uint64
+}
+
+// RedisRWLock implements the RWLock interface for locks in the redis store
+type RedisRWLock struct {
+	*RedisLock
+}
+
+// RedisSemaphore implements the Semaphore interface for semaphores in the redis store
+type RedisSemaphore struct {
+	name   string
+	slots  int
+	ttl    time.Duration
+	client *RedisClient
 }
 
 // NewRedisClient return a new RedisClient given the provided RedisOptions
 func NewRedisClient(opts RedisOptions) (*RedisClient, error) {
+	c, err := newRedisClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Reconnect()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newRedisClient returns a disconnected RedisClient, filling in the defaults
+// for the options which are not set
+func newRedisClient(opts RedisOptions) (*RedisClient, error) {
 	if opts.ClientID == "" {
 		id, err := gocql.RandomUUID()
 		if err != nil {
@@ -86,12 +395,7 @@ func NewRedisClient(opts RedisOptions) (*RedisClient, error) {
 	if opts.DialFunc == nil {
 		opts.DialFunc = redis.Dial
 	}
-	c := RedisClient{nil, opts}
-	err := c.Reconnect()
-	if err != nil {
-		return nil, err
-	}
-	return &c, nil
+	return &RedisClient{opts: opts}, nil
 }
 
 // Clone returns a disconnected copy of the currenct client
@@ -104,6 +408,12 @@ func (c *RedisClient) Clone() Client {
 
 // Close closes the connecton to redis
 func (c *RedisClient) Close() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.close()
+}
+
+func (c *RedisClient) close() {
 	if c.conn != nil {
 		c.conn.Close()
 	}
@@ -111,17 +421,168 @@ func (c *RedisClient) Close() {
 
 // Reconnect reconnects to redis, or connects if not connected
 func (c *RedisClient) Reconnect() error {
-	c.Close()
-	conn, err := c.opts.DialFunc(c.opts.Network, c.opts.Address, c.opts.DialOptions...)
+	return c.ReconnectContext(context.Background())
+}
+
+// ReconnectContext reconnects to redis, or connects if not connected.
+// It returns as soon as ctx is done, even if the dial is still in progress.
+func (c *RedisClient) ReconnectContext(ctx context.Context) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.reconnect(ctx)
+}
+
+func (c *RedisClient) reconnect(ctx context.Context) error {
+	c.close()
+	c.conn = nil
+	conn, err := c.dial(ctx)
+	if conn != nil {
+		c.conn = conn
+	}
+	return err
+}
+
+// dial opens a new connection to redis. It returns as soon as ctx is done,
+// even if the dial is still in progress.
+func (c *RedisClient) dial(ctx context.Context) (redis.Conn, error) {
+	type dialResult struct {
+		conn redis.Conn
+		err  error
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := make(chan dialResult, 1)
+	go func() {
+		conn, err := c.opts.DialFunc(c.opts.Network, c.opts.Address, c.opts.DialOptions...)
+		if err == nil {
+			_, err = conn.Do("PING")
+		}
+		res <- dialResult{conn, err}
+	}()
+
+	select {
+	case r := <-res:
+		return r.conn, r.err
+
+	case <-ctx.Done():
+		// do not leak the connection if the dial completes later on
+		go func() {
+			if r := <-res; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Notify subscribes to the events of the named lock, published by the
+// scripts which acquire and release it, on a dedicated connection.
+// Expirations are not published.
+func (c *RedisClient) Notify(ctx context.Context, name string) (<-chan struct{}, error) {
+	conn, err := c.dial(ctx)
 	if err != nil {
-		return err
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, err
+	}
+	// the connection is closed when ctx is done, which stops the receiver
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	lock := RedisLock{name: name, client: c}
+	psc := redis.PubSubConn{Conn: conn}
+	if err = psc.Subscribe(lock.key() + ":events"); err == nil {
+		// wait for the subscription, not to miss events published meanwhile
+		switch reply := psc.Receive().(type) {
+		case redis.Subscription:
+		case error:
+			err = reply
+		default:
+			err = errors.New("redis: unexpected reply to subscribe")
+		}
 	}
-	c.conn = conn
-	_, err = c.conn.Do("PING")
 	if err != nil {
+		close(done)
+		return nil, contextError(ctx, err)
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		defer close(done)
+		for {
+			switch psc.Receive().(type) {
+			case redis.Message:
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			case error:
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// do runs f against the connection, closing it if ctx is done before f
+// returns so that any blocked read or write is aborted.
+// An aborted connection is dropped, and a new one is dialed on the next call.
+func (c *RedisClient) do(ctx context.Context, f func(conn redis.Conn) error) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	return nil
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.conn == nil {
+		if err := c.reconnect(ctx); err != nil {
+			return err
+		}
+	}
+	conn := c.conn
+	if ctx.Done() == nil {
+		err := f(conn)
+		c.broken(conn)
+		return err
+	}
+
+	done := make(chan struct{})
+	aborted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			aborted <- true
+		case <-done:
+			aborted <- false
+		}
+	}()
+	err := f(conn)
+	close(done)
+	if <-aborted {
+		c.conn = nil
+		return contextError(ctx, err)
+	}
+	c.broken(conn)
+	return err
+}
+
+// broken drops conn if it failed, e.g. because redis closed it, so that the
+// next call reconnects. c.mtx must be held.
+func (c *RedisClient) broken(conn redis.Conn) {
+	if conn.Err() != nil {
+		conn.Close()
+		c.conn = nil
+	}
 }
 
 // SetID sets the ID for the current client
@@ -142,6 +603,175 @@ func (l *RedisLock) dataKey() string {
 	return l.key() + ":data"
 }
 
+// tokenKey holds the fencing token counter. It never expires, so that
+// tokens keep increasing across acquisitions.
+func (l *RedisLock) tokenKey() string {
+	return l.key() + ":token"
+}
+
+// sharedKey is the set of the shared holders of the lock
+func (l *RedisLock) sharedKey() string {
+	return l.key() + ":shared"
+}
+
+// List returns the information of all the live locks whose name starts with
+// prefix, sorted by name.
+func (c *RedisClient) List(prefix string) ([]LockInfo, error) {
+	return c.ListContext(context.Background(), prefix)
+}
+
+// ListContext is like List, but aborts the calls to redis when ctx is done.
+// The keys of the namespace starting with prefix are scanned for the keys of
+// exclusive locks and the sets of shared holders, then the information of
+// every lock found is read in a single pipeline: locks which are not held
+// anymore are left out. SCAN walks every key of the database, so the cost
+// of a listing grows with the size of the database, not with the number of
+// locks. Names ending like the keys the driver keeps next to a lock, e.g.
+// ":data" or ":token", are not listed.
+func (c *RedisClient) ListContext(ctx context.Context, prefix string) ([]LockInfo, error) {
+	pattern := redisGlobEscaper.Replace(c.opts.Namespace+prefix) + "*"
+	seen := make(map[string]bool)
+	var locks []*RedisLock
+	var replies []interface{}
+	err := c.do(ctx, func(conn redis.Conn) error {
+		cursor := 0
+		for {
+			reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern))
+			if err != nil {
+				return err
+			}
+			var keys []string
+			if _, err = redis.Scan(reply, &cursor, &keys); err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if name, ok := redisLockName(strings.TrimPrefix(key, c.opts.Namespace)); ok {
+					seen[name] = true
+				}
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+
+		names := make([]string, 0, len(seen))
+		for name := range seen {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if err := infoScript.Load(conn); err != nil {
+			return err
+		}
+		for _, name := range names {
+			l := &RedisLock{name: name, client: c}
+			locks = append(locks, l)
+			if err := infoScript.SendHash(conn, l.key(), l.dataKey(), l.tokenKey(), l.sharedKey(), c.ID()); err != nil {
+				return err
+			}
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		// every reply is read, even after an error, to keep the connection in sync
+		var err error
+		replies = make([]interface{}, len(locks))
+		for i := range locks {
+			var rerr error
+			if replies[i], rerr = conn.Receive(); rerr != nil && err == nil {
+				err = rerr
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []LockInfo
+	for i, l := range locks {
+		info, err := l.info(redis.Values(replies[i], nil))
+		if err != nil {
+			return nil, err
+		}
+		if info.Acquired {
+			infos = append(infos, *info)
+		}
+	}
+	return infos, nil
+}
+
+// redisLockSuffixes end the keys kept next to the key of a lock, and
+// redisHolderInfixes the keys of the members of its sets
+var (
+	redisLockSuffixes  = []string{":data", ":token", ":record", ":queue", ":stolen", ":semaphore"}
+	redisHolderInfixes = []string{":shared:", ":queue:", ":stolen:", ":semaphore:"}
+)
+
+// redisLockName returns the name of the lock a key of the namespace, without
+// the namespace, belongs to: the name of an exclusive lock is its key, and the
+// set of the shared holders of a lock is named after it. Other keys are not
+// told apart from locks by their names, and false is returned.
+func redisLockName(key string) (string, bool) {
+	if strings.HasSuffix(key, ":shared") {
+		return strings.TrimSuffix(key, ":shared"), true
+	}
+	for _, suffix := range redisLockSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return "", false
+		}
+	}
+	for _, infix := range redisHolderInfixes {
+		if strings.Contains(key, infix) {
+			return "", false
+		}
+	}
+	return key, true
+}
+
+// ForceRelease releases the lock with the given name whatever its holders,
+// recording who released it and why.
+func (c *RedisClient) ForceRelease(name, reason string) error {
+	return c.ForceReleaseContext(context.Background(), name, reason)
+}
+
+// ForceReleaseContext is like ForceRelease, but aborts the call to redis when
+// ctx is done.
+func (c *RedisClient) ForceReleaseContext(ctx context.Context, name, reason string) error {
+	l := &RedisLock{name: name, client: c}
+	return c.do(ctx, func(conn redis.Conn) error {
+		_, err := forceReleaseScript.Do(conn, l.key(), l.sharedKey(), adminRecord("released", c.ID(), reason))
+		return err
+	})
+}
+
+// Steal evicts the holders of the lock with the given name, and acquires it
+// for the given ttl, recording who stole it and why as its data.
+func (c *RedisClient) Steal(name string, ttl time.Duration, reason string) (Lock, error) {
+	return c.StealContext(context.Background(), name, ttl, reason)
+}
+
+// StealContext is like Steal, but aborts the call to redis when ctx is done.
+func (c *RedisClient) StealContext(ctx context.Context, name string, ttl time.Duration, reason string) (Lock, error) {
+	if ttl < time.Millisecond {
+		return nil, ErrInvalidTTL
+	}
+	l := &RedisLock{name: name, ttl: ttl, client: c, data: adminRecord("stolen", c.ID(), reason)}
+	ms := int(ttl.Nanoseconds() / int64(time.Millisecond))
+	err := c.do(ctx, func(conn redis.Conn) error {
+		var err error
+		l.token, err = redis.Uint64(stealScript.Do(conn, l.key(), l.dataKey(), l.tokenKey(), l.sharedKey(),
+			c.ID(), ms, l.data))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// redisGlobEscaper escapes the special characters of the patterns of SCAN
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
 // NewLock creates a new Lock. Lock is not automatically acquired.
 func (c *RedisClient) NewLock(name string) Lock {
 	return &RedisLock{
@@ -151,36 +781,188 @@ func (c *RedisClient) NewLock(name string) Lock {
 	}
 }
 
+// NewRWLock creates a new RWLock. Lock is not automatically acquired.
+func (c *RedisClient) NewRWLock(name string) RWLock {
+	return &RedisRWLock{&RedisLock{
+		name:   name,
+		ttl:    time.Duration(0),
+		client: c,
+	}}
+}
+
+// NewSemaphore creates a new Semaphore with the given number of slots.
+// No slot is automatically acquired.
+func (c *RedisClient) NewSemaphore(name string, slots int) Semaphore {
+	return &RedisSemaphore{
+		name:   name,
+		slots:  slots,
+		ttl:    time.Duration(0),
+		client: c,
+	}
+}
+
 // Acquire acquires the lock for the specified time lentgh (ttl).
 // It returns immadiately if the lock cannot be acquired
 func (l *RedisLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext is like Acquire, but aborts the call to redis when ctx is done.
+// If clients wait in the queue of the lock, it is theirs to take first:
+// ErrLockHeldByOtherClient is returned even if the lock is free, and Info
+// reports the waiters in Queued.
+func (l *RedisLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
 	if ttl < time.Millisecond {
 		return ErrInvalidTTL
 	}
 	l.ttl = ttl
 	ms := int(ttl.Nanoseconds() / int64(time.Millisecond))
-	_, err := redis.String(l.client.conn.Do("SET", l.key(), l.client.ID(), "PX", ms, "NX"))
-	switch {
-	case err == redis.ErrNil:
-		return ErrLockHeldByOtherClient
-	case err != nil:
-		return err
-	}
-	l.client.conn.Do("SET", l.dataKey(), l.data)
+	return l.client.do(ctx, func(conn redis.Conn) error {
+		token, err := redis.Uint64(acquireScript.Do(conn, l.key(), l.dataKey(), l.tokenKey(), l.sharedKey(),
+			l.client.ID(), ms, l.data))
+		switch {
+		case err != nil:
+			return err
+		case token == 0:
+			return ErrLockHeldByOtherClient
+		}
+		l.token = token
+		return nil
+	})
+}
 
-	return nil
+// AcquireQueued is like Acquire, but if the lock is held it waits in the
+// queue for its turn: waiters get the lock in arrival order.
+func (l *RedisLock) AcquireQueued(ttl time.Duration) error {
+	return l.AcquireQueuedContext(context.Background(), ttl)
 }
 
-// Release releases the lock if owned. Returns an error if the lock is not owned by this client
-func (l *RedisLock) Release() error {
-	res, err := redis.Bool(releaseScript.Do(l.client.conn, l.key(), l.dataKey(), l.client.ID()))
+// AcquireQueuedContext is like AcquireQueued, but leaves the queue as soon as
+// ctx is done, in which case the context error is returned.
+// The waiter is woken up by the events of the lock, and at the latest every
+// ttl/2 to refresh its entry in the queue, or when the holder expires.
+func (l *RedisLock) AcquireQueuedContext(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	ms := int(ttl.Nanoseconds() / int64(time.Millisecond))
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	notify, err := l.client.Notify(wctx, l.name)
 	if err != nil {
 		return err
 	}
-	if res == false {
+	leave := func(err error) error {
+		// the entry is removed even if ctx is done, not to hold up the queue
+		l.client.do(context.Background(), func(conn redis.Conn) error {
+			_, err := dequeueScript.Do(conn, l.key(), l.client.ID())
+			return err
+		})
+		return err
+	}
+
+	for {
+		var token uint64
+		err := l.client.do(ctx, func(conn redis.Conn) error {
+			var err error
+			token, err = redis.Uint64(acquireQueuedScript.Do(conn, l.key(), l.dataKey(), l.tokenKey(),
+				l.sharedKey(), l.client.ID(), ms, l.data))
+			return err
+		})
+		if err != nil {
+			return leave(err)
+		}
+		if token > 0 {
+			l.token = token
+			return nil
+		}
+		info, err := l.InfoContext(ctx)
+		if err != nil {
+			return leave(err)
+		}
+		wait := ttl / 2
+		if info.Acquired && info.TTL+time.Millisecond < wait {
+			wait = info.TTL + time.Millisecond
+		}
+		select {
+		case _, ok := <-notify:
+			if !ok {
+				// notifications stopped, wake up on time only
+				notify = nil
+			}
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return leave(ctx.Err())
+		}
+	}
+}
+
+// AcquireAllContext acquires all the given locks in a single script, so that
+// either all of them are acquired or none is.
+func (c *RedisClient) AcquireAllContext(ctx context.Context, locks []Lock, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	ms := int(ttl.Nanoseconds() / int64(time.Millisecond))
+	rlocks := make([]*RedisLock, len(locks))
+	keys := make([]interface{}, 0, 1+4*len(locks))
+	args := []interface{}{c.ID(), ms}
+	keys = append(keys, 4*len(locks))
+	for i, lock := range locks {
+		l, ok := lock.(*RedisLock)
+		if !ok {
+			return ErrNotSupported
+		}
+		rlocks[i] = l
+		keys = append(keys, l.key(), l.dataKey(), l.tokenKey(), l.sharedKey())
+		args = append(args, l.data)
+	}
+	return c.do(ctx, func(conn redis.Conn) error {
+		tokens, err := redis.Values(acquireAllScript.Do(conn, append(keys, args...)...))
+		switch {
+		case err != nil:
+			return err
+		case len(tokens) == 0:
+			return ErrLockHeldByOtherClient
+		}
+		for i, l := range rlocks {
+			l.ttl = ttl
+			if l.token, err = redis.Uint64(tokens[i], nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ownership maps the result of the scripts which release or refresh a lock
+// to an error
+func ownership(res int) error {
+	switch res {
+	case 1:
+		return nil
+	case -1:
+		return ErrLockStolen
+	default:
 		return ErrLockNotOwned
 	}
-	return nil
+}
+
+// Release releases the lock if owned. Returns an error if the lock is not owned by this client
+func (l *RedisLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext is like Release, but aborts the call to redis when ctx is done.
+func (l *RedisLock) ReleaseContext(ctx context.Context) error {
+	return l.client.do(ctx, func(conn redis.Conn) error {
+		res, err := redis.Int(releaseScript.Do(conn, l.key(), l.dataKey(), l.sharedKey(), l.client.ID()))
+		if err != nil {
+			return err
+		}
+		return ownership(res)
+	})
 }
 
 // RefreshTTL Extends the lock, if owned, for the specified TTL.
@@ -188,59 +970,90 @@ func (l *RedisLock) Release() error {
 // will use this ttl
 // It returns an error if the lock is not owned by the current client
 func (l *RedisLock) RefreshTTL(ttl time.Duration) error {
+	return l.RefreshTTLContext(context.Background(), ttl)
+}
+
+// RefreshTTLContext is like RefreshTTL, but aborts the call to redis when ctx is done.
+func (l *RedisLock) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
 	l.ttl = ttl
-	return l.Refresh()
+	return l.RefreshContext(ctx)
 }
 
 // Refresh extends the lock by extending the TTL in the store.
 // It returns an error if the lock is not owned by the current client
 func (l *RedisLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, but aborts the call to redis when ctx is done.
+func (l *RedisLock) RefreshContext(ctx context.Context) error {
 	if l.ttl < time.Millisecond {
 		return ErrInvalidTTL
 	}
 	ms := int(l.ttl.Nanoseconds() / int64(time.Millisecond))
-	res, err := redis.Bool(refreshScript.Do(l.client.conn, l.key(), l.dataKey(), l.client.ID(), ms, l.data))
-	if err != nil {
-		return err
-	}
-	if res == false {
-		return ErrLockNotOwned
-	}
-	return nil
+	return l.client.do(ctx, func(conn redis.Conn) error {
+		res, err := redis.Int(refreshScript.Do(conn, l.key(), l.dataKey(), l.sharedKey(), l.client.ID(), ms, l.data))
+		if err != nil {
+			return err
+		}
+		return ownership(res)
+	})
 }
 
 // Info returns information about the lock.
 func (l *RedisLock) Info() (*LockInfo, error) {
-	var owner, data string
-	var expire int
+	return l.InfoContext(context.Background())
+}
 
-	l.client.conn.Send("MULTI")
-	l.client.conn.Send("GET", l.key())
-	l.client.conn.Send("PTTL", l.key())
-	l.client.conn.Send("GET", l.dataKey())
-	reply, err := redis.Values(l.client.conn.Do("EXEC"))
+// InfoContext is like Info, but aborts the call to redis when ctx is done.
+func (l *RedisLock) InfoContext(ctx context.Context) (*LockInfo, error) {
+	var reply []interface{}
+	err := l.client.do(ctx, func(conn redis.Conn) error {
+		var err error
+		reply, err = redis.Values(infoScript.Do(conn, l.key(), l.dataKey(), l.tokenKey(), l.sharedKey(),
+			l.client.ID()))
+		return err
+	})
+	return l.info(reply, err)
+}
 
-	if err == redis.ErrNil {
-		return &LockInfo{l.name, false, "", time.Duration(0), ""}, nil
-	}
+// info builds the information of the lock from the reply of infoScript
+func (l *RedisLock) info(reply []interface{}, err error) (*LockInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	_, err = redis.Scan(reply, &owner, &expire, &data)
+	var owner, data string
+	var expire, queued, position int
+	var token uint64
+	holders, err := redis.Scan(reply, &owner, &expire, &data, &token, &queued, &position)
 	if err != nil {
 		return nil, err
 	}
 
 	ttl := time.Duration(expire) * time.Millisecond
+	if ttl <= 0 {
+		token = 0
+	}
 
-	return &LockInfo{
+	info := &LockInfo{
 		Name:     l.name,
 		Acquired: ttl > 0,
 		Owner:    owner,
 		TTL:      ttl,
 		Data:     data,
-	}, nil
+		Token:    token,
+		Shared:   len(holders) > 0,
+		Queued:   queued,
+		Position: position,
+	}
+	if len(holders) > 0 {
+		info.Holders, err = redis.Strings(holders, nil)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(info.Holders)
+	}
+	return info, nil
 }
 
 // SetData sets the data payload for the lock.
@@ -249,3 +1062,185 @@ func (l *RedisLock) Info() (*LockInfo, error) {
 func (l *RedisLock) SetData(data string) {
 	l.data = data
 }
+
+// Token returns the fencing token obtained by the last successful Acquire
+func (l *RedisLock) Token() uint64 {
+	return l.token
+}
+
+// AcquireExclusive is the same as Acquire
+func (l *RedisRWLock) AcquireExclusive(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireExclusiveContext is the same as AcquireContext
+func (l *RedisRWLock) AcquireExclusiveContext(ctx context.Context, ttl time.Duration) error {
+	return l.AcquireContext(ctx, ttl)
+}
+
+// AcquireShared acquires the lock in shared mode for the specified time length (ttl).
+// It returns immediately if the lock cannot be acquired
+func (l *RedisRWLock) AcquireShared(ttl time.Duration) error {
+	return l.AcquireSharedContext(context.Background(), ttl)
+}
+
+// AcquireSharedContext is like AcquireShared, but aborts the call to redis when ctx is done.
+func (l *RedisRWLock) AcquireSharedContext(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	ms := int(ttl.Nanoseconds() / int64(time.Millisecond))
+	return l.client.do(ctx, func(conn redis.Conn) error {
+		res, err := redis.Bool(acquireSharedScript.Do(conn, l.key(), l.sharedKey(), l.client.ID(), ms))
+		if err != nil {
+			return err
+		}
+		if res == false {
+			return ErrLockHeldByOtherClient
+		}
+		l.token = 0
+		return nil
+	})
+}
+
+func (s *RedisSemaphore) key() string {
+	return s.client.opts.Namespace + s.name + ":semaphore"
+}
+
+// Slots returns the number of slots of the semaphore
+func (s *RedisSemaphore) Slots() int {
+	return s.slots
+}
+
+// Acquire acquires a slot for the specified time length (ttl).
+// It returns immediately if all the slots are taken
+func (s *RedisSemaphore) Acquire(ttl time.Duration) error {
+	return s.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext is like Acquire, but aborts the call to redis when ctx is done.
+func (s *RedisSemaphore) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if s.slots <= 0 {
+		return ErrInvalidSlots
+	}
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	s.ttl = ttl
+	ms := int(ttl.Nanoseconds() / int64(time.Millisecond))
+	return s.client.do(ctx, func(conn redis.Conn) error {
+		res, err := redis.Bool(semAcquireScript.Do(conn, s.key(), s.client.ID(), ms, s.slots))
+		if err != nil {
+			return err
+		}
+		if res == false {
+			return ErrLockHeldByOtherClient
+		}
+		return nil
+	})
+}
+
+// Release releases the slot if owned. Returns an error if no slot is owned by this client
+func (s *RedisSemaphore) Release() error {
+	return s.ReleaseContext(context.Background())
+}
+
+// ReleaseContext is like Release, but aborts the call to redis when ctx is done.
+func (s *RedisSemaphore) ReleaseContext(ctx context.Context) error {
+	return s.client.do(ctx, func(conn redis.Conn) error {
+		res, err := redis.Bool(semReleaseScript.Do(conn, s.key(), s.client.ID()))
+		if err != nil {
+			return err
+		}
+		if res == false {
+			return ErrLockNotOwned
+		}
+		return nil
+	})
+}
+
+// RefreshTTL extends the slot, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the slot: successive calls to Refresh()
+// will use this ttl
+func (s *RedisSemaphore) RefreshTTL(ttl time.Duration) error {
+	return s.RefreshTTLContext(context.Background(), ttl)
+}
+
+// RefreshTTLContext is like RefreshTTL, but aborts the call to redis when ctx is done.
+func (s *RedisSemaphore) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
+	s.ttl = ttl
+	return s.RefreshContext(ctx)
+}
+
+// Refresh extends the slot by extending its TTL in the store.
+// It returns an error if no slot is owned by the current client
+func (s *RedisSemaphore) Refresh() error {
+	return s.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, but aborts the call to redis when ctx is done.
+func (s *RedisSemaphore) RefreshContext(ctx context.Context) error {
+	if s.ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	ms := int(s.ttl.Nanoseconds() / int64(time.Millisecond))
+	return s.client.do(ctx, func(conn redis.Conn) error {
+		res, err := redis.Bool(semRefreshScript.Do(conn, s.key(), s.client.ID(), ms))
+		if err != nil {
+			return err
+		}
+		if res == false {
+			return ErrLockNotOwned
+		}
+		return nil
+	})
+}
+
+// Info returns information about the semaphore.
+func (s *RedisSemaphore) Info() (*LockInfo, error) {
+	return s.InfoContext(context.Background())
+}
+
+// InfoContext is like Info, but aborts the call to redis when ctx is done.
+func (s *RedisSemaphore) InfoContext(ctx context.Context) (*LockInfo, error) {
+	var expire int
+	var reply []interface{}
+
+	err := s.client.do(ctx, func(conn redis.Conn) error {
+		var err error
+		reply, err = redis.Values(semInfoScript.Do(conn, s.key()))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	holders, err := redis.Scan(reply, &expire)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &LockInfo{
+		Name:     s.name,
+		Acquired: len(holders) > 0,
+		TTL:      time.Duration(expire) * time.Millisecond,
+		Shared:   len(holders) > 0,
+	}
+	if len(holders) > 0 {
+		info.Holders, err = redis.Strings(holders, nil)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(info.Holders)
+	}
+	return info, nil
+}
+
+// SetData is a no-op, as slots carry no data.
+func (s *RedisSemaphore) SetData(data string) {
+}
+
+// Token always returns 0, as slots get no fencing token.
+func (s *RedisSemaphore) Token() uint64 {
+	return 0
+}
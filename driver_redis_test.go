@@ -3,11 +3,14 @@
 package glock
 
 import (
+	"context"
 	"flag"
+	"net"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/garyburd/redigo/redis"
 	"github.com/stvp/tempredis"
 )
 
@@ -25,23 +28,150 @@ func TestMain(m *testing.M) {
 	os.Exit(result)
 }
 
-func redisClient(t *testing.T) Client {
-	opts := RedisOptions{
+// RedisTestOptions returns the options of the clients of the redis tests,
+// for the tests of package glock_test
+func RedisTestOptions() RedisOptions {
+	return RedisOptions{
 		Network:   "unix",
 		Address:   server.Socket(),
 		Namespace: *namespace,
 	}
-	c1, err := NewRedisClient(opts)
+}
+
+func redisClient(t *testing.T) Client {
+	c1, err := NewRedisClient(RedisTestOptions())
 	if err != nil {
 		t.Fatalf("Cannot create redis client: %s", err)
 	}
 	return c1
 }
 
+func init() {
+	Drivers["redis"] = Driver{redisClient, time.Millisecond}
+}
+
 func TestRedisClient(t *testing.T) {
 	testClient(t, redisClient)
 }
 
+func TestRedisClientList(t *testing.T) {
+	testClientList(t, redisClient, time.Millisecond)
+}
+
+func TestRedisLockName(t *testing.T) {
+	for key, name := range map[string]string{
+		"lock":                "lock",
+		"app:lock":            "app:lock",
+		"lock:shared":         "lock",
+		"lock:data":           "",
+		"lock:token":          "",
+		"lock:record":         "",
+		"lock:queue":          "",
+		"lock:queue:client":   "",
+		"lock:shared:client":  "",
+		"lock:stolen":         "",
+		"lock:stolen:client":  "",
+		"sem:semaphore":       "",
+		"sem:semaphore:slot1": "",
+	} {
+		got, ok := redisLockName(key)
+		if got != name || ok != (name != "") {
+			t.Errorf("redisLockName(%q) = %q, %v -- expected %q", key, got, ok, name)
+		}
+	}
+}
+
+func TestRedisClientAdmin(t *testing.T) {
+	testClientAdmin(t, redisClient, time.Millisecond)
+}
+
+func TestRedisManagerStolen(t *testing.T) {
+	testManagerStolen(t, redisClient, time.Millisecond)
+}
+
 func TestRedisLock(t *testing.T) {
 	testLock(t, redisClient, time.Millisecond)
 }
+
+func TestRedisLockContext(t *testing.T) {
+	testLockContext(t, redisClient, time.Millisecond)
+}
+
+func TestRedisHungServer(t *testing.T) {
+	// A server that accepts connections and never replies
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c := &RedisClient{opts: RedisOptions{
+		Network:   "tcp",
+		Address:   ln.Addr().String(),
+		ClientID:  "hung",
+		Namespace: *namespace,
+		DialFunc:  redis.Dial,
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = c.ReconnectContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Reconnect against a hung server should return '%s', got '%s'", context.DeadlineExceeded, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = c.NewLock(lockName).AcquireContext(ctx, time.Second)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Acquire against a hung server should return '%s', got '%s'", context.DeadlineExceeded, err)
+	}
+}
+
+func TestRedisLockToken(t *testing.T) {
+	testLockToken(t, redisClient, time.Millisecond)
+}
+
+func TestRedisLockFairQueue(t *testing.T) {
+	testLockFairQueue(t, redisClient, time.Millisecond)
+}
+
+func TestRedisManagerFair(t *testing.T) {
+	testManagerFair(t, redisClient, time.Millisecond)
+}
+
+func TestRedisRWLock(t *testing.T) {
+	testRWLock(t, redisClient, time.Millisecond)
+}
+
+func TestRedisManagerShared(t *testing.T) {
+	testManagerShared(t, redisClient, time.Millisecond)
+}
+
+func TestRedisSemaphore(t *testing.T) {
+	testSemaphore(t, redisClient, time.Millisecond)
+}
+
+func TestRedisManagerSemaphore(t *testing.T) {
+	testManagerSemaphore(t, redisClient, time.Millisecond)
+}
+
+func TestRedisManagerAcquireAll(t *testing.T) {
+	testManagerAcquireAll(t, redisClient, time.Millisecond)
+}
+
+func TestRedisManagerWatch(t *testing.T) {
+	testManagerWatch(t, redisClient, time.Millisecond)
+}
+
+func TestRedisManagerReentrant(t *testing.T) {
+	testManagerReentrant(t, redisClient, time.Millisecond)
+}
@@ -0,0 +1,392 @@
+package glock
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/gocql/gocql"
+)
+
+// ErrNoNodes is returned when a RedlockClient is created without nodes
+var ErrNoNodes = errors.New("No redis nodes configured")
+
+// bumpTokenScript raises the token counter of a node to the token of the
+// lock, so that every majority of the nodes hands out a higher token to the
+// next owner.
+var bumpTokenScript = redis.NewScript(1, `
+if tonumber(redis.call("get", KEYS[1]) or "0") < tonumber(ARGV[1]) then
+	redis.call("set", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// RedlockOptions represent options to connect to a set of independent redis masters
+type RedlockOptions struct {
+	// Nodes are the options to connect to each redis master.
+	// Their ClientID and Namespace are replaced by the ones below.
+	Nodes []RedisOptions
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+	// Namespace is an optional namespace for all redis keys that will be created.
+	// It must contain the separator (if any). If not set, the deafault value
+	// is used "glock:"
+	Namespace string
+	// DriftFactor is the clock drift between the nodes, as a fraction of the
+	// lock TTL. If not set, it defaults to 0.01
+	DriftFactor float64
+	// ClockDrift is added to the drift computed with DriftFactor.
+	// If not set, it defaults to 2ms
+	ClockDrift time.Duration
+}
+
+// RedlockClient implements the Client interface to manage locks on a set of
+// independent redis masters, using the redlock algorithm
+// (http://redis.io/topics/distlock).
+// Locks are acquired on each node as with a RedisClient, and are held as long
+// as they are held on a majority of the nodes.
+type RedlockClient struct {
+	nodes []*RedisClient
+	opts  RedlockOptions
+}
+
+// RedlockLock implements the Lock interface for locks on a set of redis masters.
+//
+// The lock is acquired only if it is acquired on a majority of the nodes
+// before its TTL, minus the clock drift, expires. The fencing token is the
+// highest token handed out by those nodes.
+type RedlockLock struct {
+	name   string
+	ttl    time.Duration
+	client *RedlockClient
+	data   string
+	token  uint64
+	locks  []*RedisLock
+}
+
+// NewRedlockClient returns a new RedlockClient given the provided RedlockOptions.
+// It fails if it cannot connect to a majority of the nodes.
+func NewRedlockClient(opts RedlockOptions) (*RedlockClient, error) {
+	if len(opts.Nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "glock:"
+	}
+	if opts.DriftFactor <= 0 {
+		opts.DriftFactor = 0.01
+	}
+	if opts.ClockDrift <= 0 {
+		opts.ClockDrift = 2 * time.Millisecond
+	}
+
+	c := RedlockClient{opts: opts}
+	for _, nodeOpts := range opts.Nodes {
+		nodeOpts.ClientID = opts.ClientID
+		nodeOpts.Namespace = opts.Namespace
+		node, err := newRedisClient(nodeOpts)
+		if err != nil {
+			return nil, err
+		}
+		c.nodes = append(c.nodes, node)
+	}
+	err := c.Reconnect()
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// quorum is the number of nodes needed to hold a lock
+func (c *RedlockClient) quorum() int {
+	return len(c.nodes)/2 + 1
+}
+
+// drift is how much the clocks of the nodes can drift during ttl
+func (c *RedlockClient) drift(ttl time.Duration) time.Duration {
+	return time.Duration(float64(ttl)*c.opts.DriftFactor) + c.opts.ClockDrift
+}
+
+// each runs f for all the nodes concurrently, and returns its results
+func (c *RedlockClient) each(f func(i int) error) []error {
+	errs := make([]error, len(c.nodes))
+	var wg sync.WaitGroup
+	for i := range c.nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = f(i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// outcome returns nil if f succeeded on a majority of the nodes. Otherwise,
+// it returns lockErr if a majority of the nodes replied, or the error of one
+// of the nodes which did not.
+func (c *RedlockClient) outcome(ctx context.Context, errs []error, lockErr error) error {
+	var succeeded, replied int
+	var failure error
+	for _, err := range errs {
+		switch err {
+		case nil:
+			succeeded++
+			replied++
+		case lockErr:
+			replied++
+		default:
+			failure = err
+		}
+	}
+	switch {
+	case succeeded >= c.quorum():
+		return nil
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case replied < c.quorum() && failure != nil:
+		return failure
+	}
+	return lockErr
+}
+
+// Clone returns a disconnected copy of the currenct client
+func (c *RedlockClient) Clone() Client {
+	clone := &RedlockClient{opts: c.opts}
+	for _, node := range c.nodes {
+		clone.nodes = append(clone.nodes, node.Clone().(*RedisClient))
+	}
+	return clone
+}
+
+// Close closes the connections to all the nodes
+func (c *RedlockClient) Close() {
+	for _, node := range c.nodes {
+		node.Close()
+	}
+}
+
+// Reconnect reconnects to all the nodes, or connects if not connected
+func (c *RedlockClient) Reconnect() error {
+	return c.ReconnectContext(context.Background())
+}
+
+// ReconnectContext reconnects to all the nodes, or connects if not connected.
+// It fails only if it cannot connect to a majority of the nodes: the others
+// will be dialed again on their next use.
+func (c *RedlockClient) ReconnectContext(ctx context.Context) error {
+	errs := c.each(func(i int) error {
+		return c.nodes[i].ReconnectContext(ctx)
+	})
+	return c.outcome(ctx, errs, nil)
+}
+
+// SetID sets the ID for the current client
+func (c *RedlockClient) SetID(id string) {
+	c.opts.ClientID = id
+	for _, node := range c.nodes {
+		node.SetID(id)
+	}
+}
+
+// ID returns the current client ID
+func (c *RedlockClient) ID() string {
+	return c.opts.ClientID
+}
+
+// NewLock creates a new Lock. Lock is not automatically acquired.
+func (c *RedlockClient) NewLock(name string) Lock {
+	l := &RedlockLock{
+		name:   name,
+		ttl:    time.Duration(0),
+		client: c,
+	}
+	for _, node := range c.nodes {
+		l.locks = append(l.locks, node.NewLock(name).(*RedisLock))
+	}
+	return l
+}
+
+// Acquire acquires the lock for the specified time lentgh (ttl).
+// It returns immadiately if the lock cannot be acquired
+func (l *RedlockLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext is like Acquire, but aborts the calls to redis when ctx is done.
+// A ttl which is not longer than the clock drift is invalid.
+func (l *RedlockLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if ttl <= l.client.drift(ttl) {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	start := time.Now()
+	errs := l.client.each(func(i int) error {
+		return l.locks[i].AcquireContext(ctx, ttl)
+	})
+	var token uint64
+	for i, err := range errs {
+		if err == nil && l.locks[i].Token() > token {
+			token = l.locks[i].Token()
+		}
+	}
+	l.client.each(func(i int) error {
+		if errs[i] != nil {
+			return nil
+		}
+		errs[i] = l.client.nodes[i].do(ctx, func(conn redis.Conn) error {
+			_, err := bumpTokenScript.Do(conn, l.locks[i].tokenKey(), token)
+			return err
+		})
+		return nil
+	})
+
+	err := l.client.outcome(ctx, errs, ErrLockHeldByOtherClient)
+	if err == nil && time.Since(start)+l.client.drift(ttl) >= ttl {
+		// the lock might have expired on some nodes already
+		err = ErrLockHeldByOtherClient
+	}
+	if err != nil {
+		// undo the acquisitions, including the ones which might have
+		// succeeded on nodes which did not reply, even if ctx is done, not
+		// to leave the lock behind
+		l.client.each(func(i int) error {
+			if errs[i] != ErrLockHeldByOtherClient {
+				l.locks[i].ReleaseContext(context.Background())
+			}
+			return nil
+		})
+		return err
+	}
+	l.token = token
+	return nil
+}
+
+// Release releases the lock on all the nodes.
+// Returns an error if the lock is not owned by this client on a majority of them
+func (l *RedlockLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext is like Release, but aborts the calls to redis when ctx is done.
+func (l *RedlockLock) ReleaseContext(ctx context.Context) error {
+	errs := l.client.each(func(i int) error {
+		return l.locks[i].ReleaseContext(ctx)
+	})
+	return l.client.outcome(ctx, errs, ErrLockNotOwned)
+}
+
+// RefreshTTL extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
+func (l *RedlockLock) RefreshTTL(ttl time.Duration) error {
+	return l.RefreshTTLContext(context.Background(), ttl)
+}
+
+// RefreshTTLContext is like RefreshTTL, but aborts the calls to redis when ctx is done.
+func (l *RedlockLock) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
+	l.ttl = ttl
+	return l.RefreshContext(ctx)
+}
+
+// Refresh extends the lock on all the nodes where it is held.
+// It returns an error if the lock is not owned by the current client on a
+// majority of the nodes, or if they could not be refreshed in time.
+func (l *RedlockLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, but aborts the calls to redis when ctx is done.
+func (l *RedlockLock) RefreshContext(ctx context.Context) error {
+	if l.ttl <= l.client.drift(l.ttl) {
+		return ErrInvalidTTL
+	}
+	start := time.Now()
+	errs := l.client.each(func(i int) error {
+		return l.locks[i].RefreshTTLContext(ctx, l.ttl)
+	})
+	err := l.client.outcome(ctx, errs, ErrLockNotOwned)
+	if err == nil && time.Since(start)+l.client.drift(l.ttl) >= l.ttl {
+		err = ErrLockNotOwned
+	}
+	return err
+}
+
+// Info returns information about the lock. The lock is acquired if a
+// majority of the nodes agree on its owner, and its TTL is the time left
+// before the lock expires on that majority, minus the clock drift.
+func (l *RedlockLock) Info() (*LockInfo, error) {
+	return l.InfoContext(context.Background())
+}
+
+// InfoContext is like Info, but aborts the calls to redis when ctx is done.
+func (l *RedlockLock) InfoContext(ctx context.Context) (*LockInfo, error) {
+	infos := make([]*LockInfo, len(l.locks))
+	errs := l.client.each(func(i int) error {
+		var err error
+		infos[i], err = l.locks[i].InfoContext(ctx)
+		return err
+	})
+	if err := l.client.outcome(ctx, errs, nil); err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string][]*LockInfo)
+	for _, info := range infos {
+		if info != nil && info.Acquired && info.Owner != "" {
+			owners[info.Owner] = append(owners[info.Owner], info)
+		}
+	}
+	for owner, held := range owners {
+		if len(held) < l.client.quorum() {
+			continue
+		}
+		// the lock expires when it expires on all but a minority of the nodes
+		sort.Slice(held, func(i, j int) bool { return held[i].TTL > held[j].TTL })
+		ttl := held[l.client.quorum()-1].TTL
+		ttl -= l.client.drift(ttl)
+		if ttl <= 0 {
+			break
+		}
+		info := &LockInfo{
+			Name:     l.name,
+			Acquired: true,
+			Owner:    owner,
+			TTL:      ttl,
+			Data:     held[0].Data,
+		}
+		for _, h := range held {
+			if h.Token > info.Token {
+				info.Token = h.Token
+			}
+		}
+		return info, nil
+	}
+	return &LockInfo{Name: l.name, Acquired: false}, nil
+}
+
+// SetData sets the data payload for the lock.
+// The data is set into the backend only when the lock is acquired,
+// so any call to this method after acquisition won't update the value.
+func (l *RedlockLock) SetData(data string) {
+	l.data = data
+	for _, lock := range l.locks {
+		lock.SetData(data)
+	}
+}
+
+// Token returns the fencing token obtained by the last successful Acquire
+func (l *RedlockLock) Token() uint64 {
+	return l.token
+}
@@ -0,0 +1,193 @@
+// +build redlock
+
+package glock
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stvp/tempredis"
+)
+
+var redlockServers []*tempredis.Server
+
+// redlockScale leaves time to acquire the lock on all the nodes
+const redlockScale = 20 * time.Millisecond
+
+func TestMain(m *testing.M) {
+	for i := 0; i < 3; i++ {
+		server, err := tempredis.Start(nil)
+		if err != nil {
+			panic(err)
+		}
+		redlockServers = append(redlockServers, server)
+	}
+	result := m.Run()
+	for _, server := range redlockServers {
+		server.Term()
+	}
+	os.Exit(result)
+}
+
+func redlockOptions() RedlockOptions {
+	opts := RedlockOptions{
+		Namespace: "glock:tests:redlock:",
+		// TTLs shorter than the drift are invalid, like half the scale
+		ClockDrift: redlockScale / 2,
+	}
+	for _, server := range redlockServers {
+		opts.Nodes = append(opts.Nodes, RedisOptions{Network: "unix", Address: server.Socket()})
+	}
+	return opts
+}
+
+func redlockClient(t *testing.T) Client {
+	c, err := NewRedlockClient(redlockOptions())
+	if err != nil {
+		t.Fatalf("Cannot create redlock client: %s", err)
+	}
+	return c
+}
+
+func init() {
+	Drivers["redlock"] = Driver{redlockClient, redlockScale}
+}
+
+// deadAddress returns the address of a closed tcp port
+func deadAddress(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %s", err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
+
+func TestRedlockClient(t *testing.T) {
+	testClient(t, redlockClient)
+}
+
+func TestRedlockLock(t *testing.T) {
+	testLock(t, redlockClient, redlockScale)
+}
+
+func TestRedlockLockContext(t *testing.T) {
+	testLockContext(t, redlockClient, redlockScale)
+}
+
+func TestRedlockLockToken(t *testing.T) {
+	testLockToken(t, redlockClient, redlockScale)
+}
+
+func TestRedlockManagerAcquire(t *testing.T) {
+	testManagerAcquire(t, redlockClient, redlockScale)
+}
+
+func TestRedlockManagerAcquireWait(t *testing.T) {
+	testManagerAcquireWait(t, redlockClient, redlockScale)
+}
+
+func TestRedlockManagerFailReleaseAll(t *testing.T) {
+	testManagerFailReleaseAll(t, redlockClient, redlockScale)
+}
+
+func TestRedlockManagerAcquireContext(t *testing.T) {
+	testManagerAcquireContext(t, redlockClient, redlockScale)
+}
+
+func TestRedlockManagerConcurrent(t *testing.T) {
+	testManagerConcurrent(t, redlockClient, redlockScale)
+}
+
+func TestRedlockManagerAcquireAll(t *testing.T) {
+	testManagerAcquireAll(t, redlockClient, redlockScale)
+}
+
+func TestRedlockManagerWatch(t *testing.T) {
+	testManagerWatch(t, redlockClient, redlockScale)
+}
+
+func TestRedlockManagerKeeper(t *testing.T) {
+	testManagerKeeper(t, redlockClient, redlockScale)
+}
+
+func TestRedlockManagerLease(t *testing.T) {
+	testManagerLease(t, redlockClient, redlockScale)
+}
+
+func TestRedlockManagerReentrant(t *testing.T) {
+	testManagerReentrant(t, redlockClient, redlockScale)
+}
+
+func TestRedlockElection(t *testing.T) {
+	testElection(t, redlockClient, redlockScale)
+}
+
+func TestRedlockNoNodes(t *testing.T) {
+	_, err := NewRedlockClient(RedlockOptions{})
+	if err != ErrNoNodes {
+		t.Errorf("Expected error '%s', got '%s'", ErrNoNodes, err)
+	}
+}
+
+func TestRedlockMajority(t *testing.T) {
+	ttl := time.Duration(ttlLength) * redlockScale
+
+	// A minority of the nodes down does not prevent locking
+	opts := redlockOptions()
+	opts.Nodes[2] = RedisOptions{Network: "tcp", Address: deadAddress(t)}
+	c, err := NewRedlockClient(opts)
+	if err != nil {
+		t.Fatalf("Cannot create redlock client with a node down: %s", err)
+	}
+	l := c.NewLock(lockName)
+	if err = l.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock with a node down: %s", err)
+	}
+	info, err := l.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if !info.Acquired || info.Owner != c.ID() {
+		t.Errorf("Lock should be held by '%s', got %+v", c.ID(), info)
+	}
+	if info.TTL <= 0 || info.TTL > ttl-c.drift(ttl) {
+		t.Errorf("Info should report the TTL minus the drift, got %v", info.TTL)
+	}
+	if err = l.Refresh(); err != nil {
+		t.Errorf("Cannot refresh lock with a node down: %s", err)
+	}
+
+	// The lock is held on a majority, nobody else can get it
+	if err = redlockClient(t).NewLock(lockName).Acquire(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+	if err = l.Release(); err != nil {
+		t.Fatalf("Cannot release lock with a node down: %s", err)
+	}
+
+	// A minority of the nodes holding the lock is not enough to own it
+	other := c.NewLock(lockName).(*RedlockLock)
+	if err = other.locks[0].Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock on a single node: %s", err)
+	}
+	info, err = redlockClient(t).NewLock(lockName).Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Acquired {
+		t.Errorf("Lock held on a minority of the nodes should not be acquired, got %+v", info)
+	}
+	if err = other.RefreshTTL(ttl); err != ErrLockNotOwned {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockNotOwned, err)
+	}
+	other.locks[0].Release()
+
+	// With a majority of the nodes down, the client cannot even connect
+	opts.Nodes[1] = RedisOptions{Network: "tcp", Address: deadAddress(t)}
+	if _, err = NewRedlockClient(opts); err == nil {
+		t.Errorf("Creating a client with a majority of the nodes down should fail")
+	}
+}
@@ -0,0 +1,689 @@
+package glock
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/gocql/gocql"
+)
+
+// zkPrefix is the prefix of the sequential nodes queued under a lock
+const zkPrefix = "lock-"
+
+// ZookeeperConn is the subset of *zk.Conn used by the zookeeper driver
+type ZookeeperConn interface {
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Delete(path string, version int32) error
+	Children(path string) ([]string, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Close()
+}
+
+// ZookeeperDialFunc is the function used to connect to zookeeper
+type ZookeeperDialFunc func(servers []string, sessionTimeout time.Duration) (ZookeeperConn, error)
+
+// ZookeeperOptions represents options for connecting to zookeeper
+type ZookeeperOptions struct {
+	// Servers, i.e. 'localhost:2181'
+	Servers []string
+	// SessionTimeout is the zookeeper session timeout. If not set, it defaults to 10 seconds
+	SessionTimeout time.Duration
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+	// Root is the node under which locks are created. If not set, the default value
+	// is used "/glock"
+	Root string
+	// ACL for the nodes that will be created. Defaults to zk.WorldACL(zk.PermAll)
+	ACL []zk.ACL
+	// The function used to connect to zookeeper. defaults to zk.Connect
+	DialFunc ZookeeperDialFunc
+}
+
+// ZookeeperClient implements the Client interface to manage locks in zookeeper.
+// It can be used by multiple goroutines.
+type ZookeeperClient struct {
+	conn ZookeeperConn
+	opts ZookeeperOptions
+	// mtx protects conn
+	mtx sync.Mutex
+}
+
+// ZookeeperLock implements the Lock interface for locks in zookeeper.
+//
+// Every acquisition creates an ephemeral sequential node under the lock node,
+// and the lock belongs to the node with the lowest sequence number. The
+// owner, data and TTL are stored in the node, whose lease runs from its last
+// write: expirations are measured with the modification times set by the
+// zookeeper server, rather than with the clocks of the clients. Nodes which
+// expired are deleted by the other contenders, so the TTL is honoured even if
+// the session of the holder is still alive. A node only holds the lock once
+// claimed by its client: until then, it is just waiting at the head of the
+// queue. The sequence number of the node is the fencing token.
+type ZookeeperLock struct {
+	name   string
+	ttl    time.Duration
+	client *ZookeeperClient
+	data   string
+	token  uint64
+}
+
+// zkNode is the content of a lock node
+type zkNode struct {
+	Owner string `json:"owner"`
+	Data  string `json:"data"`
+	// TTL is the lease of the node from its last write, in nanoseconds
+	TTL int64 `json:"ttl"`
+	// Claimed tells if the node holds the lock, rather than waiting for it
+	Claimed bool `json:"claimed,omitempty"`
+}
+
+// zkContender is a node queued for a lock
+type zkContender struct {
+	path    string
+	seq     uint64
+	node    zkNode
+	version int32
+	// left is what might have been left of the lease when read, by the
+	// server clock
+	left time.Duration
+}
+
+func (c *zkContender) live() bool {
+	return c.left > 0
+}
+
+// zkTime converts a zookeeper timestamp, in milliseconds since the epoch
+func zkTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+func zkConnect(servers []string, sessionTimeout time.Duration) (ZookeeperConn, error) {
+	conn, _, err := zk.Connect(servers, sessionTimeout, zk.WithLogInfo(false))
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// NewZookeeperClient returns a new ZookeeperClient given the provided ZookeeperOptions
+func NewZookeeperClient(opts ZookeeperOptions) (*ZookeeperClient, error) {
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.SessionTimeout <= 0 {
+		opts.SessionTimeout = 10 * time.Second
+	}
+	if opts.Root == "" {
+		opts.Root = "/glock"
+	}
+	opts.Root = strings.TrimSuffix(opts.Root, "/")
+	if opts.ACL == nil {
+		opts.ACL = zk.WorldACL(zk.PermAll)
+	}
+	if opts.DialFunc == nil {
+		opts.DialFunc = zkConnect
+	}
+	c := &ZookeeperClient{opts: opts}
+	err := c.Reconnect()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Clone returns a disconnected copy of the currenct client
+func (c *ZookeeperClient) Clone() Client {
+	return &ZookeeperClient{
+		conn: nil,
+		opts: c.opts,
+	}
+}
+
+// Close closes the connection to zookeeper. This ends the session, so all
+// the locks held by the client are released.
+func (c *ZookeeperClient) Close() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.close()
+}
+
+func (c *ZookeeperClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Reconnect reconnects to zookeeper, or connects if not connected
+func (c *ZookeeperClient) Reconnect() error {
+	return c.ReconnectContext(context.Background())
+}
+
+// ReconnectContext reconnects to zookeeper, or connects if not connected.
+// It returns as soon as ctx is done, even if the dial is still in progress.
+func (c *ZookeeperClient) ReconnectContext(ctx context.Context) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.reconnect(ctx)
+}
+
+func (c *ZookeeperClient) reconnect(ctx context.Context) error {
+	type dialResult struct {
+		conn ZookeeperConn
+		err  error
+	}
+
+	c.close()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	res := make(chan dialResult, 1)
+	go func() {
+		conn, err := c.opts.DialFunc(c.opts.Servers, c.opts.SessionTimeout)
+		res <- dialResult{conn, err}
+	}()
+
+	select {
+	case r := <-res:
+		if r.err != nil {
+			return r.err
+		}
+		c.conn = r.conn
+		return nil
+
+	case <-ctx.Done():
+		// do not leak the connection if the dial completes later on
+		go func() {
+			if r := <-res; r.err == nil {
+				r.conn.Close()
+			}
+		}()
+		return ctx.Err()
+	}
+}
+
+// connect returns the connection, connecting if not connected
+func (c *ZookeeperClient) connect(ctx context.Context) (ZookeeperConn, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.conn == nil {
+		if err := c.reconnect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c.conn, nil
+}
+
+// do runs f against the connection, returning as soon as ctx is done.
+// Calls to zookeeper cannot be aborted, so f keeps running in the background:
+// if it eventually succeeds, undo (if not nil) is called to revert its effects.
+func (c *ZookeeperClient) do(ctx context.Context, f func(conn ZookeeperConn) error, undo func(conn ZookeeperConn)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		return f(conn)
+	}
+
+	res := make(chan error, 1)
+	go func() {
+		res <- f(conn)
+	}()
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		go func() {
+			if err := <-res; err == nil && undo != nil {
+				undo(conn)
+			}
+		}()
+		return ctx.Err()
+	}
+}
+
+// SetID sets the ID for the current client
+func (c *ZookeeperClient) SetID(id string) {
+	c.opts.ClientID = id
+}
+
+// ID returns the current client ID
+func (c *ZookeeperClient) ID() string {
+	return c.opts.ClientID
+}
+
+// NewLock creates a new Lock. Lock is not automatically acquired.
+func (c *ZookeeperClient) NewLock(name string) Lock {
+	return &ZookeeperLock{
+		name:   name,
+		ttl:    time.Duration(0),
+		client: c,
+	}
+}
+
+func (l *ZookeeperLock) path() string {
+	return l.client.opts.Root + "/" + l.name
+}
+
+func (l *ZookeeperLock) node(ttl time.Duration, claimed bool) []byte {
+	data, _ := json.Marshal(zkNode{
+		Owner:   l.client.ID(),
+		Data:    l.data,
+		TTL:     int64(ttl),
+		Claimed: claimed,
+	})
+	return data
+}
+
+// ensurePath creates the lock node and its parents, if missing
+func (l *ZookeeperLock) ensurePath(conn ZookeeperConn) error {
+	path := ""
+	for _, part := range strings.Split(strings.TrimPrefix(l.path(), "/"), "/") {
+		path += "/" + part
+		_, err := conn.Create(path, nil, 0, l.client.opts.ACL)
+		if err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
+// contenders returns the nodes queued for the lock, sorted by sequence number.
+// Zookeeper cannot be asked for its time, so the lock node is touched first:
+// its modification time is the time the leases are compared with.
+func (l *ZookeeperLock) contenders(conn ZookeeperConn) ([]*zkContender, error) {
+	stat, err := conn.Set(l.path(), nil, -1)
+	if err == zk.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := zkTime(stat.Mtime)
+	children, _, err := conn.Children(l.path())
+	if err == zk.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var contenders []*zkContender
+	for _, child := range children {
+		if !strings.HasPrefix(child, zkPrefix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimPrefix(child, zkPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		path := l.path() + "/" + child
+		data, stat, err := conn.Get(path)
+		if err == zk.ErrNoNode {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		c := &zkContender{path: path, seq: seq, version: stat.Version}
+		if err := json.Unmarshal(data, &c.node); err != nil {
+			return nil, err
+		}
+		// the timestamps of zookeeper are in milliseconds: the node might
+		// have been written at the end of its millisecond, and now might be
+		// at the start of its own, so it is deemed live up to 1ms longer
+		c.left = zkTime(stat.Mtime).Add(time.Duration(c.node.TTL) + time.Millisecond).Sub(now)
+		contenders = append(contenders, c)
+	}
+	sort.Slice(contenders, func(i, j int) bool { return contenders[i].seq < contenders[j].seq })
+	return contenders, nil
+}
+
+// head returns the first live node of contenders, if any
+func head(contenders []*zkContender) *zkContender {
+	for _, c := range contenders {
+		if c.live() {
+			return c
+		}
+	}
+	return nil
+}
+
+// holder returns the node holding the lock, if any: the first live node,
+// once claimed
+func (l *ZookeeperLock) holder(conn ZookeeperConn) (*zkContender, error) {
+	contenders, err := l.contenders(conn)
+	if err != nil {
+		return nil, err
+	}
+	if c := head(contenders); c != nil && c.node.Claimed {
+		return c, nil
+	}
+	return nil, nil
+}
+
+// predecessor returns the first live node queued before seq, deleting the
+// expired ones on the way.
+func (l *ZookeeperLock) predecessor(conn ZookeeperConn, seq uint64) (*zkContender, error) {
+	contenders, err := l.contenders(conn)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range contenders {
+		if c.seq >= seq {
+			break
+		}
+		if c.live() {
+			return c, nil
+		}
+		err := conn.Delete(c.path, c.version)
+		if err != nil && err != zk.ErrNoNode && err != zk.ErrBadVersion {
+			return nil, err
+		}
+		if err == zk.ErrBadVersion {
+			// it has just been refreshed
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+// enqueue creates the node of this client in the lock queue
+func (l *ZookeeperLock) enqueue(conn ZookeeperConn, ttl time.Duration) (string, uint64, error) {
+	if err := l.ensurePath(conn); err != nil {
+		return "", 0, err
+	}
+	path, err := conn.Create(l.path()+"/"+zkPrefix, l.node(ttl, false),
+		zk.FlagEphemeral|zk.FlagSequence, l.client.opts.ACL)
+	if err != nil {
+		return "", 0, err
+	}
+	seq, err := strconv.ParseUint(path[strings.LastIndex(path, zkPrefix)+len(zkPrefix):], 10, 64)
+	if err != nil {
+		conn.Delete(path, -1)
+		return "", 0, err
+	}
+	return path, seq, nil
+}
+
+// claim starts the lease of the node of this client, with the given version,
+// once no live node is queued before it: the node is marked as claimed, which
+// restarts its lease, and checked to be the first live one again. The node
+// might have expired since it was written, and been deleted by another
+// contender, in which case ErrLockHeldByOtherClient is returned.
+func (l *ZookeeperLock) claim(conn ZookeeperConn, path string, seq uint64, version int32, ttl time.Duration) error {
+	start := time.Now()
+	if _, err := conn.Set(path, l.node(ttl, true), version); err != nil {
+		if err == zk.ErrNoNode || err == zk.ErrBadVersion {
+			return ErrLockHeldByOtherClient
+		}
+		return err
+	}
+	pred, err := l.predecessor(conn, seq)
+	if err != nil {
+		return err
+	}
+	if pred != nil || time.Since(start) >= ttl {
+		return ErrLockHeldByOtherClient
+	}
+	return nil
+}
+
+// Acquire acquires the lock for the specified time lentgh (ttl).
+// It returns immadiately if the lock cannot be acquired
+func (l *ZookeeperLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext is like Acquire, but returns as soon as ctx is done.
+// If the lock gets acquired after that, it is released.
+func (l *ZookeeperLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	var token uint64
+	err := l.client.do(ctx, func(conn ZookeeperConn) error {
+		path, seq, err := l.enqueue(conn, ttl)
+		if err != nil {
+			return err
+		}
+		pred, err := l.predecessor(conn, seq)
+		if err == nil && pred != nil {
+			err = ErrLockHeldByOtherClient
+		}
+		if err == nil {
+			// the node was just created, with version 0
+			err = l.claim(conn, path, seq, 0, ttl)
+		}
+		if err != nil {
+			conn.Delete(path, -1)
+			return err
+		}
+		token = seq + 1
+		return nil
+	}, l.release)
+	if err != nil {
+		return err
+	}
+	l.token = token
+	return nil
+}
+
+// AcquireQueued is like Acquire, but if the lock is held it waits in the
+// queue for its turn: waiters get the lock in arrival order.
+func (l *ZookeeperLock) AcquireQueued(ttl time.Duration) error {
+	return l.AcquireQueuedContext(context.Background(), ttl)
+}
+
+// AcquireQueuedContext is like AcquireQueued, but leaves the queue as soon as
+// ctx is done, in which case the context error is returned.
+// While waiting, the node of the client is kept alive by refreshing it every ttl/2.
+func (l *ZookeeperLock) AcquireQueuedContext(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	var path string
+	var seq uint64
+	var version int32
+	var conn ZookeeperConn
+	err := l.client.do(ctx, func(c ZookeeperConn) error {
+		var err error
+		conn = c
+		path, seq, err = l.enqueue(c, ttl)
+		return err
+	}, func(conn ZookeeperConn) {
+		conn.Delete(path, -1)
+	})
+	if err != nil {
+		return err
+	}
+	leave := func(err error) error {
+		conn.Delete(path, -1)
+		return err
+	}
+
+	for {
+		pred, err := l.predecessor(conn, seq)
+		if err != nil {
+			return leave(err)
+		}
+		if pred == nil {
+			if err := l.claim(conn, path, seq, version, ttl); err != nil {
+				return leave(err)
+			}
+			l.token = seq + 1
+			return nil
+		}
+		exists, _, events, err := conn.ExistsW(pred.path)
+		if err != nil {
+			return leave(err)
+		}
+		wait := ttl / 2
+		if pred.left < wait {
+			wait = pred.left
+		}
+		if exists {
+			select {
+			case <-events:
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return leave(ctx.Err())
+			}
+		}
+		stat, err := conn.Set(path, l.node(ttl, false), -1)
+		if err != nil {
+			if err == zk.ErrNoNode {
+				// expired while waiting, and deleted by someone else
+				return ErrLockHeldByOtherClient
+			}
+			return leave(err)
+		}
+		version = stat.Version
+	}
+}
+
+// release deletes the node of this client holding the lock
+func (l *ZookeeperLock) release(conn ZookeeperConn) {
+	holder, err := l.holder(conn)
+	if err == nil && holder != nil && holder.node.Owner == l.client.ID() {
+		conn.Delete(holder.path, holder.version)
+	}
+}
+
+// Release releases the lock if owned. Returns an error if the lock is not owned by this client
+func (l *ZookeeperLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext is like Release, but returns as soon as ctx is done.
+func (l *ZookeeperLock) ReleaseContext(ctx context.Context) error {
+	return l.client.do(ctx, func(conn ZookeeperConn) error {
+		holder, err := l.holder(conn)
+		if err != nil {
+			return err
+		}
+		if holder == nil || holder.node.Owner != l.client.ID() {
+			return ErrLockNotOwned
+		}
+		err = conn.Delete(holder.path, holder.version)
+		if err == zk.ErrNoNode || err == zk.ErrBadVersion {
+			return ErrLockNotOwned
+		}
+		return err
+	}, nil)
+}
+
+// RefreshTTL extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
+func (l *ZookeeperLock) RefreshTTL(ttl time.Duration) error {
+	return l.RefreshTTLContext(context.Background(), ttl)
+}
+
+// RefreshTTLContext is like RefreshTTL, but returns as soon as ctx is done.
+func (l *ZookeeperLock) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
+	l.ttl = ttl
+	return l.RefreshContext(ctx)
+}
+
+// Refresh extends the lock by writing its node again, which restarts its lease.
+// It returns an error if the lock is not owned by the current client, which
+// is also the case when the session that acquired it expired.
+func (l *ZookeeperLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, but returns as soon as ctx is done.
+func (l *ZookeeperLock) RefreshContext(ctx context.Context) error {
+	if l.ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	return l.client.do(ctx, func(conn ZookeeperConn) error {
+		holder, err := l.holder(conn)
+		if err != nil {
+			return err
+		}
+		if holder == nil || holder.node.Owner != l.client.ID() {
+			return ErrLockNotOwned
+		}
+		_, err = conn.Set(holder.path, l.node(l.ttl, true), holder.version)
+		if err == zk.ErrNoNode || err == zk.ErrBadVersion {
+			return ErrLockNotOwned
+		}
+		return err
+	}, nil)
+}
+
+// Info returns information about the lock.
+func (l *ZookeeperLock) Info() (*LockInfo, error) {
+	return l.InfoContext(context.Background())
+}
+
+// InfoContext is like Info, but returns as soon as ctx is done.
+func (l *ZookeeperLock) InfoContext(ctx context.Context) (*LockInfo, error) {
+	var contenders []*zkContender
+	err := l.client.do(ctx, func(conn ZookeeperConn) error {
+		var err error
+		contenders, err = l.contenders(conn)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the first live node holds the lock once claimed, and the others wait
+	// for it
+	info := &LockInfo{Name: l.name, Acquired: false}
+	holder := head(contenders)
+	if holder != nil && !holder.node.Claimed {
+		holder = nil
+	}
+	for _, c := range contenders {
+		if c == holder || !c.live() {
+			continue
+		}
+		info.Queued++
+		if c.node.Owner == l.client.ID() && info.Position == 0 {
+			info.Position = info.Queued
+		}
+	}
+	if holder == nil {
+		return info, nil
+	}
+	info.Acquired = true
+	info.Owner = holder.node.Owner
+	// report what is left of the lease at least, up to 2ms less than left
+	info.TTL = holder.left - 2*time.Millisecond
+	info.Data = holder.node.Data
+	info.Token = holder.seq + 1
+	return info, nil
+}
+
+// SetData sets the data payload for the lock.
+// The data is set into the backend only when the lock is acquired,
+// so any call to this method after acquisition won't update the value.
+func (l *ZookeeperLock) SetData(data string) {
+	l.data = data
+}
+
+// Token returns the fencing token obtained by the last successful Acquire
+func (l *ZookeeperLock) Token() uint64 {
+	return l.token
+}
@@ -0,0 +1,499 @@
+// +build zookeeper
+
+package glock
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/gocql/gocql"
+)
+
+// fakeZookeeper is an in-process zookeeper server, implementing the subset
+// of the zookeeper semantics used by the driver: persistent, ephemeral and
+// sequential nodes, versions, modification times, exists watches and sessions.
+type fakeZookeeper struct {
+	mtx         sync.Mutex
+	nodes       map[string]*fakeZnode
+	watches     map[string][]chan zk.Event
+	lastSession int64
+	// skew is how far the clock of the server is ahead of the local one
+	skew time.Duration
+}
+
+type fakeZnode struct {
+	data     []byte
+	version  int32
+	mtime    int64
+	session  int64
+	sequence int
+}
+
+// fakeZkConn is a connection to the fake server, with its own session
+type fakeZkConn struct {
+	server  *fakeZookeeper
+	session int64
+	closed  bool
+}
+
+func newFakeZookeeper() *fakeZookeeper {
+	return &fakeZookeeper{
+		nodes:   map[string]*fakeZnode{"/": {}},
+		watches: make(map[string][]chan zk.Event),
+	}
+}
+
+func (z *fakeZookeeper) dial(servers []string, sessionTimeout time.Duration) (ZookeeperConn, error) {
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+	z.lastSession++
+	return &fakeZkConn{server: z, session: z.lastSession}, nil
+}
+
+// now returns the time of the server, in milliseconds. z.mtx must be held
+func (z *fakeZookeeper) now() int64 {
+	return time.Now().Add(z.skew).UnixNano() / int64(time.Millisecond)
+}
+
+// stat returns the stat of node
+func (node *fakeZnode) stat() *zk.Stat {
+	return &zk.Stat{Version: node.version, Mtime: node.mtime, EphemeralOwner: node.session}
+}
+
+// fire notifies and removes the watches on path. z.mtx must be held
+func (z *fakeZookeeper) fire(p string, t zk.EventType) {
+	for _, w := range z.watches[p] {
+		w <- zk.Event{Type: t, Path: p}
+	}
+	delete(z.watches, p)
+}
+
+// endSession deletes the ephemeral nodes of the session. z.mtx must be held
+func (z *fakeZookeeper) endSession(session int64) {
+	for p, node := range z.nodes {
+		if node.session == session {
+			delete(z.nodes, p)
+			z.fire(p, zk.EventNodeDeleted)
+		}
+	}
+}
+
+// expire expires the session of the connection, like the server does when
+// the client does not heartbeat in time. The connection gets a new session.
+func (c *fakeZkConn) expire() {
+	c.server.mtx.Lock()
+	defer c.server.mtx.Unlock()
+	c.server.endSession(c.session)
+	c.server.lastSession++
+	c.session = c.server.lastSession
+}
+
+func (c *fakeZkConn) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	z := c.server
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+	if c.closed {
+		return "", zk.ErrConnectionClosed
+	}
+	parent, ok := z.nodes[path.Dir(p)]
+	if !ok {
+		return "", zk.ErrNoNode
+	}
+	if flags&zk.FlagSequence != 0 {
+		p = fmt.Sprintf("%s%010d", p, parent.sequence)
+		parent.sequence++
+	}
+	if _, ok := z.nodes[p]; ok {
+		return "", zk.ErrNodeExists
+	}
+	node := &fakeZnode{data: data, mtime: z.now()}
+	if flags&zk.FlagEphemeral != 0 {
+		node.session = c.session
+	}
+	z.nodes[p] = node
+	z.fire(p, zk.EventNodeCreated)
+	return p, nil
+}
+
+func (c *fakeZkConn) Get(p string) ([]byte, *zk.Stat, error) {
+	z := c.server
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+	if c.closed {
+		return nil, nil, zk.ErrConnectionClosed
+	}
+	node, ok := z.nodes[p]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return node.data, node.stat(), nil
+}
+
+func (c *fakeZkConn) Set(p string, data []byte, version int32) (*zk.Stat, error) {
+	z := c.server
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+	if c.closed {
+		return nil, zk.ErrConnectionClosed
+	}
+	node, ok := z.nodes[p]
+	if !ok {
+		return nil, zk.ErrNoNode
+	}
+	if version != -1 && version != node.version {
+		return nil, zk.ErrBadVersion
+	}
+	node.data = data
+	node.version++
+	node.mtime = z.now()
+	z.fire(p, zk.EventNodeDataChanged)
+	return node.stat(), nil
+}
+
+func (c *fakeZkConn) Delete(p string, version int32) error {
+	z := c.server
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+	if c.closed {
+		return zk.ErrConnectionClosed
+	}
+	node, ok := z.nodes[p]
+	if !ok {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != node.version {
+		return zk.ErrBadVersion
+	}
+	for other := range z.nodes {
+		if path.Dir(other) == p {
+			return zk.ErrNotEmpty
+		}
+	}
+	delete(z.nodes, p)
+	z.fire(p, zk.EventNodeDeleted)
+	return nil
+}
+
+func (c *fakeZkConn) Children(p string) ([]string, *zk.Stat, error) {
+	z := c.server
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+	if c.closed {
+		return nil, nil, zk.ErrConnectionClosed
+	}
+	node, ok := z.nodes[p]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	var children []string
+	for other := range z.nodes {
+		if other != "/" && path.Dir(other) == p {
+			children = append(children, strings.TrimPrefix(other[len(p):], "/"))
+		}
+	}
+	return children, node.stat(), nil
+}
+
+func (c *fakeZkConn) ExistsW(p string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	z := c.server
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+	if c.closed {
+		return false, nil, nil, zk.ErrConnectionClosed
+	}
+	w := make(chan zk.Event, 1)
+	z.watches[p] = append(z.watches[p], w)
+	node, ok := z.nodes[p]
+	if !ok {
+		return false, nil, w, nil
+	}
+	return true, node.stat(), w, nil
+}
+
+func (c *fakeZkConn) Close() {
+	z := c.server
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+	if !c.closed {
+		c.closed = true
+		z.endSession(c.session)
+	}
+}
+
+var fakeZk = newFakeZookeeper()
+
+func zookeeperClient(t *testing.T) Client {
+	opts := ZookeeperOptions{
+		ClientID: gocql.TimeUUID().String(),
+		Root:     "/glock/tests",
+		DialFunc: fakeZk.dial,
+	}
+	c, err := NewZookeeperClient(opts)
+	if err != nil {
+		t.Fatalf("Cannot create zookeeper client: %s", err)
+	}
+	return c
+}
+
+func init() {
+	Drivers["zookeeper"] = Driver{zookeeperClient, time.Millisecond}
+}
+
+func TestZookeeperClient(t *testing.T) {
+	testClient(t, zookeeperClient)
+}
+
+func TestZookeeperLock(t *testing.T) {
+	testLock(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperLockContext(t *testing.T) {
+	testLockContext(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperLockToken(t *testing.T) {
+	testLockToken(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperLockFairQueue(t *testing.T) {
+	testLockFairQueue(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperManagerFair(t *testing.T) {
+	testManagerFair(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperManagerAcquire(t *testing.T) {
+	testManagerAcquire(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperManagerAcquireWait(t *testing.T) {
+	testManagerAcquireWait(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperManagerFailReleaseAll(t *testing.T) {
+	testManagerFailReleaseAll(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperManagerAcquireContext(t *testing.T) {
+	testManagerAcquireContext(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperManagerConcurrent(t *testing.T) {
+	testManagerConcurrent(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperManagerAcquireAll(t *testing.T) {
+	testManagerAcquireAll(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperManagerWatch(t *testing.T) {
+	testManagerWatch(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperManagerKeeper(t *testing.T) {
+	testManagerKeeper(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperManagerLease(t *testing.T) {
+	testManagerLease(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperManagerReentrant(t *testing.T) {
+	testManagerReentrant(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperElection(t *testing.T) {
+	testElection(t, zookeeperClient, time.Millisecond)
+}
+
+func TestZookeeperSessionExpiry(t *testing.T) {
+	c := zookeeperClient(t)
+	m := NewLockManager(c, options(time.Millisecond, ttlLength, 0, defData))
+
+	err := m.Acquire(lockName, AcquireOptions{})
+	if err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	control, err := m.StartHeartbeat(lockName)
+	if err != nil {
+		t.Fatalf("Cannot start heartbeats: '%s'", err)
+	}
+
+	// The ephemeral node goes away with the session, and so does the lock
+	c.(*ZookeeperClient).conn.(*fakeZkConn).expire()
+	select {
+	case err = <-control:
+		if err != ErrLockNotOwned {
+			t.Errorf("Heartbeat should report '%s', got '%s'", ErrLockNotOwned, err)
+		}
+	case <-time.After(time.Duration(ttlLength) * time.Millisecond * 2):
+		t.Fatalf("Heartbeat did not report the lost lock")
+	}
+
+	other := zookeeperClient(t)
+	l := other.NewLock(lockName)
+	if err = l.Acquire(time.Duration(ttlLength) * time.Millisecond); err != nil {
+		t.Fatalf("Cannot acquire lock after session expiry: %s", err)
+	}
+	l.Release()
+}
+
+func TestZookeeperFairQueue(t *testing.T) {
+	scale := time.Millisecond
+	ttl := time.Duration(ttlLength) * scale
+	holder := zookeeperClient(t).NewLock(lockName)
+	if err := holder.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+
+	// Waiters queue up in order, and get the lock in the same order
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	var order []int
+	for i := 0; i < 3; i++ {
+		l := zookeeperClient(t).NewLock(lockName).(*ZookeeperLock)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := l.AcquireQueued(ttl); err != nil {
+				t.Errorf("Waiter %d cannot acquire lock: %s", i, err)
+				return
+			}
+			mtx.Lock()
+			order = append(order, i)
+			mtx.Unlock()
+			time.Sleep(scale)
+			l.Release()
+		}(i)
+		// give the waiter the time to enqueue
+		time.Sleep(5 * scale)
+	}
+
+	// Non-queued contenders cannot jump the queue
+	if err := zookeeperClient(t).NewLock(lockName).Acquire(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+	holder.Release()
+	wg.Wait()
+	if fmt.Sprint(order) != "[0 1 2]" {
+		t.Errorf("Waiters should get the lock in arrival order, got %v", order)
+	}
+}
+
+func TestZookeeperClaim(t *testing.T) {
+	ttl := time.Duration(ttlLength) * time.Millisecond
+	c := zookeeperClient(t).(*ZookeeperClient)
+	l := c.NewLock("claim").(*ZookeeperLock)
+	conn, err := c.connect(context.Background())
+	if err != nil {
+		t.Fatalf("Cannot connect: %s", err)
+	}
+
+	// A node which expired before being claimed might be gone already
+	path, seq, err := l.enqueue(conn, ttl)
+	if err != nil {
+		t.Fatalf("Cannot enqueue: %s", err)
+	}
+	if err = conn.Delete(path, 0); err != nil {
+		t.Fatalf("Cannot delete node: %s", err)
+	}
+	if err = l.claim(conn, path, seq, 0, ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%v'", ErrLockHeldByOtherClient, err)
+	}
+
+	// Otherwise the lease starts from the claim, whenever the node was written
+	if path, seq, err = l.enqueue(conn, time.Nanosecond); err != nil {
+		t.Fatalf("Cannot enqueue: %s", err)
+	}
+	if err = l.claim(conn, path, seq, 0, ttl); err != nil {
+		t.Fatalf("Cannot claim node: %s", err)
+	}
+	defer conn.Delete(path, -1)
+	info, err := l.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if !info.Acquired || info.Owner != c.ID() || info.TTL <= ttl/2 {
+		t.Errorf("info: %+v -- expected Acquired: true, Owner: %s and TTL around %v", info, c.ID(), ttl)
+	}
+}
+
+func TestZookeeperUnclaimed(t *testing.T) {
+	ttl := time.Duration(ttlLength) * time.Millisecond
+	c := zookeeperClient(t).(*ZookeeperClient)
+	l := c.NewLock("unclaimed").(*ZookeeperLock)
+	conn, err := c.connect(context.Background())
+	if err != nil {
+		t.Fatalf("Cannot connect: %s", err)
+	}
+
+	// A waiter at the head of the queue does not hold the lock until claimed
+	path, seq, err := l.enqueue(conn, ttl)
+	if err != nil {
+		t.Fatalf("Cannot enqueue: %s", err)
+	}
+	defer conn.Delete(path, -1)
+	info, err := l.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Acquired || info.Queued != 1 || info.Position != 1 {
+		t.Errorf("info: %+v -- expected Acquired: false, Queued: 1 and Position: 1", info)
+	}
+	other := c.NewLock("unclaimed")
+	if err = other.RefreshTTL(ttl); err != ErrLockNotOwned {
+		t.Errorf("Refresh of an unclaimed node, expected '%s', got '%v'", ErrLockNotOwned, err)
+	}
+	if err = other.Release(); err != ErrLockNotOwned {
+		t.Errorf("Release of an unclaimed node, expected '%s', got '%v'", ErrLockNotOwned, err)
+	}
+
+	if err = l.claim(conn, path, seq, 0, ttl); err != nil {
+		t.Fatalf("Cannot claim node: %s", err)
+	}
+	if info, err = l.Info(); err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if !info.Acquired || info.Owner != c.ID() || info.Queued != 0 {
+		t.Errorf("info: %+v -- expected Acquired: true, Owner: %s and Queued: 0", info, c.ID())
+	}
+}
+
+func TestZookeeperServerClock(t *testing.T) {
+	ttl := time.Duration(ttlLength) * time.Millisecond
+	z := newFakeZookeeper()
+	newClient := func() Client {
+		c, err := NewZookeeperClient(ZookeeperOptions{
+			ClientID: gocql.TimeUUID().String(),
+			DialFunc: z.dial,
+		})
+		if err != nil {
+			t.Fatalf("Cannot create zookeeper client: %s", err)
+		}
+		return c
+	}
+	l1 := newClient().NewLock("clock")
+	l2 := newClient().NewLock("clock")
+	if err := l1.Acquire(ttl * 100); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+
+	// Leases are measured by the clock of the server, not by the local one
+	z.mtx.Lock()
+	z.skew = ttl * 101
+	z.mtx.Unlock()
+	if err := l2.Acquire(ttl); err != nil {
+		t.Fatalf("Lock should have expired by the server clock: %s", err)
+	}
+	if err := l1.Refresh(); err != ErrLockNotOwned {
+		t.Errorf("Expected error '%s', got '%v'", ErrLockNotOwned, err)
+	}
+	l2.Release()
+}
@@ -0,0 +1,169 @@
+package glock
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrNoLeader is returned when nobody is leading an election
+var ErrNoLeader = errors.New("No leader elected")
+
+// ElectionOptions configures an Election
+type ElectionOptions struct {
+	// TTL is the ttl of the lock held by the leader. It bounds how long the
+	// election stays without a leader if the leader crashes.
+	TTL time.Duration
+	// Data is published with the leadership, e.g. the address of the leader
+	Data string
+	// Keeper configures how the leader keeps its lease. OnLoss is ignored:
+	// leadership is given up as soon as the lease is lost.
+	Keeper KeeperOptions
+	// PollInterval is how often Observe and Campaign check the lock of the
+	// election. If <= 0, half the TTL is used.
+	PollInterval time.Duration
+}
+
+// LeaderInfo describes the leader of an election
+type LeaderInfo struct {
+	// ID is the ID of the client of the leader
+	ID string
+	// Data is the data published by the leader
+	Data string
+	// Token is the fencing token of the lock of the leader, which increases
+	// at every term
+	Token uint64
+}
+
+// Election elects a leader among the clients campaigning for the same name.
+// The leader is the holder of the lock with that name, which is kept by a
+// lease keeper for as long as the leader does not resign. Elections work
+// with any Client.
+type Election struct {
+	Logger  *log.Logger
+	name    string
+	opts    ElectionOptions
+	manager *LockManager
+}
+
+// NewElection returns a new Election with the given name, in which the
+// given client campaigns.
+func NewElection(client Client, name string, opts ElectionOptions) (*Election, error) {
+	if opts.TTL <= 0 {
+		return nil, ErrInvalidTTL
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = opts.TTL / 2
+	}
+	opts.Keeper.OnLoss = ForgetOnLoss
+	manager := NewLockManager(client, AcquireOptions{
+		TTL:     opts.TTL,
+		MaxWait: opts.PollInterval,
+		Data:    opts.Data,
+	})
+	return &Election{manager.Logger, name, opts, manager}, nil
+}
+
+// Name returns the name of the election
+func (e *Election) Name() string {
+	return e.name
+}
+
+// Campaign blocks until the client is elected leader.
+// It returns a context which is cancelled when the leadership ends, either
+// because the client resigned or because its lease was lost. Once lost,
+// the client must campaign again to get the leadership back.
+func (e *Election) Campaign() (context.Context, error) {
+	return e.CampaignContext(context.Background())
+}
+
+// CampaignContext is like Campaign, but gives up when ctx is done.
+func (e *Election) CampaignContext(ctx context.Context) (context.Context, error) {
+	for {
+		err := e.manager.AcquireContext(ctx, e.name, AcquireOptions{})
+		if err == nil {
+			break
+		}
+		if err != ErrLockHeldByOtherClient {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if _, err := e.manager.KeepLease(e.name, e.opts.Keeper); err != nil {
+		e.manager.ReleaseContext(ctx, e.name)
+		return nil, err
+	}
+	e.Logger.Printf("client %s: Elected leader of '%s'", e.manager.client.ID(), e.name)
+	return e.manager.Context(e.name)
+}
+
+// Resign gives up the leadership, if the client holds it, so that another
+// candidate can be elected without waiting for the lease to expire.
+func (e *Election) Resign() error {
+	return e.ResignContext(context.Background())
+}
+
+// ResignContext is like Resign, but honours the given context.
+func (e *Election) ResignContext(ctx context.Context) error {
+	err := e.manager.ReleaseContext(ctx, e.name)
+	if err == ErrInvalidLock || notOwned(err) {
+		// not the leader, or not anymore
+		return nil
+	}
+	return err
+}
+
+// Leader returns the current leader of the election, or ErrNoLeader if
+// nobody leads it.
+func (e *Election) Leader() (*LeaderInfo, error) {
+	return e.LeaderContext(context.Background())
+}
+
+// LeaderContext is like Leader, but honours the given context.
+func (e *Election) LeaderContext(ctx context.Context) (*LeaderInfo, error) {
+	info, err := e.manager.client.NewLock(e.name).InfoContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Acquired {
+		return nil, ErrNoLeader
+	}
+	return &LeaderInfo{ID: info.Owner, Data: info.Data, Token: info.Token}, nil
+}
+
+// Observe returns a channel which receives the leader of the election
+// every time it changes, starting with the current one. A zero LeaderInfo
+// is sent when the election has no leader. The lock of the election is
+// polled every PollInterval, so short terms may be missed. The channel is
+// closed when ctx is done.
+func (e *Election) Observe(ctx context.Context) <-chan LeaderInfo {
+	ch := make(chan LeaderInfo)
+	go func() {
+		defer close(ch)
+		var last *LeaderInfo
+		for {
+			leader, err := e.LeaderContext(ctx)
+			if err == ErrNoLeader {
+				leader, err = &LeaderInfo{}, nil
+			}
+			if err != nil {
+				e.Logger.Printf("client %s: Cannot observe leader of '%s': %s",
+					e.manager.client.ID(), e.name, err)
+			} else if last == nil || *leader != *last {
+				select {
+				case ch <- *leader:
+					last = leader
+				case <-ctx.Done():
+					return
+				}
+			}
+			if !sleep(ctx, e.manager.clock(), e.opts.PollInterval) {
+				return
+			}
+		}
+	}()
+	return ch
+}
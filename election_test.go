@@ -0,0 +1,115 @@
+package glock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func elections(t *testing.T, c1, c2 Client, scale time.Duration) (*Election, *Election) {
+	ttl := time.Duration(ttlLength) * scale
+	e1, err := NewElection(c1, "election", ElectionOptions{TTL: ttl, Data: "e1", PollInterval: ttl / 4})
+	if err != nil {
+		t.Fatalf("Cannot create election: %s", err)
+	}
+	e2, err := NewElection(c2, "election", ElectionOptions{TTL: ttl, Data: "e2", PollInterval: ttl / 4})
+	if err != nil {
+		t.Fatalf("Cannot create election: %s", err)
+	}
+	return e1, e2
+}
+
+func observed(t *testing.T, ch <-chan LeaderInfo, id string, timeout time.Duration) LeaderInfo {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case leader := <-ch:
+			if leader.ID == id {
+				return leader
+			}
+		case <-deadline:
+			t.Fatalf("Leader '%s' not observed after %v", id, timeout)
+		}
+	}
+}
+
+func testElection(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1, c2 := cfun(t), cfun(t)
+	e1, e2 := elections(t, c1, c2, scale)
+	defer e1.Resign()
+	defer e2.Resign()
+	ttl := time.Duration(ttlLength) * scale
+
+	if _, err := NewElection(c1, "election", ElectionOptions{}); err != ErrInvalidTTL {
+		t.Errorf("NewElection, expected: '%s', got: '%s'", ErrInvalidTTL, err)
+	}
+	if _, err := e1.Leader(); err != ErrNoLeader {
+		t.Errorf("Leader, expected: '%s', got: '%s'", ErrNoLeader, err)
+	}
+	if err := e1.Resign(); err != nil {
+		t.Errorf("Resigning without leading should not fail, got '%s'", err)
+	}
+
+	octx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	obs := e2.Observe(octx)
+	if leader := observed(t, obs, "", ttl); leader != (LeaderInfo{}) {
+		t.Errorf("Expected no leader, got %+v", leader)
+	}
+
+	lctx, err := e1.Campaign()
+	if err != nil {
+		t.Fatalf("Cannot campaign: %s", err)
+	}
+	leader, err := e2.Leader()
+	if err != nil {
+		t.Fatalf("Cannot get leader: %s", err)
+	}
+	if leader.ID != c1.ID() || leader.Data != "e1" {
+		t.Errorf("Leader: %+v -- expected ID: %s, Data: e1", leader, c1.ID())
+	}
+	observed(t, obs, c1.ID(), ttl)
+
+	// The leader keeps its lease, so other candidates wait
+	cctx, ccancel := context.WithTimeout(context.Background(), ttl*2)
+	_, err = e2.CampaignContext(cctx)
+	ccancel()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Campaign while somebody leads, expected: '%s', got: '%v'", context.DeadlineExceeded, err)
+	}
+	if lctx.Err() != nil {
+		t.Fatalf("Leadership should be kept, got '%s'", lctx.Err())
+	}
+
+	// Resigning ends the leadership, and elects the next candidate
+	elected := make(chan error, 1)
+	go func() {
+		_, err := e2.Campaign()
+		elected <- err
+	}()
+	time.Sleep(ttl / 4)
+	if err = e1.Resign(); err != nil {
+		t.Fatalf("Cannot resign: %s", err)
+	}
+	waitDone(t, lctx, scale)
+	select {
+	case err = <-elected:
+		if err != nil {
+			t.Fatalf("Cannot campaign: %s", err)
+		}
+	case <-time.After(ttl * 2):
+		t.Fatalf("Candidate not elected after the leader resigned")
+	}
+	next := observed(t, obs, c2.ID(), ttl)
+	if next.Data != "e2" || next.Token <= leader.Token {
+		t.Errorf("Leader: %+v -- expected Data: e2, Token > %d", next, leader.Token)
+	}
+	if err = e2.Resign(); err != nil {
+		t.Fatalf("Cannot resign: %s", err)
+	}
+	observed(t, obs, "", ttl)
+
+	cancel()
+	for range obs {
+	}
+}
@@ -0,0 +1,17 @@
+package glock
+
+import (
+	"testing"
+	"time"
+)
+
+// Driver is a driver built into the test binary, with the client and time
+// scale used by its tests
+type Driver struct {
+	NewClient func(t *testing.T) Client
+	Scale     time.Duration
+}
+
+// Drivers are registered by the driver tests, so that the glocktest suites
+// run against them from package glock_test
+var Drivers = make(map[string]Driver)
@@ -27,7 +27,7 @@ func managerInfo(manager *glock.LockManager, name string) {
 }
 
 func lockInfo(i *glock.LockInfo) {
-	fmt.Printf("Name: %s, Owner: %s, TTL: %s, Acquired: %v, Data: %s\n", i.Name, i.Owner, i.TTL, i.Acquired, i.Data)
+	fmt.Printf("Name: %s, Owner: %s, TTL: %s, Acquired: %v, Data: %s, Token: %d\n", i.Name, i.Owner, i.TTL, i.Acquired, i.Data, i.Token)
 }
 
 func main() {
@@ -38,11 +38,20 @@ func main() {
 
 	switch *tp {
 	case "cassandra":
-		opts := glock.CassandraOptions{[]string{"localhost"}, "test", "", "", "test", 1}
+		opts := glock.CassandraOptions{
+			Hosts:             []string{"localhost"},
+			KeySpace:          "test",
+			TableName:         "test",
+			ReplicationFactor: 1,
+		}
 		c, err = glock.NewCassandraLockClient(opts)
 		c2, err = glock.NewCassandraLockClient(opts)
 	case "redis":
-		opts := glock.RedisOptions{"tcp", "localhost:6379", "", "myns", nil, nil}
+		opts := glock.RedisOptions{
+			Network:   "tcp",
+			Address:   "localhost:6379",
+			Namespace: "myns",
+		}
 		c, err = glock.NewRedisClient(opts)
 		c2, err = glock.NewRedisClient(opts)
 	case "memory":
@@ -40,15 +40,50 @@ func (f *cHostsFlag) Set(value string) error {
 	return nil
 }
 
+type lockNamesFlag struct {
+	names []string
+}
+
+func (f *lockNamesFlag) String() string {
+	return fmt.Sprint(f.names)
+}
+
+func (f *lockNamesFlag) Set(value string) error {
+	if value == "" {
+		return errors.New("lock name cannot be empty")
+	}
+	f.names = append(f.names, value)
+	return nil
+}
+
 var driver = flag.String("driver", "cassandra", "driver to use")
-var name = flag.String("lock", "", "lock name. Required")
+var lockNames lockNamesFlag
 var id = flag.String("client-id", "", "if unset, it will be autogenerated")
 var ttl = flag.Duration("lock-ttl", time.Duration(30)*time.Second, "TTL for the lock")
 var wait = flag.Duration("max-wait", time.Duration(-1), "How long to wait for the lock to be acquired. If <= 0, no wait at all")
+var list = flag.Bool("list", false, "list the locks held, whose name starts with the argument if given, and exit")
 var quiet = flag.Bool("quiet", false, "Disable logging in glock")
+var fair = flag.Bool("fair", false, "wait for the lock in arrival order, with the drivers keeping a queue of waiters (memory, redis, zookeeper)")
+var slots = flag.Int("slots", 0, "if > 0, acquire one of this many slots of the semaphore named by -lock instead of the lock")
 
 var redisAddress = flag.String("redis-server", "localhost:6379", "redis server address (with port)")
 var redisNS = flag.String("redis-namspace", "glock", "namespace for keys in redis. Default is used even if set to be empty on commandline")
+var redlockAddresses = flag.String("redlock-servers", "localhost:6379", "Comma separated list of independent redis masters for the redlock driver (with port)")
+
+var etcdEndpoints = flag.String("etcd-endpoints", "localhost:2379", "Comma separated list of etcd endpoints")
+var etcdNS = flag.String("etcd-namespace", "glock/", "prefix for keys in etcd")
+
+var zkServers = flag.String("zookeeper-servers", "localhost:2181", "Comma separated list of zookeeper servers")
+var zkRoot = flag.String("zookeeper-root", "/glock", "zookeeper node under which locks are created")
+
+var consulAddress = flag.String("consul-address", "localhost:8500", "consul agent address (with port)")
+var consulNS = flag.String("consul-namespace", "glock/", "prefix for keys in consul")
+
+var postgresDSN = flag.String("postgres-dsn", "postgres://localhost/glock?sslmode=disable", "postgres connection string")
+var postgresTable = flag.String("postgres-table", "glock", "postgres table")
+var postgresAdvisory = flag.Bool("postgres-advisory", false, "use postgres advisory locks, released when the connection is lost")
+
+var fileDir = flag.String("file-dir", "", "directory holding the lock files. If unset, $TMPDIR/glock is used")
 
 var cHosts cHostsFlag
 var cassandraKS = flag.String("cassandra-ks", "glock", "cassandra keyspace")
@@ -59,6 +94,7 @@ var cassandraReplFactor = flag.Int("cassandra-replication-factor", 1, "Cassandra
 
 func main() {
 	flag.Var(&cHosts, "cassandra-hosts", "Comma separated list of cassandra hosts")
+	flag.Var(&lockNames, "lock", "lock name. Required. Repeat it to take several locks, all of them or none")
 	flag.Parse()
 
 	var client glock.Client
@@ -84,6 +120,45 @@ func main() {
 		}
 		client, err = glock.NewRedisClient(opts)
 
+	case "redlock":
+		opts := glock.RedlockOptions{Namespace: *redisNS}
+		for _, address := range strings.Split(*redlockAddresses, ",") {
+			opts.Nodes = append(opts.Nodes, glock.RedisOptions{Network: "tcp", Address: address})
+		}
+		client, err = glock.NewRedlockClient(opts)
+
+	case "etcd":
+		opts := glock.EtcdOptions{
+			Endpoints: strings.Split(*etcdEndpoints, ","),
+			Namespace: *etcdNS,
+		}
+		client, err = glock.NewEtcdClient(opts)
+
+	case "zookeeper":
+		opts := glock.ZookeeperOptions{
+			Servers: strings.Split(*zkServers, ","),
+			Root:    *zkRoot,
+		}
+		client, err = glock.NewZookeeperClient(opts)
+
+	case "consul":
+		opts := glock.ConsulOptions{
+			Address:   *consulAddress,
+			Namespace: *consulNS,
+		}
+		client, err = glock.NewConsulClient(opts)
+
+	case "postgres":
+		opts := glock.PostgresOptions{
+			DSN:       *postgresDSN,
+			TableName: *postgresTable,
+			Advisory:  *postgresAdvisory,
+		}
+		client, err = glock.NewPostgresClient(opts)
+
+	case "file":
+		client, err = glock.NewFileClient(glock.FileOptions{Dir: *fileDir})
+
 	default:
 		log.Fatalf("Invalid value for --driver '%s'", *driver)
 	}
@@ -92,7 +167,12 @@ func main() {
 		log.Fatalf("Cannot create lock client: %s", err.Error())
 	}
 
-	if *name == "" {
+	if *list {
+		listLocks(client, flag.Arg(0))
+		return
+	}
+
+	if len(lockNames.names) == 0 {
 		log.Print("Missing lock name (required)")
 		flag.Usage()
 		os.Exit(1)
@@ -109,8 +189,9 @@ func main() {
 		TTL:     *ttl,
 		MaxWait: *wait,
 		Data:    commandStr,
+		Fair:    *fair,
 	}
-	execOpts := glock.ExecOptions{Options: options}
+	execOpts := glock.ExecOptions{Options: options, Slots: *slots, Locks: lockNames.names[1:]}
 	manager := glock.NewLockManager(client, options)
 
 	if !*quiet {
@@ -124,9 +205,28 @@ func main() {
 	command.Stdout = os.Stdout
 	command.Stderr = os.Stderr
 
-	res, err := manager.Exec(*name, command, execOpts)
+	res, err := manager.Exec(lockNames.names[0], command, execOpts)
 	if err != nil {
 		log.Fatal(err)
 	}
 	os.Exit(res)
 }
+
+// listLocks prints the locks held whose name starts with prefix
+func listLocks(client glock.Client, prefix string) {
+	lister, ok := client.(glock.ListClient)
+	if !ok {
+		log.Fatalf("Driver '%s' cannot list locks", *driver)
+	}
+	infos, err := lister.List(prefix)
+	if err != nil {
+		log.Fatalf("Cannot list locks: %s", err)
+	}
+	for _, info := range infos {
+		if info.Shared {
+			fmt.Printf("%s\tshared by %s\t%v\n", info.Name, strings.Join(info.Holders, ","), info.TTL)
+			continue
+		}
+		fmt.Printf("%s\t%s\t%v\t%s\n", info.Name, info.Owner, info.TTL, info.Data)
+	}
+}
@@ -0,0 +1,224 @@
+package glocktest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Check checks that the history of every lock is linearizable: that its
+// operations can be ordered, each one taking effect at some point between its
+// call and its return, so that the outcome of every operation is the one of
+// a lock held by at most one client at a time. Besides two overlapping
+// owners, it catches e.g. locks taken over before their TTL expired,
+// refreshes of locks not held and tokens not increasing.
+//
+// Stores are allowed to expire locks after their TTL, not before. The
+// outcome of pending operations is unknown, so they might or might not have
+// taken effect. The histories of the locks are checked one by one, by
+// searching all the orders of their concurrent operations: the search grows
+// exponentially with the number of operations running at the same time.
+//
+// Check returns a *Violation if the history is not linearizable.
+func Check(ops []Op) error {
+	locks := make(map[string][]*Op)
+	for i := range ops {
+		op := &ops[i]
+		locks[op.Lock] = append(locks[op.Lock], op)
+	}
+	var names []string
+	for name := range locks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if v := checkLock(locks[name]); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// Violation is the error returned by Check when a history is not
+// linearizable
+type Violation struct {
+	// Lock is the name of the lock
+	Lock string
+	// Linearized is the longest sequence of operations that could be ordered
+	Linearized []Op
+	// Next are the operations that could be ordered next, but whose outcome
+	// contradicts the ones before
+	Next []Op
+}
+
+func (v *Violation) Error() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "History of lock '%s' is not linearizable after %d operations", v.Lock, len(v.Linearized))
+	if n := len(v.Linearized); n > 0 {
+		fmt.Fprintf(&b, "\nlast operation: %s", &v.Linearized[n-1])
+	}
+	for i := range v.Next {
+		fmt.Fprintf(&b, "\ncannot follow: %s", &v.Next[i])
+	}
+	return b.String()
+}
+
+// model is the state of a lock held by at most one client
+type model struct {
+	owner string
+	// expire is the earliest time the lock of the owner can expire: it might
+	// be held after that, until another client acquires it
+	expire time.Time
+	token  uint64
+	// now is the earliest time the next operation can take effect
+	now time.Time
+}
+
+// step returns the state after op took effect, or false if the outcome of op
+// is impossible in state m. Pending operations take effect as if they
+// succeeded.
+func (m model) step(op *Op) (model, bool) {
+	// every operation takes effect as early as possible, which leaves the
+	// most room to the ones after
+	at := op.Call
+	if at.Before(m.now) {
+		at = m.now
+	}
+	pending := op.Pending()
+	ok := op.Err == nil || pending
+	// expired takes effect once the lock of the owner can have expired
+	expired := func() {
+		if m.owner != "" && at.Before(m.expire) {
+			at = m.expire
+		}
+	}
+
+	next := m
+	switch {
+	case op.Type == OpAcquire && ok:
+		expired()
+		if !pending && op.Token <= m.token {
+			return m, false
+		}
+		next.owner, next.expire = op.Client, at.Add(op.TTL)
+		if !pending {
+			next.token = op.Token
+		}
+	case op.Type == OpAcquire:
+		if m.owner == "" {
+			return m, false
+		}
+	case op.Type == OpRefresh && ok:
+		if m.owner != op.Client {
+			return m, false
+		}
+		next.expire = at.Add(op.TTL)
+	case op.Type == OpRelease && ok:
+		if m.owner != op.Client {
+			return m, false
+		}
+		next.owner = ""
+	default:
+		// refreshing or releasing a lock not owned: if the client held it,
+		// it must have expired
+		if m.owner == op.Client {
+			expired()
+			next.owner = ""
+		}
+	}
+	if !pending && at.After(op.Return) {
+		return m, false
+	}
+	next.now = at
+	return next, true
+}
+
+// search looks for a linearization of the operations on a lock, with the
+// algorithm of Wing and Gong: it tries every operation which can take effect
+// next, and backtracks when stuck.
+type search struct {
+	ops  []*Op
+	done []bool
+	// order are the operations linearized so far
+	order []int
+	seen  map[string]bool
+	// best is the longest linearization found, and next the operations
+	// which could not follow it
+	best []int
+	next []int
+}
+
+func checkLock(ops []*Op) *Violation {
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Call.Before(ops[j].Call) })
+	s := &search{ops: ops, done: make([]bool, len(ops)), seen: make(map[string]bool)}
+	if s.run(model{}) {
+		return nil
+	}
+	v := &Violation{Lock: ops[0].Lock}
+	for _, i := range s.best {
+		v.Linearized = append(v.Linearized, *ops[i])
+	}
+	for _, i := range s.next {
+		v.Next = append(v.Next, *ops[i])
+	}
+	return v
+}
+
+// key identifies the operations done and the state of the lock
+func (s *search) key(m model) string {
+	b := make([]byte, (len(s.done)+7)/8)
+	for i, done := range s.done {
+		if done {
+			b[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return fmt.Sprintf("%x/%s/%d/%d/%d", b, m.owner, m.expire.UnixNano(), m.token, m.now.UnixNano())
+}
+
+func (s *search) run(m model) bool {
+	// operations which returned must have taken effect, the pending ones
+	// might not: the candidates are the ones called before the first
+	// operation left returned
+	var first *time.Time
+	for i, op := range s.ops {
+		if !s.done[i] && !op.Pending() && (first == nil || op.Return.Before(*first)) {
+			first = &op.Return
+		}
+	}
+	if first == nil {
+		return true
+	}
+	key := s.key(m)
+	if s.seen[key] {
+		return false
+	}
+	s.seen[key] = true
+
+	var stuck []int
+	for i, op := range s.ops {
+		if op.Call.After(*first) {
+			break
+		}
+		if s.done[i] {
+			continue
+		}
+		next, ok := m.step(op)
+		if !ok {
+			stuck = append(stuck, i)
+			continue
+		}
+		s.done[i] = true
+		s.order = append(s.order, i)
+		if s.run(next) {
+			return true
+		}
+		s.order = s.order[:len(s.order)-1]
+		s.done[i] = false
+	}
+	if len(s.order) >= len(s.best) {
+		s.best = append(s.best[:0], s.order...)
+		s.next = stuck
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package glocktest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+var base = time.Now()
+
+// op returns an operation called and returned at the given milliseconds
+func op(typ OpType, client string, call, ret int, ttl int, err error, token uint64) Op {
+	return Op{
+		Type:   typ,
+		Lock:   "lock",
+		Client: client,
+		TTL:    time.Duration(ttl) * time.Millisecond,
+		Call:   base.Add(time.Duration(call) * time.Millisecond),
+		Return: base.Add(time.Duration(ret) * time.Millisecond),
+		Err:    err,
+		Token:  token,
+	}
+}
+
+func TestCheck(t *testing.T) {
+	errNetwork := errors.New("connection reset")
+	cases := []struct {
+		name string
+		ops  []Op
+		ok   bool
+	}{
+		{"sequential", []Op{
+			op(OpAcquire, "a", 0, 1, 100, nil, 1),
+			op(OpAcquire, "b", 2, 3, 100, glock.ErrLockHeldByOtherClient, 0),
+			op(OpRefresh, "a", 4, 5, 100, nil, 0),
+			op(OpRelease, "a", 6, 7, 0, nil, 0),
+			op(OpAcquire, "b", 8, 9, 100, nil, 2),
+		}, true},
+		{"overlapping owners", []Op{
+			op(OpAcquire, "a", 0, 1, 100, nil, 1),
+			op(OpAcquire, "b", 10, 11, 100, nil, 2),
+		}, false},
+		{"taken over after expiration", []Op{
+			op(OpAcquire, "a", 0, 1, 10, nil, 1),
+			op(OpAcquire, "b", 12, 13, 100, nil, 2),
+			op(OpRefresh, "a", 14, 15, 10, glock.ErrLockNotOwned, 0),
+		}, true},
+		{"refreshed lock taken over", []Op{
+			op(OpAcquire, "a", 0, 1, 10, nil, 1),
+			op(OpRefresh, "a", 8, 9, 10, nil, 0),
+			op(OpAcquire, "b", 12, 13, 100, nil, 2),
+		}, false},
+		{"expired late", []Op{
+			op(OpAcquire, "a", 0, 1, 10, nil, 1),
+			op(OpAcquire, "b", 20, 21, 100, glock.ErrLockHeldByOtherClient, 0),
+			op(OpRefresh, "a", 22, 23, 10, nil, 0),
+		}, true},
+		{"concurrent", []Op{
+			op(OpAcquire, "a", 0, 10, 100, nil, 1),
+			op(OpAcquire, "b", 1, 2, 100, glock.ErrLockHeldByOtherClient, 0),
+			op(OpRelease, "a", 11, 20, 0, nil, 0),
+			op(OpAcquire, "b", 12, 13, 100, nil, 2),
+		}, true},
+		{"held while free", []Op{
+			op(OpAcquire, "a", 0, 1, 100, nil, 1),
+			op(OpRelease, "a", 2, 3, 0, nil, 0),
+			op(OpAcquire, "b", 4, 5, 100, glock.ErrLockHeldByOtherClient, 0),
+		}, false},
+		{"refreshed by another client", []Op{
+			op(OpAcquire, "a", 0, 1, 100, nil, 1),
+			op(OpRefresh, "b", 2, 3, 100, nil, 0),
+		}, false},
+		{"tokens not increasing", []Op{
+			op(OpAcquire, "a", 0, 1, 100, nil, 2),
+			op(OpRelease, "a", 2, 3, 0, nil, 0),
+			op(OpAcquire, "b", 4, 5, 100, nil, 1),
+		}, false},
+		{"pending release", []Op{
+			op(OpAcquire, "a", 0, 1, 100, nil, 1),
+			op(OpRelease, "a", 2, 3, 0, errNetwork, 0),
+			op(OpAcquire, "b", 4, 5, 100, nil, 2),
+		}, true},
+		{"pending acquisition", []Op{
+			op(OpAcquire, "a", 0, 1, 100, errNetwork, 0),
+			op(OpAcquire, "b", 2, 3, 100, glock.ErrLockHeldByOtherClient, 0),
+			op(OpRelease, "a", 4, 5, 0, nil, 0),
+		}, true},
+	}
+	for _, c := range cases {
+		err := Check(c.ops)
+		if c.ok && err != nil {
+			t.Errorf("%s: expected a linearizable history, got: %s", c.name, err)
+		}
+		if _, ok := err.(*Violation); !c.ok && !ok {
+			t.Errorf("%s: expected a violation, got '%v'", c.name, err)
+		}
+	}
+}
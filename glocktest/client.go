@@ -0,0 +1,161 @@
+package glocktest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+// TestClient checks the client ids, and that Close and Reconnect are
+// idempotent.
+func TestClient(t *testing.T, newClient NewClientFunc) {
+	c1 := newClient(t)
+	c2 := newClient(t)
+
+	if c1.ID() == c2.ID() {
+		t.Errorf("Both client have the same id: %s == %s", c1.ID(), c2.ID())
+	}
+
+	c1.SetID("myclient")
+	id := c1.ID()
+	if id != "myclient" {
+		t.Errorf("SetID did not set ID correctly: 'myclient' expected, got %s", id)
+	}
+
+	// close should be idempotent, as well as Reconnect
+	c1.Close()
+	c1.Close()
+	err := c1.Reconnect()
+	if err != nil {
+		t.Fatalf("Reconnect error: %s", err)
+	}
+	err = c1.Reconnect()
+	if err != nil {
+		t.Fatalf("Reconnect error: %s", err)
+	}
+
+	c3 := c1.Clone()
+	if c1.ID() != c3.ID() {
+		t.Errorf("Clone should have copied client ids '%s' != '%s'", c1.ID(), c3.ID())
+	}
+}
+
+// TestReconnect checks that clients keep working after reconnecting. Stores
+// which tie locks to the connection, e.g. zookeeper sessions, lose them on
+// reconnection: either way the client and the store must agree on who owns
+// the lock.
+func TestReconnect(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	c1, c2 := newClient(t), newClient(t)
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+
+	if err := lock1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	if err := c1.Reconnect(); err != nil {
+		t.Fatalf("Reconnect error: %s", err)
+	}
+	info, err := lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	switch err = lock1.Refresh(); err {
+	case nil:
+		if !info.Acquired || info.Owner != c1.ID() {
+			t.Errorf("Lock refreshed after reconnecting, but info: %+v -- expected Owner: %s", info, c1.ID())
+		}
+		if err = lock1.Release(); err != nil {
+			t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+		}
+	case glock.ErrLockNotOwned:
+		if info.Acquired {
+			t.Errorf("Lock lost after reconnecting, but info: %+v -- expected Acquired: false", info)
+		}
+	default:
+		t.Fatalf("Error while refreshing lock after reconnecting: %s", err)
+	}
+
+	// Locks are acquired and released as usual after reconnecting
+	if err = lock1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s' after reconnecting: %s", lockName, err)
+	}
+	if err = lock2.Acquire(ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", glock.ErrLockHeldByOtherClient, err)
+	}
+	if err = lock1.Release(); err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+
+	// A reconnection aborted by the context can be retried
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = c1.ReconnectContext(ctx); err != context.Canceled {
+		t.Errorf("Reconnect with a cancelled context should return '%s', got '%s'", context.Canceled, err)
+	}
+	if err = c1.Reconnect(); err != nil {
+		t.Fatalf("Reconnect error: %s", err)
+	}
+	if err = lock1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s' after reconnecting: %s", lockName, err)
+	}
+	if err = lock1.Release(); err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+}
+
+// TestClone checks that clones act on behalf of the same client, as lease
+// keepers do, but do not share anything else with it.
+func TestClone(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	c1, c2 := newClient(t), newClient(t)
+	lock1 := c1.NewLock(lockName)
+	lock1.SetData("client1")
+	if err := lock1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	defer lock1.Release()
+
+	clone := c1.Clone()
+	if err := clone.Reconnect(); err != nil {
+		t.Fatalf("Reconnect error: %s", err)
+	}
+	if clone.ID() != c1.ID() {
+		t.Fatalf("Clone should have copied client ids '%s' != '%s'", c1.ID(), clone.ID())
+	}
+
+	// The clone owns the locks acquired by the client
+	cloned := clone.NewLock(lockName)
+	cloned.SetData("client1")
+	if err := cloned.RefreshTTL(ttl * 2); err != nil {
+		t.Fatalf("Cannot refresh the lock of the client from its clone: %s", err)
+	}
+	info, err := c2.NewLock(lockName).Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if !info.Acquired || info.Owner != c1.ID() || info.TTL <= ttl {
+		t.Errorf("info: %+v -- expected Owner: %s, TTL > %v", info, c1.ID(), ttl)
+	}
+
+	// Changing or closing the clone does not affect the client
+	clone.SetID("clone")
+	if c1.ID() == "clone" {
+		t.Errorf("SetID on the clone changed the id of the client")
+	}
+	if err = clone.NewLock(lockName).Release(); err != glock.ErrLockNotOwned {
+		t.Errorf("Releasing a lock held by another client should return '%s', got: '%s'", glock.ErrLockNotOwned, err)
+	}
+	clone.Close()
+	if err = lock1.Refresh(); err != nil {
+		t.Fatalf("Cannot refresh lock after closing the clone: %s", err)
+	}
+	if info, err = lock1.Info(); err != nil {
+		t.Fatalf("Error while getting lock info after closing the clone: %s", err)
+	}
+	if !info.Acquired || info.Owner != c1.ID() {
+		t.Errorf("info: %+v -- expected Owner: %s", info, c1.ID())
+	}
+}
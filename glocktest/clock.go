@@ -0,0 +1,147 @@
+package glocktest
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+// FakeClock is a glock.Clock whose time only passes when advanced by hand,
+// so that tests of TTLs and timeouts neither sleep nor depend on the load of
+// the machine. It is safe for concurrent use.
+//
+// Code under test usually waits on the clock from other goroutines: use
+// BlockUntil before Advance, so that the clock is advanced once they wait.
+type FakeClock struct {
+	mtx    sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	c     chan time.Time
+	f     func()
+}
+
+// NewFakeClock returns a fake clock set at the given time
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mtx)
+	return c
+}
+
+// Now returns the time of the clock
+func (c *FakeClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+// NewTimer returns a timer firing once the clock is advanced by d
+func (c *FakeClock) NewTimer(d time.Duration) glock.Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// AfterFunc calls f in its own goroutine once the clock is advanced by d
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) glock.Timer {
+	t := &fakeTimer{clock: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers due meanwhile in
+// order, each one at its own time.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	end := c.now.Add(d)
+	for {
+		var next *fakeTimer
+		for _, t := range c.timers {
+			if !t.when.After(end) && (next == nil || t.when.Before(next.when)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+		if next.when.After(c.now) {
+			c.now = next.when
+		}
+		c.fire(next)
+	}
+	c.now = end
+}
+
+// Timers returns the number of timers which did not fire yet
+func (c *FakeClock) Timers() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil waits until at least n timers did not fire yet, e.g. until the
+// goroutines under test wait on the clock.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// fire removes t from the pending timers, and fires it. c.mtx must be held.
+func (c *FakeClock) fire(t *fakeTimer) {
+	c.remove(t)
+	if t.f != nil {
+		go t.f()
+		return
+	}
+	select {
+	case t.c <- c.now:
+	default:
+	}
+}
+
+// remove removes t from the pending timers, and returns false if it was not
+// pending. c.mtx must be held.
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, p := range c.timers {
+		if p == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mtx.Lock()
+	defer t.clock.mtx.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	pending := c.remove(t)
+	t.when = c.now.Add(d)
+	if d <= 0 {
+		c.fire(t)
+		return pending
+	}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return pending
+}
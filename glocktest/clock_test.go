@@ -0,0 +1,74 @@
+package glocktest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	t1 := clock.NewTimer(time.Minute)
+	t2 := clock.NewTimer(time.Hour)
+	fired := make(chan time.Time, 1)
+	clock.AfterFunc(30*time.Second, func() { fired <- clock.Now() })
+	if n := clock.Timers(); n != 3 {
+		t.Errorf("Expected 3 timers, got %d", n)
+	}
+
+	// timers fire in order, each one at its own time
+	clock.Advance(2 * time.Minute)
+	if now := clock.Now(); !now.Equal(start.Add(2 * time.Minute)) {
+		t.Errorf("Expected time %s, got %s", start.Add(2*time.Minute), now)
+	}
+	select {
+	case at := <-t1.C():
+		if !at.Equal(start.Add(time.Minute)) {
+			t.Errorf("Timer fired at %s, expected %s", at, start.Add(time.Minute))
+		}
+	default:
+		t.Errorf("Timer did not fire")
+	}
+	if at := <-fired; at.Before(start.Add(30 * time.Second)) {
+		t.Errorf("Function called at %s, before %s", at, start.Add(30*time.Second))
+	}
+	select {
+	case <-t2.C():
+		t.Errorf("Timer fired too early")
+	default:
+	}
+
+	// stopped timers do not fire, reset ones fire from the time of the reset
+	if !t2.Stop() {
+		t.Errorf("Stop should return true for a pending timer")
+	}
+	if t2.Stop() {
+		t.Errorf("Stop should return false for a stopped timer")
+	}
+	if t1.Reset(time.Minute) {
+		t.Errorf("Reset should return false for a fired timer")
+	}
+	clock.Advance(time.Hour)
+	select {
+	case <-t2.C():
+		t.Errorf("Stopped timer fired")
+	case at := <-t1.C():
+		if !at.Equal(start.Add(3 * time.Minute)) {
+			t.Errorf("Timer fired at %s, expected %s", at, start.Add(3*time.Minute))
+		}
+	}
+	if n := clock.Timers(); n != 0 {
+		t.Errorf("Expected no timers, got %d", n)
+	}
+
+	// BlockUntil waits for other goroutines to wait on the clock
+	done := make(chan bool)
+	go func() {
+		<-clock.NewTimer(time.Second).C()
+		done <- true
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	<-done
+}
@@ -0,0 +1,67 @@
+// Package glocktest provides the conformance suites of glock drivers.
+//
+// Every glock.Client implementation, in this repository or not, can check
+// that it behaves like the others by running the suites from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		glocktest.Run(t, func(t *testing.T) glock.Client {
+//			c, err := NewMyClient(opts)
+//			if err != nil {
+//				t.Fatalf("Cannot create client: %s", err)
+//			}
+//			return c
+//		}, time.Millisecond)
+//	}
+//
+// The suites use the lock named "glocktest", which must not be used by
+// anybody else while they run.
+//
+// Besides the suites, History records the operations of clients on locks,
+// and Check verifies that they are linearizable, i.e. that no lock was ever
+// held by two clients at once. FakeClock is a glock.Clock advanced by hand,
+// for tests of TTLs and timeouts which do not sleep.
+package glocktest
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+// ttlLength is the TTL of the locks acquired by the suites, in scale units
+const ttlLength = 30
+
+const lockName = "glocktest"
+
+// NewClientFunc returns a new client connected to the store under test.
+// Every call must return a client with a different ID.
+type NewClientFunc func(t *testing.T) glock.Client
+
+// Run runs all the suites against the clients returned by newClient, each
+// one as a subtest. The suites for RWClient and SemaphoreClient are skipped
+// if the clients do not implement them.
+//
+// Scale is the time unit of the suites: locks are acquired for 30 times
+// scale, and expirations are checked within a few scale units. It must be
+// larger than the TTL resolution of the store, e.g. time.Millisecond for
+// redis and time.Second for stores with TTLs in seconds.
+func Run(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	t.Run("Client", func(t *testing.T) { TestClient(t, newClient) })
+	t.Run("Reconnect", func(t *testing.T) { TestReconnect(t, newClient, scale) })
+	t.Run("Clone", func(t *testing.T) { TestClone(t, newClient, scale) })
+	t.Run("Lock", func(t *testing.T) { TestLock(t, newClient, scale) })
+	t.Run("LockContext", func(t *testing.T) { TestLockContext(t, newClient, scale) })
+	t.Run("Token", func(t *testing.T) { TestToken(t, newClient, scale) })
+	t.Run("Data", func(t *testing.T) { TestData(t, newClient, scale) })
+	t.Run("Expiry", func(t *testing.T) { TestExpiry(t, newClient, scale) })
+	t.Run("Concurrency", func(t *testing.T) { TestConcurrency(t, newClient, scale) })
+	t.Run("RWLock", func(t *testing.T) { TestRWLock(t, newClient, scale) })
+	t.Run("Semaphore", func(t *testing.T) { TestSemaphore(t, newClient, scale) })
+	t.Run("Linearizability", func(t *testing.T) { TestLinearizability(t, newClient, scale) })
+}
+
+// sleepUntil sleeps until the given time, if not passed already
+func sleepUntil(deadline time.Time) {
+	time.Sleep(time.Until(deadline))
+}
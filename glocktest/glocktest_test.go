@@ -0,0 +1,20 @@
+package glocktest
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+var clients int64
+
+func memoryClient(t *testing.T) glock.Client {
+	return glock.NewMemoryClient(fmt.Sprintf("glocktest-%d", atomic.AddInt64(&clients, 1)))
+}
+
+func TestMemory(t *testing.T) {
+	Run(t, memoryClient, time.Millisecond)
+}
@@ -0,0 +1,249 @@
+package glocktest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+// OpType is the type of the operations of a history
+type OpType int
+
+const (
+	// OpAcquire is an acquisition
+	OpAcquire OpType = iota
+	// OpRefresh is a refresh, with or without a new TTL
+	OpRefresh
+	// OpRelease is a release
+	OpRelease
+)
+
+func (t OpType) String() string {
+	switch t {
+	case OpAcquire:
+		return "acquire"
+	case OpRefresh:
+		return "refresh"
+	case OpRelease:
+		return "release"
+	default:
+		return "unknown"
+	}
+}
+
+// Op is an operation on a lock, as seen by the client which made it
+type Op struct {
+	Type OpType
+	// Lock is the name of the lock
+	Lock string
+	// Client is the ID of the client
+	Client string
+	// TTL is the ttl of the lock after acquisitions and refreshes
+	TTL time.Duration
+	// Call and Return are when the operation was called and when it returned
+	Call   time.Time
+	Return time.Time
+	// Err is the error returned by the operation
+	Err error
+	// Token is the fencing token obtained by a successful acquisition
+	Token uint64
+}
+
+// Pending tells if the outcome of the operation is unknown: the operation
+// failed with an error other than the ones of the lock, e.g. a network error
+// or a timeout, so it might or might not have taken effect.
+func (op *Op) Pending() bool {
+	switch op.Err {
+	case nil, glock.ErrLockHeldByOtherClient, glock.ErrLockNotOwned, glock.ErrLockStolen:
+		return false
+	default:
+		return true
+	}
+}
+
+func (op *Op) String() string {
+	res := "ok"
+	if op.Err != nil {
+		res = op.Err.Error()
+	}
+	if op.Type == OpAcquire && op.Err == nil {
+		res = fmt.Sprintf("token %d", op.Token)
+	}
+	return fmt.Sprintf("%s %s '%s' (ttl %v) from %s to %s: %s", op.Client, op.Type, op.Lock, op.TTL,
+		op.Call.Format("15:04:05.000000"), op.Return.Format("15:04:05.000000"), res)
+}
+
+// History records the operations of concurrent clients on locks, so that
+// they can be checked with Check. It is safe for concurrent use.
+type History struct {
+	mtx sync.Mutex
+	ops []Op
+}
+
+// Record adds an operation to the history
+func (h *History) Record(op Op) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.ops = append(h.ops, op)
+}
+
+// Ops returns the operations recorded so far
+func (h *History) Ops() []Op {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return append([]Op(nil), h.ops...)
+}
+
+// Check checks the operations recorded so far, see Check
+func (h *History) Check() error {
+	return Check(h.Ops())
+}
+
+// Client returns a client whose locks, and the ones of its clones, record
+// their operations in h. The optional interfaces of c, e.g. RWClient, are not
+// implemented by the client returned.
+func (h *History) Client(c glock.Client) glock.Client {
+	return &recordingClient{c, h}
+}
+
+// Lock returns a lock of the given client which records its operations in h
+func (h *History) Lock(c glock.Client, name string) glock.Lock {
+	return &recordingLock{Lock: c.NewLock(name), name: name, client: c, history: h}
+}
+
+type recordingClient struct {
+	glock.Client
+	history *History
+}
+
+func (c *recordingClient) NewLock(name string) glock.Lock {
+	return c.history.Lock(c.Client, name)
+}
+
+func (c *recordingClient) Clone() glock.Client {
+	return &recordingClient{c.Client.Clone(), c.history}
+}
+
+type recordingLock struct {
+	glock.Lock
+	name    string
+	client  glock.Client
+	history *History
+	ttl     time.Duration
+}
+
+func (l *recordingLock) record(typ OpType, f func() error) error {
+	op := Op{Type: typ, Lock: l.name, Client: l.client.ID(), TTL: l.ttl, Call: time.Now()}
+	op.Err = f()
+	op.Return = time.Now()
+	if typ == OpAcquire && op.Err == nil {
+		op.Token = l.Lock.Token()
+	}
+	l.history.Record(op)
+	return op.Err
+}
+
+func (l *recordingLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+func (l *recordingLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	l.ttl = ttl
+	return l.record(OpAcquire, func() error { return l.Lock.AcquireContext(ctx, ttl) })
+}
+
+func (l *recordingLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+func (l *recordingLock) RefreshContext(ctx context.Context) error {
+	return l.record(OpRefresh, func() error { return l.Lock.RefreshContext(ctx) })
+}
+
+func (l *recordingLock) RefreshTTL(ttl time.Duration) error {
+	return l.RefreshTTLContext(context.Background(), ttl)
+}
+
+func (l *recordingLock) RefreshTTLContext(ctx context.Context, ttl time.Duration) error {
+	l.ttl = ttl
+	return l.record(OpRefresh, func() error { return l.Lock.RefreshTTLContext(ctx, ttl) })
+}
+
+func (l *recordingLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+func (l *recordingLock) ReleaseContext(ctx context.Context) error {
+	return l.record(OpRelease, func() error { return l.Lock.ReleaseContext(ctx) })
+}
+
+// Contend runs the given number of clients for duration d. Each one
+// acquires, refreshes and releases the lock named "glocktest" at random,
+// sometimes letting it expire. It returns their history.
+func Contend(t *testing.T, newClient NewClientFunc, scale time.Duration, clients int, d time.Duration) *History {
+	ttl := time.Duration(ttlLength) * scale
+	ttls := []time.Duration{ttl / 3, ttl / 2, ttl}
+	deadline := time.Now().Add(d)
+	h := &History{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		lock := h.Lock(newClient(t), lockName)
+		r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			held := false
+			for time.Now().Before(deadline) {
+				switch {
+				case !held:
+					held = lock.Acquire(ttls[r.Intn(len(ttls))]) == nil
+				case r.Intn(3) == 0:
+					lock.Release()
+					held = false
+				case r.Intn(2) == 0:
+					held = lock.RefreshTTL(ttls[r.Intn(len(ttls))]) == nil
+				default:
+					held = lock.Refresh() == nil
+				}
+				if r.Intn(8) == 0 {
+					// long enough for the shortest ttl to expire
+					time.Sleep(ttl / 2)
+				} else {
+					time.Sleep(time.Duration(r.Int63n(int64(scale))))
+				}
+			}
+			if held {
+				lock.Release()
+			}
+		}()
+	}
+	wg.Wait()
+	return h
+}
+
+// TestLinearizability runs concurrent clients with Contend, and checks their
+// history with Check.
+func TestLinearizability(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	h := Contend(t, newClient, scale, 8, time.Duration(ttlLength)*scale*2)
+	acquired := 0
+	for _, op := range h.Ops() {
+		if op.Type == OpAcquire && op.Err == nil {
+			acquired++
+		}
+		if op.Pending() {
+			t.Errorf("Operation failed: %s", &op)
+		}
+	}
+	if acquired < 2 {
+		t.Errorf("Expected the lock to be acquired by several clients, got %d acquisitions", acquired)
+	}
+	if err := h.Check(); err != nil {
+		t.Error(err)
+	}
+}
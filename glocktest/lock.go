@@ -0,0 +1,511 @@
+package glocktest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+// TestLock checks acquisitions, refreshes, releases, Info and expirations.
+func TestLock(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	c1 := newClient(t)
+	c2 := newClient(t)
+
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+
+	lock1.SetData("client1")
+	lock2.SetData("client2")
+
+	err := lock1.Acquire(time.Duration(ttlLength) * scale)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+
+	// Re-acquire the same lock twice is an error
+	err = lock1.Acquire(time.Duration(ttlLength) * scale)
+	if err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got %s", glock.ErrLockHeldByOtherClient, err)
+	}
+
+	// Other client should see the lock as acquired
+	err = lock2.Acquire(time.Duration(ttlLength) * scale)
+	if err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got %s", glock.ErrLockHeldByOtherClient, err)
+	}
+
+	// client 2 cannot release the lock as it's held by client 1
+	err = lock2.Release()
+	if err != glock.ErrLockNotOwned {
+		t.Fatalf("Releasing a lock held by another client should return '%s', got: '%s'", glock.ErrLockNotOwned, err)
+	}
+
+	// Refreshing a lock held by another client
+	err = lock2.Refresh()
+	if err != glock.ErrLockNotOwned {
+		t.Fatalf("Refreshing a lock not held should return '%s', got: '%s'", glock.ErrLockNotOwned, err)
+	}
+
+	// Refreshing a lock acquired should succeed
+	err = lock1.Refresh()
+	if err != nil {
+		t.Fatalf("Error while refreshing lock: '%s'", err)
+	}
+
+	// Refreshing a lock should check for the TTL
+	err = lock1.RefreshTTL(scale / 2)
+	if err != glock.ErrInvalidTTL {
+		t.Fatalf("Expected error '%s' with TTL %v, got '%s'", glock.ErrInvalidTTL, scale/2, err)
+	}
+
+	info1, err := lock1.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+
+	info2, err := lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+
+	if info1.Name != lockName {
+		t.Errorf("LockInfo Name should be equal to the lock name '%s', got '%s'", lockName, info1.Name)
+	}
+	if info1.Name != info2.Name {
+		t.Errorf("Info should return the same name for both clients: '%s' != '%s'",
+			info1.Name, info2.Name)
+	}
+	if info1.TTL > time.Duration(ttlLength)*scale || info1.TTL <= 0 {
+		t.Errorf("Invalid TTL returned by lock.Info() : %v", info1.TTL)
+	}
+	if info1.Acquired != true {
+		t.Errorf("Lock is held by client1, info.Acquired should be true, got %v", info1.Acquired)
+	}
+	if info2.Acquired != true {
+		t.Errorf("Lock is held by client1, info.Acquired should be true, got %v", info2.Acquired)
+	}
+
+	if info1.Data != "client1" {
+		t.Errorf("Expected data 'client1', got '%s'", info1.Data)
+	}
+
+	if info2.Data != info1.Data {
+		t.Errorf("lock.Info() should return the data set by the client who acquired the lock ('client1'), got '%s'", info2.Data)
+	}
+
+	if info1.Owner != c1.ID() {
+		t.Errorf("info.Owner should be equal to the client ID of the client owning the lock ('%s'), got '%s'", c1.ID(), info1.Owner)
+	}
+	if info2.Owner != c1.ID() {
+		t.Errorf("info.Owner should be equal to the client ID of the client owning the lock ('%s'), got '%s'", c1.ID(), info2.Owner)
+	}
+
+	// refreshing should update TTL and Data
+	lock1.SetData("newdata")
+	err = lock1.RefreshTTL(info1.TTL * 2)
+	if err != nil {
+		t.Fatalf("Error in RefreshTTL: '%s'", err)
+	}
+	info3, err := lock1.Info()
+	if err != nil {
+		t.Fatalf("Error in Info: '%s'", err)
+	}
+	if info3.TTL <= info1.TTL {
+		t.Errorf("Lock not refreshed? %v <= %v should be closer to %v",
+			info3.TTL, info1.TTL, info1.TTL*2)
+	}
+	if info3.Data != "newdata" {
+		t.Errorf("Refresh did not refresh data, expected 'newdata' got '%s'", info3.Data)
+	}
+
+	err = lock1.Release()
+	if err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+	// Acquiring the lock with an invalid TTL should result in an error
+	err = lock1.Acquire(scale / 2)
+	if err != glock.ErrInvalidTTL {
+		t.Fatalf("Expected error '%s' with TTL %v, got '%s'", glock.ErrInvalidTTL, scale/2, err)
+	}
+
+	// Releasing a lock twice is an error
+	err = lock1.Release()
+	if err != glock.ErrLockNotOwned {
+		t.Fatalf("Releasing a lock twice should return '%s', got: '%s'", glock.ErrLockNotOwned, err)
+	}
+
+	// Refreshing a lock not held is an error
+	err = lock1.Refresh()
+	if err != glock.ErrLockNotOwned {
+		t.Fatalf("Refreshing a lock not held should return '%s', got: '%s'", glock.ErrLockNotOwned, err)
+	}
+
+	// Getting info on a non-existing/already release lock should not return an error
+	info1, err = lock1.Info()
+	if err != nil {
+		t.Fatalf("Error in Info: '%s'", err)
+	}
+	if info1.Acquired == true {
+		t.Error("Lock is not acquired but info.Acquired = true")
+	}
+
+	// Finally, locks should expire.
+	lock1.Acquire(scale)
+	time.Sleep(scale * 2)
+	info1, err = lock1.Info()
+	if err != nil {
+		t.Fatalf("Error in Info: '%s'", err)
+	}
+	if info1.Acquired != false {
+		t.Fatalf("Lock should be expired but info.Acquired = true")
+	}
+	err = lock1.Refresh()
+	if err != glock.ErrLockNotOwned {
+		t.Fatalf("Lock should be expired but refresh was succesful: %s", err)
+	}
+	err = lock1.Release()
+	if err != glock.ErrLockNotOwned {
+		t.Fatalf("Lock should be expired but release was succesful: %s", err)
+	}
+}
+
+// TestLockContext checks that calls with a done context are aborted with the
+// context error.
+func TestLockContext(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	c1 := newClient(t)
+	c2 := newClient(t)
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A done context must abort the operation with the context error
+	err := lock1.AcquireContext(ctx, time.Duration(ttlLength)*scale)
+	if err != context.Canceled {
+		t.Fatalf("Acquire with a cancelled context should return '%s', got '%s'", context.Canceled, err)
+	}
+	_, err = lock1.InfoContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("Info with a cancelled context should return '%s', got '%s'", context.Canceled, err)
+	}
+
+	err = lock1.AcquireContext(context.Background(), time.Duration(ttlLength)*scale)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	err = lock1.RefreshContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("Refresh with a cancelled context should return '%s', got '%s'", context.Canceled, err)
+	}
+	err = lock1.ReleaseContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("Release with a cancelled context should return '%s', got '%s'", context.Canceled, err)
+	}
+
+	// The client must still be usable after an aborted call
+	err = lock2.AcquireContext(context.Background(), time.Duration(ttlLength)*scale)
+	if err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got %s", glock.ErrLockHeldByOtherClient, err)
+	}
+	err = lock1.ReleaseContext(context.Background())
+	if err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+}
+
+// TestToken checks that fencing tokens increase at every acquisition.
+func TestToken(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	c1 := newClient(t)
+	c2 := newClient(t)
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+
+	if lock1.Token() != 0 {
+		t.Errorf("Token should be 0 before acquisition, got %d", lock1.Token())
+	}
+
+	err := lock1.Acquire(time.Duration(ttlLength) * scale)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	token1 := lock1.Token()
+	if token1 == 0 {
+		t.Fatalf("Acquire should return a fencing token")
+	}
+
+	// A failed acquisition does not hand out a token
+	err = lock2.Acquire(time.Duration(ttlLength) * scale)
+	if err != glock.ErrLockHeldByOtherClient {
+		t.Fatalf("Expected error '%s', got %s", glock.ErrLockHeldByOtherClient, err)
+	}
+	if lock2.Token() != 0 {
+		t.Errorf("Token should be 0 after a failed acquisition, got %d", lock2.Token())
+	}
+
+	info, err := lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Token != token1 {
+		t.Errorf("info.Token should be the owner token %d, got %d", token1, info.Token)
+	}
+
+	// Refreshing keeps the token
+	err = lock1.Refresh()
+	if err != nil {
+		t.Fatalf("Error while refreshing lock: '%s'", err)
+	}
+	if lock1.Token() != token1 {
+		t.Errorf("Refresh changed the token: %d != %d", lock1.Token(), token1)
+	}
+
+	err = lock1.Release()
+	if err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+	info, err = lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Token != 0 {
+		t.Errorf("info.Token should be 0 for a lock not acquired, got %d", info.Token)
+	}
+
+	// Tokens increase across owners, also after expiration
+	err = lock2.Acquire(scale * 2)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	token2 := lock2.Token()
+	if token2 <= token1 {
+		t.Errorf("Tokens should increase: %d <= %d", token2, token1)
+	}
+	time.Sleep(scale * 5)
+
+	err = lock1.Acquire(time.Duration(ttlLength) * scale)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	defer lock1.Release()
+	if lock1.Token() <= token2 {
+		t.Errorf("Tokens should increase after expiration: %d <= %d", lock1.Token(), token2)
+	}
+}
+
+// TestData checks that the data of the holder is stored as is, and only
+// updated when the lock is acquired or refreshed.
+func TestData(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	c1, c2 := newClient(t), newClient(t)
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+	data := "host:1234 \"quoted\"\n\tλ"
+
+	lock1.SetData(data)
+	if err := lock1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	info, err := lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Data != data {
+		t.Errorf("Expected data %q, got %q", data, info.Data)
+	}
+
+	// The data of contenders is not stored
+	lock2.SetData("client2")
+	if err = lock2.Acquire(ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", glock.ErrLockHeldByOtherClient, err)
+	}
+	if info, err = lock1.Info(); err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Data != data {
+		t.Errorf("Expected data %q, got %q", data, info.Data)
+	}
+
+	// Data set after the acquisition is stored at the next refresh
+	lock1.SetData("")
+	if info, err = lock2.Info(); err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Data != data {
+		t.Errorf("SetData should not update the data of a lock held, got %q", info.Data)
+	}
+	if err = lock1.Refresh(); err != nil {
+		t.Fatalf("Error while refreshing lock: '%s'", err)
+	}
+	if info, err = lock2.Info(); err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Data != "" {
+		t.Errorf("Refresh did not refresh data, expected empty data, got %q", info.Data)
+	}
+
+	// Released locks have no data, and the next holder sets its own
+	if err = lock1.Release(); err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+	if info, err = lock1.Info(); err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Acquired || info.Data != "" {
+		t.Errorf("info: %+v -- expected Acquired: false and no data", info)
+	}
+	if err = lock2.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	defer lock2.Release()
+	if info, err = lock1.Info(); err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Data != "client2" {
+		t.Errorf("Expected data 'client2', got %q", info.Data)
+	}
+}
+
+// TestExpiry checks that locks are held for their whole TTL, refreshes
+// included, and that they are free shortly after it.
+func TestExpiry(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	// the tolerance on expirations
+	margin := ttl / 4
+	c1, c2 := newClient(t), newClient(t)
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+
+	start := time.Now()
+	if err := lock1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	defer lock1.Release()
+	// not to leave the lock behind for the next tests, if acquired too early
+	defer lock2.Release()
+
+	// the lock is surely held only until ttl after since, when its lease
+	// started at the earliest: a slow scheduler, e.g. under -race, might
+	// delay the checks past that, whatever the margin
+	late := func(since time.Time) {
+		if time.Since(since) >= ttl {
+			t.Skipf("Checks delayed past the TTL of %v, cannot tell if the lock expired", ttl)
+		}
+	}
+
+	sleepUntil(start.Add(ttl - margin))
+	info, err := lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	late(start)
+	if !info.Acquired || info.TTL <= 0 || info.TTL > 2*margin {
+		t.Errorf("info: %+v -- expected Acquired: true and TTL around %v", info, margin)
+	}
+	err = lock2.Acquire(ttl)
+	late(start)
+	if err != glock.ErrLockHeldByOtherClient {
+		t.Fatalf("Lock should be held until it expires, got '%v'", err)
+	}
+
+	// Refreshing extends the lease from the time of the refresh
+	refreshing := time.Now()
+	if err = lock1.Refresh(); err != nil {
+		t.Fatalf("Error while refreshing lock: '%s'", err)
+	}
+	refreshed := time.Now()
+	sleepUntil(refreshed.Add(ttl - margin))
+	err = lock2.Acquire(ttl)
+	late(refreshing)
+	if err != glock.ErrLockHeldByOtherClient {
+		t.Fatalf("Lock should be held until the refreshed lease expires, got '%v'", err)
+	}
+
+	sleepUntil(refreshed.Add(ttl + margin))
+	if info, err = lock2.Info(); err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Acquired {
+		t.Errorf("Lock should be expired, got %+v", info)
+	}
+	// Some stores free expired locks lazily, e.g. consul might take up to
+	// twice the TTL
+	for err = lock2.Acquire(ttl); err == glock.ErrLockHeldByOtherClient; err = lock2.Acquire(ttl) {
+		if time.Now().After(refreshed.Add(ttl*2 + margin)) {
+			break
+		}
+		time.Sleep(scale)
+	}
+	if err != nil {
+		t.Fatalf("Cannot acquire expired lock '%s': %s", lockName, err)
+	}
+	if err = lock1.Refresh(); err != glock.ErrLockNotOwned {
+		t.Errorf("Lock should be expired but refresh returned: '%v'", err)
+	}
+}
+
+// TestConcurrency checks that clients racing for a lock hold it one at a
+// time, in the order of their tokens.
+func TestConcurrency(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	const clients = 4
+	const rounds = 3
+	ttl := time.Duration(ttlLength) * scale
+	deadline := time.Now().Add(ttl * clients * rounds)
+
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	var tokens []uint64
+	holding := 0
+	for i := 0; i < clients; i++ {
+		lock := newClient(t).NewLock(lockName)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for acquired := 0; acquired < rounds; {
+				if time.Now().After(deadline) {
+					t.Errorf("Client %d acquired the lock %d times out of %d", i, acquired, rounds)
+					return
+				}
+				err := lock.Acquire(ttl)
+				if err == glock.ErrLockHeldByOtherClient {
+					time.Sleep(scale)
+					continue
+				}
+				if err != nil {
+					t.Errorf("Client %d cannot acquire lock '%s': %s", i, lockName, err)
+					return
+				}
+				acquired++
+
+				mtx.Lock()
+				holding++
+				if holding > 1 {
+					t.Errorf("Client %d acquired the lock while held by %d other clients", i, holding-1)
+				}
+				tokens = append(tokens, lock.Token())
+				mtx.Unlock()
+
+				time.Sleep(scale)
+
+				mtx.Lock()
+				holding--
+				mtx.Unlock()
+				if err = lock.Release(); err != nil {
+					t.Errorf("Client %d cannot release lock '%s': %s", i, lockName, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i] <= tokens[i-1] {
+			t.Errorf("Tokens should increase at every acquisition, got %v", tokens)
+			break
+		}
+	}
+}
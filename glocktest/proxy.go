@@ -0,0 +1,219 @@
+package glocktest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Proxy is an in-process TCP proxy to a store, which injects faults in the
+// connections going through it: latency, dropped and stalled connections,
+// and partitions. Clients connect through the proxy by dialing Addr instead
+// of the store, e.g. with RedisOptions.DialFunc or CassandraOptions.Dialer.
+// Use one proxy per client to break the connections of some clients only.
+type Proxy struct {
+	network  string
+	address  string
+	listener net.Listener
+
+	mtx         sync.Mutex
+	cond        *sync.Cond
+	latency     time.Duration
+	partitioned bool
+	closed      bool
+	conns       map[*proxyConn]bool
+}
+
+// proxyConn is a connection of a client, forwarded to the store
+type proxyConn struct {
+	client  net.Conn
+	server  net.Conn
+	stalled bool
+	closed  bool
+}
+
+// NewProxy starts a proxy to the store listening on the given network and
+// address, e.g. "tcp" and "localhost:6379".
+func NewProxy(network, address string) (*Proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		network:  network,
+		address:  address,
+		listener: listener,
+		conns:    make(map[*proxyConn]bool),
+	}
+	p.cond = sync.NewCond(&p.mtx)
+	go p.accept()
+	return p, nil
+}
+
+// Addr returns the TCP address clients connect to
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Dial connects to the proxy. The arguments are ignored, so that it can be
+// used in place of the dial function of a client.
+func (p *Proxy) Dial(network, address string) (net.Conn, error) {
+	return p.DialContext(context.Background(), network, address)
+}
+
+// DialContext is like Dial, but honours the given context.
+func (p *Proxy) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", p.Addr())
+}
+
+// SetLatency delays everything forwarded from now on by d, in both
+// directions
+func (p *Proxy) SetLatency(d time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.latency = d
+}
+
+// Drop closes the connections open, as if the store reset them. New
+// connections are forwarded as usual.
+func (p *Proxy) Drop() {
+	p.mtx.Lock()
+	conns := make([]*proxyConn, 0, len(p.conns))
+	for c := range p.conns {
+		conns = append(conns, c)
+	}
+	p.mtx.Unlock()
+	for _, c := range conns {
+		p.drop(c)
+	}
+}
+
+// Stall stops forwarding the connections open, without closing them, as if
+// the store hung: clients wait until their calls time out. Data sent
+// meanwhile is delivered after Resume. New connections are forwarded as
+// usual.
+func (p *Proxy) Stall() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for c := range p.conns {
+		c.stalled = true
+	}
+}
+
+// Resume forwards the connections stalled again
+func (p *Proxy) Resume() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for c := range p.conns {
+		c.stalled = false
+	}
+	p.cond.Broadcast()
+}
+
+// Partition cuts the clients off the store: nothing is forwarded, on the
+// connections open as well as on new ones, until Heal. Connecting to the
+// proxy still succeeds.
+func (p *Proxy) Partition() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.partitioned = true
+}
+
+// Heal ends the partition. Data sent meanwhile is delivered.
+func (p *Proxy) Heal() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.partitioned = false
+	p.cond.Broadcast()
+}
+
+// Close stops the proxy, and closes all its connections
+func (p *Proxy) Close() {
+	p.mtx.Lock()
+	p.closed = true
+	p.mtx.Unlock()
+	p.listener.Close()
+	p.Drop()
+}
+
+func (p *Proxy) accept() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.serve(client)
+	}
+}
+
+func (p *Proxy) serve(client net.Conn) {
+	server, err := net.Dial(p.network, p.address)
+	if err != nil {
+		client.Close()
+		return
+	}
+	c := &proxyConn{client: client, server: server}
+	p.mtx.Lock()
+	if p.closed {
+		p.mtx.Unlock()
+		client.Close()
+		server.Close()
+		return
+	}
+	p.conns[c] = true
+	p.mtx.Unlock()
+	go p.forward(c, client, server)
+	go p.forward(c, server, client)
+}
+
+// forward copies from src to dst until either is closed
+func (p *Proxy) forward(c *proxyConn, src, dst net.Conn) {
+	defer p.drop(c)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if !p.wait(c) {
+				return
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// wait waits for the latency, and for c to be forwarded again if stalled or
+// partitioned. It returns false if c was closed meanwhile.
+func (p *Proxy) wait(c *proxyConn) bool {
+	p.mtx.Lock()
+	latency := p.latency
+	p.mtx.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for !c.closed && (c.stalled || p.partitioned) {
+		p.cond.Wait()
+	}
+	return !c.closed
+}
+
+func (p *Proxy) drop(c *proxyConn) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.client.Close()
+	c.server.Close()
+	delete(p.conns, c)
+	p.cond.Broadcast()
+}
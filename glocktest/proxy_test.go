@@ -0,0 +1,99 @@
+package glocktest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// echo starts a server echoing back everything it receives
+func echo(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot start echo server: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	return l
+}
+
+// roundtrip sends a line on conn, and returns the line read back within
+// timeout, or the error
+func roundtrip(conn net.Conn, r *bufio.Reader, timeout time.Duration) (string, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		return "", err
+	}
+	return r.ReadString('\n')
+}
+
+func TestProxy(t *testing.T) {
+	l := echo(t)
+	defer l.Close()
+	proxy, err := NewProxy("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Cannot start proxy: %s", err)
+	}
+	defer proxy.Close()
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := proxy.Dial("", "")
+		if err != nil {
+			t.Fatalf("Cannot connect to proxy: %s", err)
+		}
+		return conn, bufio.NewReader(conn)
+	}
+	conn, r := dial()
+	defer conn.Close()
+	if line, err := roundtrip(conn, r, time.Second); err != nil || line != "ping\n" {
+		t.Fatalf("Expected 'ping', got '%s', %v", line, err)
+	}
+
+	// stalled connections deliver once resumed, new ones are forwarded
+	proxy.Stall()
+	if _, err = roundtrip(conn, r, 50*time.Millisecond); err == nil {
+		t.Errorf("Stalled connection should time out")
+	}
+	conn2, r2 := dial()
+	if _, err = roundtrip(conn2, r2, time.Second); err != nil {
+		t.Errorf("New connection should be forwarded while others are stalled: %s", err)
+	}
+	conn2.Close()
+	proxy.Resume()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if line, err := r.ReadString('\n'); err != nil || line != "ping\n" {
+		t.Errorf("Expected 'ping' once resumed, got '%s', %v", line, err)
+	}
+
+	// partitions cut every connection, old and new
+	proxy.Partition()
+	conn3, r3 := dial()
+	if _, err = roundtrip(conn3, r3, 50*time.Millisecond); err == nil {
+		t.Errorf("New connection should time out while partitioned")
+	}
+	conn3.Close()
+	proxy.Heal()
+	if line, err := roundtrip(conn, r, time.Second); err != nil || line != "ping\n" {
+		t.Errorf("Expected 'ping' once healed, got '%s', %v", line, err)
+	}
+
+	// dropped connections are closed
+	proxy.Drop()
+	if _, err = roundtrip(conn, r, time.Second); err == nil {
+		t.Errorf("Dropped connection should be closed")
+	}
+	conn4, r4 := dial()
+	defer conn4.Close()
+	if line, err := roundtrip(conn4, r4, time.Second); err != nil || line != "ping\n" {
+		t.Errorf("Expected 'ping' after drop, got '%s', %v", line, err)
+	}
+}
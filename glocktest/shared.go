@@ -0,0 +1,214 @@
+package glocktest
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+func rwLock(t *testing.T, c glock.Client) glock.RWLock {
+	rwc, ok := c.(glock.RWClient)
+	if !ok {
+		t.Skipf("Client %T does not implement RWClient", c)
+	}
+	return rwc.NewRWLock(lockName)
+}
+
+func semaphore(t *testing.T, c glock.Client, slots int) glock.Semaphore {
+	sc, ok := c.(glock.SemaphoreClient)
+	if !ok {
+		t.Skipf("Client %T does not implement SemaphoreClient", c)
+	}
+	return sc.NewSemaphore(lockName, slots)
+}
+
+// TestRWLock checks reader-writer locks. It is skipped if the clients do not
+// implement RWClient.
+func TestRWLock(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	c1, c2, c3 := newClient(t), newClient(t), newClient(t)
+	r1, r2, w := rwLock(t, c1), rwLock(t, c2), rwLock(t, c3)
+	ttl := time.Duration(ttlLength) * scale
+
+	if err := r1.AcquireShared(ttl); err != nil {
+		t.Fatalf("Cannot acquire shared lock: %s", err)
+	}
+	if err := r2.AcquireShared(ttl); err != nil {
+		t.Fatalf("Cannot acquire shared lock held by another reader: %s", err)
+	}
+	if r1.Token() != 0 {
+		t.Errorf("Shared acquisitions should not get a token, got %d", r1.Token())
+	}
+
+	// Writers, with both RWLock and plain Lock, are excluded
+	if err := w.AcquireExclusive(ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", glock.ErrLockHeldByOtherClient, err)
+	}
+	if err := c3.NewLock(lockName).Acquire(ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", glock.ErrLockHeldByOtherClient, err)
+	}
+
+	info, err := w.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	holders := []string{c1.ID(), c2.ID()}
+	sort.Strings(holders)
+	if !info.Acquired || !info.Shared || info.Owner != "" {
+		t.Errorf("info: %+v -- expected Acquired: true, Shared: true and no Owner", info)
+	}
+	if !reflect.DeepEqual(info.Holders, holders) {
+		t.Errorf("info.Holders: expected %v, got %v", holders, info.Holders)
+	}
+	if info.TTL <= 0 || info.TTL > ttl {
+		t.Errorf("Invalid TTL returned by lock.Info() : %v", info.TTL)
+	}
+
+	// Refresh and Release act on the shared lease of the client
+	if err = w.Refresh(); err != glock.ErrLockNotOwned {
+		t.Errorf("Refreshing a lock not held should return '%s', got: '%s'", glock.ErrLockNotOwned, err)
+	}
+	if err = r1.RefreshTTL(ttl * 2); err != nil {
+		t.Errorf("Cannot refresh shared lock: %s", err)
+	}
+	if err = r1.Release(); err != nil {
+		t.Fatalf("Cannot release shared lock: %s", err)
+	}
+	if err = r1.Release(); err != glock.ErrLockNotOwned {
+		t.Errorf("Releasing a lock twice should return '%s', got: '%s'", glock.ErrLockNotOwned, err)
+	}
+	info, err = w.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if !reflect.DeepEqual(info.Holders, []string{c2.ID()}) {
+		t.Errorf("info.Holders: expected [%s], got %v", c2.ID(), info.Holders)
+	}
+	if err = r2.Release(); err != nil {
+		t.Fatalf("Cannot release shared lock: %s", err)
+	}
+
+	// Once all readers are gone, writers get in and keep readers out
+	if err = w.AcquireExclusive(ttl); err != nil {
+		t.Fatalf("Cannot acquire exclusive lock: %s", err)
+	}
+	if w.Token() == 0 {
+		t.Errorf("Exclusive acquisitions should get a token")
+	}
+	if err = r1.AcquireShared(ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", glock.ErrLockHeldByOtherClient, err)
+	}
+	info, err = r1.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Shared || info.Owner != c3.ID() || len(info.Holders) != 0 {
+		t.Errorf("info: %+v -- expected Shared: false and Owner: %s", info, c3.ID())
+	}
+	if err = w.Release(); err != nil {
+		t.Fatalf("Cannot release exclusive lock: %s", err)
+	}
+
+	// Shared leases expire on their own
+	if err = r1.AcquireShared(scale * 2); err != nil {
+		t.Fatalf("Cannot acquire shared lock: %s", err)
+	}
+	time.Sleep(scale * 5)
+	info, err = r1.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Acquired {
+		t.Errorf("Shared lock should be expired, got %+v", info)
+	}
+	if err = r1.Refresh(); err != glock.ErrLockNotOwned {
+		t.Errorf("Lock should be expired but refresh returned: '%s'", err)
+	}
+}
+
+// TestSemaphore checks semaphores. It is skipped if the clients do not
+// implement SemaphoreClient.
+func TestSemaphore(t *testing.T, newClient NewClientFunc, scale time.Duration) {
+	c1, c2, c3 := newClient(t), newClient(t), newClient(t)
+	s1, s2, s3 := semaphore(t, c1, 2), semaphore(t, c2, 2), semaphore(t, c3, 2)
+	ttl := time.Duration(ttlLength) * scale
+
+	if s1.Slots() != 2 {
+		t.Errorf("Expected 2 slots, got %d", s1.Slots())
+	}
+	if err := semaphore(t, c1, 0).Acquire(ttl); err != glock.ErrInvalidSlots {
+		t.Errorf("Expected error '%s', got '%s'", glock.ErrInvalidSlots, err)
+	}
+
+	if err := s1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire slot: %s", err)
+	}
+	if err := s1.Acquire(ttl); err != nil {
+		t.Errorf("Acquiring a slot twice should refresh it, got: %s", err)
+	}
+	if err := s2.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire second slot: %s", err)
+	}
+	if err := s3.Acquire(ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", glock.ErrLockHeldByOtherClient, err)
+	}
+
+	// semaphores do not conflict with locks with the same name
+	lock := c3.NewLock(lockName)
+	if err := lock.Acquire(ttl); err != nil {
+		t.Errorf("Cannot acquire lock with the same name of a semaphore: %s", err)
+	}
+	lock.Release()
+
+	info, err := s3.Info()
+	if err != nil {
+		t.Fatalf("Error while getting semaphore info: %s", err)
+	}
+	holders := []string{c1.ID(), c2.ID()}
+	sort.Strings(holders)
+	if !info.Acquired || !info.Shared || info.Owner != "" {
+		t.Errorf("info: %+v -- expected Acquired: true, Shared: true and no Owner", info)
+	}
+	if !reflect.DeepEqual(info.Holders, holders) {
+		t.Errorf("info.Holders: expected %v, got %v", holders, info.Holders)
+	}
+	if info.TTL <= 0 || info.TTL > ttl {
+		t.Errorf("Invalid TTL returned by semaphore.Info() : %v", info.TTL)
+	}
+
+	if err = s3.Refresh(); err != glock.ErrLockNotOwned {
+		t.Errorf("Refreshing a slot not held should return '%s', got: '%s'", glock.ErrLockNotOwned, err)
+	}
+	if err = s1.RefreshTTL(ttl * 2); err != nil {
+		t.Errorf("Cannot refresh slot: %s", err)
+	}
+	if err = s1.Release(); err != nil {
+		t.Fatalf("Cannot release slot: %s", err)
+	}
+	if err = s1.Release(); err != glock.ErrLockNotOwned {
+		t.Errorf("Releasing a slot twice should return '%s', got: '%s'", glock.ErrLockNotOwned, err)
+	}
+	if err = s3.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire released slot: %s", err)
+	}
+	s2.Release()
+	s3.Release()
+
+	// slots expire on their own
+	if err = s1.Acquire(scale * 2); err != nil {
+		t.Fatalf("Cannot acquire slot: %s", err)
+	}
+	time.Sleep(scale * 5)
+	info, err = s1.Info()
+	if err != nil {
+		t.Fatalf("Error while getting semaphore info: %s", err)
+	}
+	if info.Acquired || len(info.Holders) != 0 {
+		t.Errorf("Slot should be expired, got %+v", info)
+	}
+	if err = s1.Refresh(); err != glock.ErrLockNotOwned {
+		t.Errorf("Slot should be expired but refresh returned: '%s'", err)
+	}
+}
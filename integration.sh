@@ -3,7 +3,7 @@
 set -eu
 
 if [ $# -ne 1 ]; then
-  echo >&2 "Usage: $0 <memory|redis|cassandra:x.y.z>"
+  echo >&2 "Usage: $0 <memory|redis|redlock|etcd|zookeeper|consul|postgres|file|cassandra:x.y.z>"
   exit 1
 fi
 
@@ -20,6 +20,30 @@ case $DB in
     TAGS='redis'
     ;;
 
+  "redlock")
+    TAGS='redlock'
+    ;;
+
+  "etcd")
+    TAGS='etcd'
+    ;;
+
+  "zookeeper")
+    TAGS='zookeeper'
+    ;;
+
+  "consul")
+    TAGS='consul'
+    ;;
+
+  "postgres")
+    TAGS='postgres'
+    ;;
+
+  "file")
+    TAGS='file'
+    ;;
+
   "cassandra")
     echo >&2 "Missing cassandra version. (cassandra:x.y.z)"
     exit 1
@@ -78,5 +102,5 @@ if [ ! -z "$VERSION" ]; then
   echo " ok"
 fi
 
-go test -v --tags="$TAGS"
+go test -v -race --tags="$TAGS"
 exit $?
@@ -0,0 +1,266 @@
+package glock
+
+import (
+	"context"
+	"time"
+)
+
+// LeaseEventType is the type of the events emitted by a lease keeper
+type LeaseEventType int
+
+const (
+	// LeaseRefreshed is emitted when the lease of the lock was refreshed
+	LeaseRefreshed LeaseEventType = iota
+	// LeaseDegraded is emitted when a refresh failed and will be retried
+	// before the lease expires
+	LeaseDegraded
+	// LeaseLost is emitted when the lease expired before it could be
+	// refreshed, or the store reported the lock as not owned anymore
+	LeaseLost
+	// LeaseReacquired is emitted when the lock was acquired again after its
+	// lease was lost, with the ReacquireOnLoss policy
+	LeaseReacquired
+)
+
+func (t LeaseEventType) String() string {
+	switch t {
+	case LeaseRefreshed:
+		return "refreshed"
+	case LeaseDegraded:
+		return "degraded"
+	case LeaseLost:
+		return "lost"
+	case LeaseReacquired:
+		return "reacquired"
+	default:
+		return "unknown"
+	}
+}
+
+// LeaseEvent describes a change in the lease of a lock kept by a LockManager
+type LeaseEvent struct {
+	Type LeaseEventType
+	// Lock is the name of the lock
+	Lock string
+	// Time is when the event happened
+	Time time.Time
+	// Expires is when the lease expires, as far as the keeper knows
+	Expires time.Time
+	// Attempt counts the failed attempts since the last successful one
+	Attempt int
+	// Err is the error of the last failed attempt, if any
+	Err error
+}
+
+// LossPolicy tells what a lease keeper does when the lease of a lock is lost
+type LossPolicy int
+
+const (
+	// StopOnLoss stops the keeper. The manager keeps the lock until it is
+	// released, and its calls for the lock return the errors of the store.
+	StopOnLoss LossPolicy = iota
+	// ForgetOnLoss stops the keeper, and the manager forgets the lock as if
+	// it had been released.
+	ForgetOnLoss
+	// ReacquireOnLoss keeps trying to acquire the lock again, backing off
+	// between the attempts, until the keeper is stopped.
+	ReacquireOnLoss
+)
+
+// KeeperOptions configures how a LockManager keeps the lease of a lock.
+type KeeperOptions struct {
+	// Interval is how often the lease is refreshed. If <= 0, half the TTL
+	// of the lock is used.
+	Interval time.Duration
+	// MinBackoff is how long to wait before retrying a failed refresh. The
+	// wait doubles at every failure, up to MaxBackoff, but never goes past
+	// the end of the lease. If <= 0, a tenth of the interval is used.
+	MinBackoff time.Duration
+	// MaxBackoff is the longest wait between failed attempts. If <= 0, the
+	// interval is used.
+	MaxBackoff time.Duration
+	// OnLoss is what to do when the lease is lost
+	OnLoss LossPolicy
+	// OnEvent, if not nil, is called with every event from the goroutine
+	// of the keeper, so it should not block.
+	OnEvent func(LeaseEvent)
+}
+
+// keeper refreshes the lease of a lock held by a manager in the background,
+// with its own copy of the client of the manager
+type keeper struct {
+	manager *LockManager
+	name    string
+	mode    lockMode
+	opts    KeeperOptions
+	client  Client
+	expires time.Time
+	// lost receives the error the lease was lost with
+	lost   chan error
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (k *keeper) stop() {
+	k.cancel()
+	<-k.done
+}
+
+func (k *keeper) interval(ttl time.Duration) time.Duration {
+	if k.opts.Interval > 0 {
+		return k.opts.Interval
+	}
+	return ttl / 2
+}
+
+// backoff returns how long to wait after the given number of failed attempts
+func (k *keeper) backoff(ttl time.Duration, attempt int) time.Duration {
+	min, max := k.opts.MinBackoff, k.opts.MaxBackoff
+	if min <= 0 {
+		min = k.interval(ttl) / 10
+	}
+	if max <= 0 {
+		max = k.interval(ttl)
+	}
+	wait := min
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+func (k *keeper) emit(t LeaseEventType, attempt int, err error) {
+	ev := LeaseEvent{
+		Type:    t,
+		Lock:    k.name,
+		Time:    k.manager.clock().Now(),
+		Expires: k.expires,
+		Attempt: attempt,
+		Err:     err,
+	}
+	if err != nil {
+		k.manager.Logger.Printf("client %s: lease of lock '%s' %s (attempt %d): %s",
+			k.client.ID(), k.name, t, attempt, err)
+	} else {
+		k.manager.Logger.Printf("client %s: lease of lock '%s' %s until %s",
+			k.client.ID(), k.name, t, k.expires.Format(time.RFC3339Nano))
+	}
+	if k.opts.OnEvent != nil {
+		k.opts.OnEvent(ev)
+	}
+}
+
+// sleep waits for d on the given clock, and returns false if ctx is done
+// meanwhile
+func sleep(ctx context.Context, clock Clock, d time.Duration) bool {
+	t := clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (k *keeper) run(ctx context.Context) {
+	defer close(k.done)
+	defer k.client.Close()
+	k.client.ReconnectContext(ctx)
+
+	lock, err := newLock(k.client, k.name, k.mode)
+	if err != nil {
+		k.lose(ctx, 0, err)
+		return
+	}
+	clock := k.manager.clock()
+	_, ttl, _ := k.manager.lock(k.name)
+	wait := k.interval(ttl)
+	attempt := 0
+	for sleep(ctx, clock, wait) {
+		_, ttl, ok := k.manager.lock(k.name)
+		if !ok {
+			return
+		}
+		lock.SetData(k.manager.lockData(k.name))
+
+		// a refresh past the end of the lease is pointless: the lock would
+		// be expired anyway.
+		start := clock.Now()
+		rctx, cancel := withDeadline(ctx, clock, k.expires)
+		err = lock.RefreshTTLContext(rctx, ttl)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			k.expires = start.Add(ttl)
+			attempt = 0
+			k.emit(LeaseRefreshed, attempt, nil)
+			wait = k.interval(ttl)
+			continue
+		}
+
+		attempt++
+		left := k.expires.Sub(clock.Now())
+		if notOwned(err) || left <= 0 {
+			if !k.lose(ctx, attempt, err) {
+				return
+			}
+			attempt = 0
+			wait = k.interval(ttl)
+			continue
+		}
+		k.emit(LeaseDegraded, attempt, err)
+		rctx, cancel = withDeadline(ctx, clock, k.expires)
+		k.client.ReconnectContext(rctx)
+		cancel()
+		wait = k.backoff(ttl, attempt)
+		if left = k.expires.Sub(clock.Now()); wait > left {
+			// one last attempt before the lease expires
+			wait = left * 3 / 4
+		}
+	}
+}
+
+// lose handles the loss of the lease according to the policy, and returns
+// true if the lock was acquired again
+func (k *keeper) lose(ctx context.Context, attempt int, err error) bool {
+	k.emit(LeaseLost, attempt, err)
+	k.manager.leaseLost(k.name, err)
+	select {
+	case k.lost <- err:
+	default:
+	}
+
+	switch k.opts.OnLoss {
+	case ForgetOnLoss:
+		k.manager.forget(ctx, k)
+		return false
+
+	case ReacquireOnLoss:
+		for attempt = 1; ; attempt++ {
+			_, ttl, ok := k.manager.lock(k.name)
+			if !ok {
+				return false
+			}
+			start := k.manager.clock().Now()
+			err = k.manager.reacquire(ctx, k)
+			if ctx.Err() != nil {
+				return false
+			}
+			if err == nil {
+				k.expires = start.Add(ttl)
+				k.emit(LeaseReacquired, attempt, nil)
+				return true
+			}
+			if !sleep(ctx, k.manager.clock(), k.backoff(ttl, attempt)) {
+				return false
+			}
+		}
+	}
+	return false
+}
@@ -1,7 +1,9 @@
 package glock
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -17,6 +19,9 @@ type Client interface {
 	// Reconnect reconnects to the store, or connects if not connected
 	Reconnect() error
 
+	// ReconnectContext is like Reconnect, but gives up when the context is done.
+	ReconnectContext(ctx context.Context) error
+
 	// Close closes the connection to the store
 	Close()
 
@@ -28,31 +33,189 @@ type Client interface {
 }
 
 // Lock represent a lock in the store
+//
+// Every operation that talks to the store has a Context variant. When the
+// context is cancelled or its deadline expires, the in-flight call is aborted
+// and the context error (context.Canceled or context.DeadlineExceeded) is
+// returned as is, so it can be told apart from errors coming from the store.
 type Lock interface {
 
 	// Info returns a LockInfo struct with information about this lock
 	Info() (*LockInfo, error)
 
+	// InfoContext is like Info, but honours the given context.
+	InfoContext(ctx context.Context) (*LockInfo, error)
+
 	// Acquire tries to acquire the lock for a specified duration
 	// The lock must not be locked.
 	Acquire(ttl time.Duration) error
 
+	// AcquireContext is like Acquire, but honours the given context.
+	AcquireContext(ctx context.Context, ttl time.Duration) error
+
 	// Refresh extends the validity of the lock by its ttl
 	// The lock must be acquired by the current client.
 	Refresh() error
 
+	// RefreshContext is like Refresh, but honours the given context.
+	RefreshContext(ctx context.Context) error
+
 	// RefreshTTL extends the validity of the lock by the given ttl.
 	// The lock must be acquired by the current client
 	RefreshTTL(ttl time.Duration) error
 
+	// RefreshTTLContext is like RefreshTTL, but honours the given context.
+	RefreshTTLContext(ctx context.Context, ttl time.Duration) error
+
 	// Release removed the lock from the store.
 	// The lock must be owned by the current client
 	Release() error
 
+	// ReleaseContext is like Release, but honours the given context.
+	ReleaseContext(ctx context.Context) error
+
 	// SetData sets the data payload for the lock.
 	// The data is set into the backend only when the lock is acquired,
 	// so any call to this method after acquisition won't update the value.
 	SetData(data string)
+
+	// Token returns the fencing token obtained by the last successful
+	// acquisition of this lock, or 0 if the lock was never acquired.
+	// Tokens increase monotonically for every acquisition of a given lock name,
+	// so a store can reject writes carrying a token lower than the last seen.
+	Token() uint64
+}
+
+// RWClient is implemented by clients that support reader-writer locks
+type RWClient interface {
+	Client
+
+	// NewRWLock returns a new reader-writer lock object
+	NewRWLock(name string) RWLock
+}
+
+// RWLock represents a lock that can be held either by a single client in
+// exclusive mode, or by many clients at once in shared mode.
+// An RWLock and a Lock with the same name refer to the same lock in the store:
+// a Lock is just an RWLock that can be acquired only in exclusive mode.
+//
+// Acquire is the same as AcquireExclusive. Refresh, RefreshTTL and Release act
+// on the lease held by the current client, whatever its mode.
+// Shared acquisitions do not get a fencing token, so Token returns 0.
+type RWLock interface {
+	Lock
+
+	// AcquireShared tries to acquire the lock in shared mode for a specified duration.
+	// The lock must not be held in exclusive mode.
+	AcquireShared(ttl time.Duration) error
+
+	// AcquireSharedContext is like AcquireShared, but honours the given context.
+	AcquireSharedContext(ctx context.Context, ttl time.Duration) error
+
+	// AcquireExclusive tries to acquire the lock in exclusive mode for a specified duration.
+	// The lock must not be held in any mode.
+	AcquireExclusive(ttl time.Duration) error
+
+	// AcquireExclusiveContext is like AcquireExclusive, but honours the given context.
+	AcquireExclusiveContext(ctx context.Context, ttl time.Duration) error
+}
+
+// SemaphoreClient is implemented by clients that support counting semaphores
+type SemaphoreClient interface {
+	Client
+
+	// NewSemaphore returns a new semaphore object with the given number of slots
+	NewSemaphore(name string, slots int) Semaphore
+}
+
+// Semaphore represents a counting semaphore in the store: up to Slots clients
+// can hold it at once, each with its own slot and TTL. Expired slots are
+// reclaimed automatically.
+// Semaphores live in their own namespace: a Semaphore and a Lock with the same
+// name are unrelated. The number of slots is not stored, so all the clients
+// must agree on it.
+//
+// Acquire takes a slot, and returns ErrLockHeldByOtherClient if all the slots
+// are taken. Refresh, RefreshTTL and Release act on the slot of the current client.
+// Info reports the slot holders in Holders, and the TTL of the slot that
+// expires first. Slots carry no data and get no fencing token.
+type Semaphore interface {
+	Lock
+
+	// Slots returns the number of slots of the semaphore
+	Slots() int
+}
+
+// MultiClient is implemented by clients that can acquire several locks at
+// once atomically: either all of them are acquired, or none is.
+// Stores that cannot do it, e.g. because conditional updates cannot span
+// several locks, leave it to LockManager.AcquireAll to acquire the locks one
+// at a time.
+type MultiClient interface {
+	Client
+
+	// AcquireAllContext acquires all the given locks, created by this client
+	// with NewLock, for the given ttl. If any of them is held, none is
+	// acquired and ErrLockHeldByOtherClient is returned. If any of them was
+	// not created by this client, e.g. by a wrapper of it, ErrNotSupported
+	// is returned.
+	AcquireAllContext(ctx context.Context, locks []Lock, ttl time.Duration) error
+}
+
+// NotifyClient is implemented by clients that can be notified by the store
+// when a lock changes, so that watchers do not have to poll it.
+type NotifyClient interface {
+	Client
+
+	// Notify returns a channel which receives a value when the lock with the
+	// given name may have changed. Notifications may be coalesced. The
+	// channel is closed when ctx is done, or when notifications stop, e.g.
+	// because the connection to the store was lost.
+	Notify(ctx context.Context, name string) (<-chan struct{}, error)
+}
+
+// ListClient is implemented by clients that can enumerate the locks in their
+// store, e.g. to see what every job holds.
+type ListClient interface {
+	Client
+
+	// List returns the information of all the live locks whose name starts
+	// with the given prefix, held in exclusive or shared mode, sorted by
+	// name. Semaphores are not listed.
+	List(prefix string) ([]LockInfo, error)
+	// ListContext is like List, but honours the given context.
+	ListContext(ctx context.Context, prefix string) ([]LockInfo, error)
+}
+
+// AdminClient is implemented by clients that can evict the holders of a lock
+// whatever their ownership, e.g. when a holder is wedged but still
+// heartbeating. The evicted holders get ErrLockStolen from Refresh and
+// Release until their lease would have ended.
+// It is implemented by the memory and redis clients only: the other stores
+// do not keep the evicted holders, so they could not tell a stolen lock from
+// an expired one.
+type AdminClient interface {
+	Client
+
+	// ForceRelease releases the lock with the given name, held in exclusive
+	// or shared mode by any client. Who released it and why is recorded as
+	// the data of the lock until the leases of the evicted holders end.
+	ForceRelease(name, reason string) error
+	// ForceReleaseContext is like ForceRelease, but honours the given context.
+	ForceReleaseContext(ctx context.Context, name, reason string) error
+	// Steal evicts the holders of the lock with the given name, like
+	// ForceRelease, and acquires it for the given ttl, even if other clients
+	// are waiting for it. Who stole it and why is recorded as the data of the
+	// lock. The returned lock is held by this client.
+	Steal(name string, ttl time.Duration, reason string) (Lock, error)
+	// StealContext is like Steal, but honours the given context.
+	StealContext(ctx context.Context, name string, ttl time.Duration, reason string) (Lock, error)
+}
+
+// adminRecord returns the data recorded when the client with the given id
+// evicts the holders of a lock
+func adminRecord(action, id, reason string) string {
+	return fmt.Sprintf("%s by %s: %s", action, id, reason)
 }
 
 // LockInfo represent information about a given lock
@@ -67,6 +230,22 @@ type LockInfo struct {
 	TTL time.Duration
 	// Data associated with the lock, if any
 	Data string
+	// Token is the fencing token of the current owner, 0 if the lock is not acquired
+	Token uint64
+	// Shared is true if the lock is held in shared mode. Owner is empty, and TTL is
+	// the remaining TTL of the longest lived holder.
+	Shared bool
+	// Holders are the ClientIDs of the clients holding the lock in shared mode, if any
+	Holders []string
+	// Queued is the number of clients waiting in the fair queue of the lock
+	Queued int
+	// Position is the position of the client of the lock in the fair queue,
+	// starting from 1, or 0 if the client is not waiting
+	Position int
+	// Holds is the number of times the lock is held by the LockManager which
+	// returned the info: more than 1 if acquired again in reentrant mode.
+	// It is 0 for the info returned by the stores.
+	Holds int
 }
 
 var (
@@ -79,4 +258,24 @@ var (
 	ErrInvalidLock = errors.New("Invalid lock name")
 	// ErrLockNotOwned is returned when either the lock is not existing or held by another client
 	ErrLockNotOwned = errors.New("Lock is not held by current client")
+	// ErrLockStolen is returned when the lock of the current client was force released or
+	// stolen by an administrator
+	ErrLockStolen = errors.New("Lock was stolen from current client")
+	// ErrInvalidSlots is returned when the number of slots of a semaphore is not valid
+	ErrInvalidSlots = errors.New("Invalid number of slots")
+	// ErrNotSupported is returned when the client does not support the requested operation
+	ErrNotSupported = errors.New("Operation not supported by client")
+	// ErrModeMismatch is returned when acquiring a lock already held by the manager in
+	// another mode, i.e. exclusive, shared or as a slot of a semaphore of another size
+	ErrModeMismatch = errors.New("Lock held in another mode")
 )
+
+// contextError returns the context error if ctx is done, err otherwise.
+// Drivers use it to report aborted calls consistently, whatever error the
+// underlying connection returned when it was torn down.
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
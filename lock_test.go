@@ -1,6 +1,9 @@
 package glock
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -166,3 +169,215 @@ func testLock(t *testing.T, cfun newClientFunc, scale time.Duration) {
 		t.Fatalf("Lock should be expired but release was succesful: %s", err)
 	}
 }
+
+func testLockContext(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	c2 := cfun(t)
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A done context must abort the operation with the context error
+	err := lock1.AcquireContext(ctx, time.Duration(ttlLength)*scale)
+	if err != context.Canceled {
+		t.Fatalf("Acquire with a cancelled context should return '%s', got '%s'", context.Canceled, err)
+	}
+	_, err = lock1.InfoContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("Info with a cancelled context should return '%s', got '%s'", context.Canceled, err)
+	}
+
+	err = lock1.AcquireContext(context.Background(), time.Duration(ttlLength)*scale)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	err = lock1.RefreshContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("Refresh with a cancelled context should return '%s', got '%s'", context.Canceled, err)
+	}
+	err = lock1.ReleaseContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("Release with a cancelled context should return '%s', got '%s'", context.Canceled, err)
+	}
+
+	// The client must still be usable after an aborted call
+	err = lock2.AcquireContext(context.Background(), time.Duration(ttlLength)*scale)
+	if err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got %s", ErrLockHeldByOtherClient, err)
+	}
+	err = lock1.ReleaseContext(context.Background())
+	if err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+}
+
+func testLockToken(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	c2 := cfun(t)
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+
+	if lock1.Token() != 0 {
+		t.Errorf("Token should be 0 before acquisition, got %d", lock1.Token())
+	}
+
+	err := lock1.Acquire(time.Duration(ttlLength) * scale)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	token1 := lock1.Token()
+	if token1 == 0 {
+		t.Fatalf("Acquire should return a fencing token")
+	}
+
+	// A failed acquisition does not hand out a token
+	err = lock2.Acquire(time.Duration(ttlLength) * scale)
+	if err != ErrLockHeldByOtherClient {
+		t.Fatalf("Expected error '%s', got %s", ErrLockHeldByOtherClient, err)
+	}
+	if lock2.Token() != 0 {
+		t.Errorf("Token should be 0 after a failed acquisition, got %d", lock2.Token())
+	}
+
+	info, err := lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Token != token1 {
+		t.Errorf("info.Token should be the owner token %d, got %d", token1, info.Token)
+	}
+
+	// Refreshing keeps the token
+	err = lock1.Refresh()
+	if err != nil {
+		t.Fatalf("Error while refreshing lock: '%s'", err)
+	}
+	if lock1.Token() != token1 {
+		t.Errorf("Refresh changed the token: %d != %d", lock1.Token(), token1)
+	}
+
+	err = lock1.Release()
+	if err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+	info, err = lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Token != 0 {
+		t.Errorf("info.Token should be 0 for a lock not acquired, got %d", info.Token)
+	}
+
+	// Tokens increase across owners, also after expiration
+	expiring := scale * 2
+	err = lock2.Acquire(expiring)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	token2 := lock2.Token()
+	if token2 <= token1 {
+		t.Errorf("Tokens should increase: %d <= %d", token2, token1)
+	}
+
+	// the lock is free once expired, which some stores notice lazily
+	time.Sleep(expiring)
+	deadline := time.Now().Add(expiring * 2)
+	for {
+		err = lock1.Acquire(time.Duration(ttlLength) * scale)
+		if err != ErrLockHeldByOtherClient || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(scale)
+	}
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	defer lock1.Release()
+	if lock1.Token() <= token2 {
+		t.Errorf("Tokens should increase after expiration: %d <= %d", lock1.Token(), token2)
+	}
+}
+
+// queuedPosition waits for the client of the lock to be at the given position
+// of the fair queue
+func queuedPosition(t *testing.T, lock Lock, position int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		info, err := lock.Info()
+		if err != nil {
+			t.Fatalf("Error while getting lock info: %s", err)
+		}
+		if info.Position == position {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected position %d in the queue, got %d", position, info.Position)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testLockFairQueue(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	holder := cfun(t).NewLock(lockName)
+	if err := holder.Acquire(ttl * 10); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+
+	// Waiters queue up in order, and get the lock in the same order
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	var order []int
+	for i := 0; i < 3; i++ {
+		l := cfun(t).NewLock(lockName)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := l.(queuedLock).AcquireQueuedContext(context.Background(), ttl); err != nil {
+				t.Errorf("Waiter %d cannot acquire lock: %s", i, err)
+				return
+			}
+			mtx.Lock()
+			order = append(order, i)
+			mtx.Unlock()
+			time.Sleep(scale)
+			l.Release()
+		}(i)
+		queuedPosition(t, l, i+1, ttl)
+	}
+
+	info, err := holder.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Queued != 3 || info.Position != 0 {
+		t.Errorf("Expected 3 waiters and the holder not to wait, got %+v", info)
+	}
+
+	// Non-queued contenders cannot jump the queue
+	if err = cfun(t).NewLock(lockName).Acquire(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+
+	// Waiters giving up leave the queue
+	ctx, cancel := context.WithTimeout(context.Background(), ttl/2)
+	err = cfun(t).NewLock(lockName).(queuedLock).AcquireQueuedContext(ctx, ttl)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected error '%s', got '%s'", context.DeadlineExceeded, err)
+	}
+	if info, err = holder.Info(); err != nil || info.Queued != 3 {
+		t.Errorf("Expected 3 waiters, got %+v (%v)", info, err)
+	}
+
+	holder.Release()
+	wg.Wait()
+	if fmt.Sprint(order) != "[0 1 2]" {
+		t.Errorf("Waiters should get the lock in arrival order, got %v", order)
+	}
+	if info, err = holder.Info(); err != nil || info.Queued != 0 {
+		t.Errorf("Expected no waiters, got %+v (%v)", info, err)
+	}
+}
@@ -1,21 +1,38 @@
 package glock
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"sync"
 	"time"
-
-	"github.com/aristanetworks/goarista/monotime"
 )
 
-// LockManager manages all the locks for a single client
+// LockManager manages all the locks for a single client.
+// It is safe for concurrent use by multiple goroutines: calls for the same
+// lock name are serialized, so concurrent acquisitions of a lock wait for
+// each other and the later ones refresh the lock acquired by the first.
 type LockManager struct {
 	Logger *log.Logger
+	// PollInterval is how often the manager polls the locks it watches or
+	// waits for, if the client cannot be notified of their changes.
+	PollInterval time.Duration
+	// Clock is the clock the manager waits for locks and keeps leases with,
+	// set by NewLockManagerWithClock. It is SystemClock by default.
+	Clock  Clock
 	opts   AcquireOptions
 	client Client
 	locks  map[string]Lock
-	hb     map[string]chan error
+	ttls   map[string]time.Duration
+	data   map[string]string
+	hb     map[string]*keeper
+	leases map[string]*lease
+	// mtx protects the maps
+	mtx *sync.Mutex
+	// busy holds a channel for every lock name with a call in progress,
+	// closed when the call returns
+	busy map[string]chan struct{}
 }
 
 // AcquireOptions allows to set options during lock acquisition.
@@ -26,30 +43,278 @@ type AcquireOptions struct {
 	MaxWait time.Duration
 	// The Data to set with the lock.
 	Data string
+	// Fair makes the manager wait for exclusive locks in the queue kept by
+	// the store, if the store keeps one, so that waiters get the lock in
+	// arrival order. The queue is kept by memory, redis and zookeeper.
+	// Acquisitions which are not fair never jump the queue: they fail while
+	// waiters are queued, even if the lock is free, and Info reports the
+	// waiters in Queued. Waiting managers then wait for the lock to change.
+	Fair bool
+	// Reentrant makes acquiring a lock already held by the manager count as
+	// one more hold of the lock, besides refreshing it. The lock is released
+	// in the store only when it has been released as many times as held.
+	Reentrant bool
 }
 
 // NewLockManager returns a new LockManager for the given client.
 // By default, logging is sent to /dev/null. You must call SetOutput() on
 // the Logger instance if you want logging to be sent somewhere.
 func NewLockManager(client Client, opts AcquireOptions) *LockManager {
+	return NewLockManagerWithClock(client, opts, SystemClock)
+}
+
+// NewLockManagerWithClock is like NewLockManager, but the manager measures
+// time with clock, e.g. a fake clock in tests.
+func NewLockManagerWithClock(client Client, opts AcquireOptions, clock Clock) *LockManager {
 	return &LockManager{
 		log.New(ioutil.Discard, "glock: ", log.LstdFlags|log.LUTC),
+		defaultPollInterval,
+		clockOrSystem(clock),
 		opts,
 		client,
 		make(map[string]Lock),
-		make(map[string]chan error),
+		make(map[string]time.Duration),
+		make(map[string]string),
+		make(map[string]*keeper),
+		make(map[string]*lease),
+		&sync.Mutex{},
+		make(map[string]chan struct{}),
+	}
+}
+
+// pollInterval returns PollInterval, or the default if not set
+func (m *LockManager) pollInterval() time.Duration {
+	if m.PollInterval <= 0 {
+		return defaultPollInterval
+	}
+	return m.PollInterval
+}
+
+// clock returns the clock of the manager
+func (m *LockManager) clock() Clock {
+	return clockOrSystem(m.Clock)
+}
+
+// enter waits for the calls in progress for the given lock name to return,
+// and marks the name as busy until leave is called. It returns the context
+// error if ctx is done before that.
+func (m *LockManager) enter(ctx context.Context, lockName string) error {
+	for {
+		m.mtx.Lock()
+		done, ok := m.busy[lockName]
+		if !ok {
+			m.busy[lockName] = make(chan struct{})
+			m.mtx.Unlock()
+			return nil
+		}
+		m.mtx.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (m *LockManager) leave(lockName string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	close(m.busy[lockName])
+	delete(m.busy, lockName)
+}
+
+// lock returns the lock held by the manager with the given name, and its ttl
+func (m *LockManager) lock(lockName string) (Lock, time.Duration, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	lock, ok := m.locks[lockName]
+	return lock, m.ttls[lockName], ok
+}
+
+// lockData returns the data of the lock held by the manager with the given name
+func (m *LockManager) lockData(lockName string) string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.data[lockName]
+}
+
+// setLock sets the lock held by the manager with the given name, its ttl
+// and data. The lock is forgotten if it is nil, which ends its lease. A new
+// lease starts if the lock had none, or the previous one was lost.
+func (m *LockManager) setLock(lockName string, lock Lock, ttl time.Duration, data string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if lock == nil {
+		delete(m.locks, lockName)
+		delete(m.ttls, lockName)
+		delete(m.data, lockName)
+		if l, ok := m.leases[lockName]; ok {
+			l.cancel()
+			delete(m.leases, lockName)
+		}
+		return
+	}
+	_, held := m.locks[lockName]
+	m.locks[lockName] = lock
+	m.ttls[lockName] = ttl
+	m.data[lockName] = data
+	l, ok := m.leases[lockName]
+	if !ok {
+		l = &lease{}
+		m.leases[lockName] = l
+	}
+	if !held {
+		l.holds = 1
+	}
+	if l.ctx == nil || l.err != nil {
+		l.ctx, l.cancel = context.WithCancel(context.Background())
+		l.err = nil
+	}
+}
+
+// lease tracks a lock held by the manager until it is released, and the
+// callbacks waiting for it to end. A lease whose lock was acquired again
+// after it was lost starts over with a new context.
+type lease struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// err is the error the lease was lost with, nil while it lasts
+	err error
+	// holds counts the acquisitions of the lock not released yet
+	holds      int
+	onLost     []func(error)
+	onReleased []func()
+}
+
+// hold adds a hold to the lock with the given name, acquired again in
+// reentrant mode
+func (m *LockManager) hold(lockName string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if l, ok := m.leases[lockName]; ok {
+		l.holds++
+	}
+}
+
+// unhold drops a hold of the lock with the given name, and tells if the lock
+// is still held by outer acquisitions afterwards
+func (m *LockManager) unhold(lockName string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	l, ok := m.leases[lockName]
+	if !ok || l.holds <= 1 {
+		return false
+	}
+	l.holds--
+	return true
+}
+
+// holds returns the number of holds of the lock with the given name
+func (m *LockManager) holds(lockName string) int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if l, ok := m.leases[lockName]; ok {
+		return l.holds
+	}
+	return 0
+}
+
+// notOwned tells if err reports that the lock is not held by the client
+// anymore, because it expired or because it was stolen
+func notOwned(err error) bool {
+	return err == ErrLockNotOwned || err == ErrLockStolen
+}
+
+// leaseLost ends the lease of the lock with the given name, lost with err.
+// Callbacks run in their own goroutine, so that they can call the manager.
+func (m *LockManager) leaseLost(lockName string, err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	l, ok := m.leases[lockName]
+	if !ok || l.err != nil {
+		return
+	}
+	l.err = err
+	l.cancel()
+	for _, f := range l.onLost {
+		go f(err)
+	}
+}
+
+// leaseReleased ends the lease of the lock with the given name, released by
+// the manager
+func (m *LockManager) leaseReleased(lockName string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	l, ok := m.leases[lockName]
+	if !ok {
+		return
+	}
+	l.cancel()
+	for _, f := range l.onReleased {
+		go f()
+	}
+}
+
+// Context returns a context which is cancelled as soon as the lease of the
+// lock with the given name is lost, or the lock released. Work that must
+// only run while the lock is held can use it to abort. If the lock was lost
+// and acquired again, the context of the new lease is returned.
+func (m *LockManager) Context(lockName string) (context.Context, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	l, ok := m.leases[lockName]
+	if !ok {
+		return nil, ErrInvalidLock
+	}
+	return l.ctx, nil
+}
+
+// OnLost registers f to be called with the error the lease of the lock with
+// the given name was lost with, either by the lease keeper or by a call of
+// the manager which found the lock not owned anymore. f is called at every
+// loss while the manager holds the lock, and at once if the lease is already
+// lost. Callbacks run in their own goroutine.
+func (m *LockManager) OnLost(lockName string, f func(error)) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	l, ok := m.leases[lockName]
+	if !ok {
+		return ErrInvalidLock
+	}
+	l.onLost = append(l.onLost, f)
+	if l.err != nil {
+		go f(l.err)
+	}
+	return nil
+}
+
+// OnReleased registers f to be called when the lock with the given name is
+// released through the manager. Callbacks run in their own goroutine.
+func (m *LockManager) OnReleased(lockName string, f func()) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	l, ok := m.leases[lockName]
+	if !ok {
+		return ErrInvalidLock
 	}
+	l.onReleased = append(l.onReleased, f)
+	return nil
 }
 
 // SetData updates data for an existing, acquired lock. Data won't be
 // saved into the backend database until the lock is refreshed (either manually
 // or at the next heartbeat.
 func (m *LockManager) SetData(lock, data string) error {
-	l, ok := m.locks[lock]
+	m.enter(context.Background(), lock)
+	defer m.leave(lock)
+	l, ttl, ok := m.lock(lock)
 	if !ok {
 		return ErrInvalidLock
 	}
 	l.SetData(data)
+	m.setLock(lock, l, ttl, data)
 	return nil
 }
 
@@ -60,51 +325,209 @@ func (m *LockManager) Client() Client {
 
 // Info returns information about a lock with the given name
 func (m *LockManager) Info(lockName string) (*LockInfo, error) {
-	lock, ok := m.locks[lockName]
+	return m.InfoContext(context.Background(), lockName)
+}
+
+// InfoContext is like Info, but honours the given context.
+func (m *LockManager) InfoContext(ctx context.Context, lockName string) (*LockInfo, error) {
+	if err := m.enter(ctx, lockName); err != nil {
+		return nil, err
+	}
+	defer m.leave(lockName)
+	return m.info(ctx, lockName)
+}
+
+func (m *LockManager) info(ctx context.Context, lockName string) (*LockInfo, error) {
+	lock, _, ok := m.lock(lockName)
 	if !ok {
 		return nil, ErrInvalidLock
 	}
-	info, err := lock.Info()
+	info, err := lock.InfoContext(ctx)
 	if err != nil {
 		return nil, err
 	}
+	info.Holds = m.holds(lockName)
 	return info, nil
 }
 
+// Token returns the fencing token obtained when the lock with the given name was acquired.
+func (m *LockManager) Token(lockName string) (uint64, error) {
+	m.enter(context.Background(), lockName)
+	defer m.leave(lockName)
+	lock, _, ok := m.lock(lockName)
+	if !ok {
+		return 0, ErrInvalidLock
+	}
+	return lock.Token(), nil
+}
+
 // Refresh refreshes a single lock.
 func (m *LockManager) Refresh(lockName string) error {
-	lock, ok := m.locks[lockName]
+	return m.RefreshContext(context.Background(), lockName)
+}
+
+// RefreshContext is like Refresh, but honours the given context.
+func (m *LockManager) RefreshContext(ctx context.Context, lockName string) error {
+	if err := m.enter(ctx, lockName); err != nil {
+		return err
+	}
+	defer m.leave(lockName)
+	lock, _, ok := m.lock(lockName)
 	if !ok {
 		return ErrInvalidLock
 	}
-	return lock.Refresh()
+	err := lock.RefreshContext(ctx)
+	if notOwned(err) {
+		m.leaseLost(lockName, err)
+	}
+	return err
+}
+
+// queuedLock is implemented by locks that can wait for their turn in a
+// queue kept by the store, so that waiters get the lock in arrival order.
+// The manager uses it to wait for exclusive locks in fair mode, instead of
+// polling.
+type queuedLock interface {
+	AcquireQueuedContext(ctx context.Context, ttl time.Duration) error
+}
+
+// lockMode tells how a lock is acquired: exclusive, shared or as a slot of a
+// semaphore with the given number of slots.
+type lockMode struct {
+	shared bool
+	slots  int
 }
 
-func (m *LockManager) acquire(lockName string, opts AcquireOptions) error {
-	lock := m.client.NewLock(lockName)
+var exclusive = lockMode{}
+
+func (mode lockMode) String() string {
+	switch {
+	case mode.slots > 0:
+		return fmt.Sprintf("semaphore of %d slots", mode.slots)
+	case mode.shared:
+		return "shared"
+	default:
+		return "exclusive"
+	}
+}
+
+// modeOf returns the mode a lock returned by newLock was created for.
+func modeOf(lock Lock) lockMode {
+	switch l := lock.(type) {
+	case Semaphore:
+		return lockMode{slots: l.Slots()}
+	case RWLock:
+		return lockMode{shared: true}
+	default:
+		return exclusive
+	}
+}
+
+// newLock returns a new lock object for the client. Shared locks are RWLocks
+// and semaphore slots are Semaphores, so that they can be told apart from the
+// exclusive ones.
+func newLock(client Client, lockName string, mode lockMode) (Lock, error) {
+	switch {
+	case mode.slots > 0:
+		semclient, ok := client.(SemaphoreClient)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return semclient.NewSemaphore(lockName, mode.slots), nil
+	case mode.shared:
+		rwclient, ok := client.(RWClient)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return rwclient.NewRWLock(lockName), nil
+	default:
+		return client.NewLock(lockName), nil
+	}
+}
+
+func (m *LockManager) acquire(ctx context.Context, lockName string, opts AcquireOptions, mode lockMode) error {
+	lock, err := newLock(m.client, lockName, mode)
+	if err != nil {
+		return err
+	}
 	if opts.Data != "" {
 		lock.SetData(opts.Data)
 	}
-	err := lock.Acquire(opts.TTL)
+	queued, isQueued := lock.(queuedLock)
+	switch {
+	case mode.shared:
+		err = lock.(RWLock).AcquireSharedContext(ctx, opts.TTL)
+	case isQueued && opts.Fair && opts.MaxWait > 0:
+		wctx, cancel := context.WithTimeout(ctx, opts.MaxWait)
+		err = queued.AcquireQueuedContext(wctx, opts.TTL)
+		cancel()
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
+			err = ErrLockHeldByOtherClient
+		}
+	default:
+		err = lock.AcquireContext(ctx, opts.TTL)
+	}
 	if err != nil {
-		m.Logger.Printf("client %s: Cannot acquire lock '%s': %s",
-			m.client.ID(), lockName, err.Error())
+		m.Logger.Printf("client %s: Cannot acquire lock '%s' (%s): %s",
+			m.client.ID(), lockName, mode, err.Error())
 		return err
 	}
-	m.Logger.Printf("client %s: Acquired lock '%s' for %v", m.client.ID(), lockName, opts.TTL)
-	m.locks[lockName] = lock
+	m.Logger.Printf("client %s: Acquired lock '%s' (%s) for %v", m.client.ID(), lockName, mode, opts.TTL)
+	m.setLock(lockName, lock, opts.TTL, opts.Data)
 	return nil
 }
 
 // Acquire tries to acquire the lock with the given name using the default TTL
 // for the manager. If the lock cannot be acquired, it will wait up to MaxWait
-// for the lock to be released by the owner.
-// If this manager instance already has acquired this lock, this action is a no-op.
+// for the lock to be released by the owner. In fair mode, with stores that
+// keep a queue of waiters, the lock is handed to waiters in arrival order.
+// If this manager instance already has acquired this lock, this action is a
+// refresh, also when the lock was acquired by a concurrent call. A lock held
+// in another mode, e.g. shared, is not refreshed: ErrModeMismatch is returned.
 func (m *LockManager) Acquire(lockName string, opts AcquireOptions) error {
+	return m.AcquireContext(context.Background(), lockName, opts)
+}
 
-	var waited time.Duration
-	lock := m.client.NewLock(lockName)
+// AcquireContext is like Acquire, but stops waiting for the lock as soon as
+// ctx is done, in which case the context error is returned.
+func (m *LockManager) AcquireContext(ctx context.Context, lockName string, opts AcquireOptions) error {
+	return m.acquireWait(ctx, lockName, opts, exclusive)
+}
+
+// AcquireShared is like Acquire, but acquires the lock in shared mode.
+// The client must implement RWClient, ErrNotSupported is returned otherwise.
+// Shared locks are refreshed, heartbeated and released like exclusive ones.
+func (m *LockManager) AcquireShared(lockName string, opts AcquireOptions) error {
+	return m.AcquireSharedContext(context.Background(), lockName, opts)
+}
+
+// AcquireSharedContext is like AcquireShared, but stops waiting for the lock as soon as
+// ctx is done, in which case the context error is returned.
+func (m *LockManager) AcquireSharedContext(ctx context.Context, lockName string, opts AcquireOptions) error {
+	return m.acquireWait(ctx, lockName, opts, lockMode{shared: true})
+}
+
+// AcquireSlot is like Acquire, but acquires one of the given number of slots
+// of the semaphore with the given name. The client must implement
+// SemaphoreClient, ErrNotSupported is returned otherwise. ErrInvalidSlots is
+// returned if slots is not positive.
+// Slots are refreshed, heartbeated and released like locks, under the
+// semaphore name: a manager cannot hold a lock and a slot with the same name.
+func (m *LockManager) AcquireSlot(semaphoreName string, slots int, opts AcquireOptions) error {
+	return m.AcquireSlotContext(context.Background(), semaphoreName, slots, opts)
+}
+
+// AcquireSlotContext is like AcquireSlot, but stops waiting for a slot as soon as
+// ctx is done, in which case the context error is returned.
+func (m *LockManager) AcquireSlotContext(ctx context.Context, semaphoreName string, slots int, opts AcquireOptions) error {
+	if slots <= 0 {
+		return ErrInvalidSlots
+	}
+	return m.acquireWait(ctx, semaphoreName, opts, lockMode{slots: slots})
+}
 
+// defaults fills the options left unset with the ones of the manager
+func (m *LockManager) defaults(opts AcquireOptions) AcquireOptions {
 	if opts.TTL <= 0 {
 		opts.TTL = m.opts.TTL
 	}
@@ -117,21 +540,70 @@ func (m *LockManager) Acquire(lockName string, opts AcquireOptions) error {
 		opts.MaxWait = m.opts.MaxWait
 	}
 
-	if lock, ok := m.locks[lockName]; ok {
+	if !opts.Fair {
+		opts.Fair = m.opts.Fair
+	}
+
+	if !opts.Reentrant {
+		opts.Reentrant = m.opts.Reentrant
+	}
+	return opts
+}
+
+func (m *LockManager) acquireWait(ctx context.Context, lockName string, opts AcquireOptions, mode lockMode) error {
+	opts = m.defaults(opts)
+	if err := m.enter(ctx, lockName); err != nil {
+		return err
+	}
+	defer m.leave(lockName)
+	return m.acquireEntered(ctx, lockName, opts, mode)
+}
+
+// acquireEntered is like acquireWait, for a name the caller entered already
+// and options with the defaults filled in
+func (m *LockManager) acquireEntered(ctx context.Context, lockName string, opts AcquireOptions, mode lockMode) error {
+	var waited time.Duration
+	lock, err := newLock(m.client, lockName, mode)
+	if err != nil {
+		return err
+	}
+
+	if lock, _, ok := m.lock(lockName); ok {
+		if held := modeOf(lock); held != mode {
+			m.Logger.Printf("client %s: Cannot acquire lock '%s' (%s): held as %s",
+				m.client.ID(), lockName, mode, held)
+			return ErrModeMismatch
+		}
 		lock.SetData(opts.Data)
-		return lock.RefreshTTL(opts.TTL)
+		m.setLock(lockName, lock, opts.TTL, opts.Data)
+		err = lock.RefreshTTLContext(ctx, opts.TTL)
+		if notOwned(err) {
+			m.leaseLost(lockName, err)
+		}
+		if err == nil && opts.Reentrant {
+			m.hold(lockName)
+		}
+		return err
 	}
 
+	// events of the lock, watched while waiting for it
+	var events <-chan LockEvent
+	watched := false
+	clock := m.clock()
 	for {
-		init := monotime.Now()
-		err := m.acquire(lockName, opts)
+		init := clock.Now()
+		err := m.acquire(ctx, lockName, opts, mode)
 		if err == nil {
 			return nil
 		}
 		if err != ErrLockHeldByOtherClient {
 			return err
 		}
-		info, err := lock.Info()
+		if _, ok := lock.(queuedLock); ok && opts.Fair && mode == exclusive {
+			// acquire waited in the queue already
+			return err
+		}
+		info, err := lock.InfoContext(ctx)
 		if err != nil {
 			return err
 		}
@@ -142,35 +614,114 @@ func (m *LockManager) Acquire(lockName string, opts AcquireOptions) error {
 			return ErrLockHeldByOtherClient
 		}
 
-		wait := info.TTL - monotime.Since(init)
+		if !watched && mode.slots == 0 {
+			// wake up as soon as the lock changes, instead of waiting for the
+			// holder to expire. The lock may have changed before the watch
+			// started, so try again at once.
+			watched = true
+			wctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if events, err = m.Watch(wctx, lockName); err == nil {
+				continue
+			}
+			m.Logger.Printf("client %s: Cannot watch lock '%s': %s", m.client.ID(), lockName, err)
+		}
+
+		wait := info.TTL - clock.Now().Sub(init)
+		if !info.Acquired && info.Queued > 0 {
+			// the lock is free, but fair waiters are queued ahead: wait for
+			// the first of them to take it
+			wait = m.pollInterval()
+		} else if wait < time.Millisecond {
+			// the lock looks free, yet acquire failed, e.g. because the
+			// store keeps it a while after expiry: back off until it
+			// changes, instead of spinning
+			wait = m.pollInterval()
+		}
 		if waited+wait > opts.MaxWait {
 			wait = opts.MaxWait - waited
 		}
 
-		time.Sleep(wait)
-		waited = waited + wait
+		start := clock.Now()
+		timer := clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-events:
+		case <-ctx.Done():
+			timer.Stop()
+			m.Logger.Printf("client %s: Gave up waiting for lock '%s' after %v: %s",
+				m.client.ID(), lockName, waited+clock.Now().Sub(init), ctx.Err())
+			return ctx.Err()
+		}
+		timer.Stop()
+		waited = waited + clock.Now().Sub(start)
 	}
 }
 
 // Release releases a lock with the given name. The lock must be held by the current manager.
 // Any eventual heartbeating will be stopped as well.
+// A lock acquired again in reentrant mode is only released in the store by
+// the last Release: the others just drop a hold of the lock.
 func (m *LockManager) Release(lockName string) error {
+	return m.ReleaseContext(context.Background(), lockName)
+}
+
+// ReleaseContext is like Release, but honours the given context.
+// The lock is forgotten by the manager once released, or found not owned.
+// If the release fails otherwise, e.g. the context is done before the store
+// acknowledges it, the lock is kept so that it can be released again, but
+// its heartbeats are stopped anyway.
+func (m *LockManager) ReleaseContext(ctx context.Context, lockName string) error {
+	return m.release(ctx, lockName, false)
+}
+
+// release releases the lock with the given name, or drops one of its holds
+// if it is held by outer acquisitions, unless all the holds are released.
+func (m *LockManager) release(ctx context.Context, lockName string, all bool) error {
 	var err error
+	if err = m.enter(ctx, lockName); err != nil {
+		return err
+	}
+	defer m.leave(lockName)
+	if !all && m.unhold(lockName) {
+		m.Logger.Printf("client %s: Lock '%s' still held %d times", m.client.ID(), lockName, m.holds(lockName))
+		return nil
+	}
 	m.Logger.Printf("client %s: Releasing lock '%s'", m.client.ID(), lockName)
-	if lock, ok := m.locks[lockName]; ok {
-		m.StopHeartbeat(lockName)
-		err = lock.Release()
-		delete(m.locks, lockName)
+	if lock, _, ok := m.lock(lockName); ok {
+		m.stopHeartbeat(lockName)
+		err = lock.ReleaseContext(ctx)
+		switch {
+		case err == nil:
+			m.leaseReleased(lockName)
+		case notOwned(err):
+			m.leaseLost(lockName, err)
+		default:
+			return err
+		}
+		m.setLock(lockName, nil, 0, "")
 	}
 	return err
 }
 
-// ReleaseAll releases all the locks held by the manager.
+// ReleaseAll releases all the locks held by the manager, however many times
+// they are held.
 func (m *LockManager) ReleaseAll() map[string]error {
+	return m.ReleaseAllContext(context.Background())
+}
+
+// ReleaseAllContext is like ReleaseAll, but honours the given context.
+func (m *LockManager) ReleaseAllContext(ctx context.Context) map[string]error {
 	results := make(map[string]error)
 	var err error
+	m.mtx.Lock()
+	names := make([]string, 0, len(m.locks))
 	for n := range m.locks {
-		err = m.Release(n)
+		names = append(names, n)
+	}
+	m.mtx.Unlock()
+	for _, n := range names {
+		err = m.release(ctx, n, true)
 		if err != nil {
 			results[n] = err
 		}
@@ -178,74 +729,98 @@ func (m *LockManager) ReleaseAll() map[string]error {
 	return results
 }
 
-func heartbeat(client Client, logger *log.Logger, lockName string, ttl time.Duration, control chan error) {
-	client.Reconnect()
-	defer client.Close()
-	freq := time.Duration(ttl / 2)
-	elapsed := time.Duration(0)
-	sleeptime := 10 * time.Millisecond
-	if sleeptime > freq {
-		sleeptime = freq
-	}
-
-	lock := client.NewLock(lockName)
-	for {
-		select {
-		case <-control:
-			return
-
-		default:
-			if elapsed >= freq {
-				start := time.Now()
-				err := lock.RefreshTTL(ttl)
-				if err != nil {
-					logger.Printf("client %s: heartbeat -- FATAL cannot refresh lock '%s': %s",
-						client.ID(), lockName, err.Error())
-					select {
-					case control <- err:
-						return
-					case <-time.After(sleeptime):
-						panic(err)
-					}
-				}
-				logger.Printf("client %s: heartbeat -- refreshed lock '%s' for %v",
-					client.ID(), lockName, ttl)
-				s := sleeptime - (time.Now().Sub(start))
-				time.Sleep(s)
-				elapsed = s
-			} else {
-				time.Sleep(sleeptime)
-				elapsed += sleeptime
-			}
-		}
-	}
+// StartHeartbeat is like KeepLease with the default KeeperOptions: the lock
+// is refreshed every ttl/2, where ttl is the one the lock was acquired with,
+// and the lease keeper stops when the lease is lost.
+func (m *LockManager) StartHeartbeat(lockName string) (<-chan error, error) {
+	return m.KeepLease(lockName, KeeperOptions{})
 }
 
-// StartHeartbeat starts a goroutine in the backgroud that will refresh the
-// lock The lock will be refreshed every ttl/2 or whichever is greater.  The
-// background goroutine will panic() if the lock cannot be refreshed for any
-// reason The background goroutine will run forever until StopHeartbeat is
-// called or the lock released.  It will return a channel to signal if the lock
-// cannot be refreshed during heartbeats (before panicking)
-func (m *LockManager) StartHeartbeat(lockName string) (<-chan error, error) {
-	info, err := m.Info(lockName)
+// KeepLease starts a lease keeper, a goroutine in the background that
+// refreshes the lock with its own copy of the client. Shared locks and
+// semaphore slots are refreshed for this client only, regardless of the
+// other holders. The data set with SetData is saved at the next refresh.
+//
+// When a refresh fails, the keeper reconnects its client and retries with
+// backoff until the lease expires; if the store reports the lock as not
+// owned, or the lease expires first, the lease is lost and opts.OnLoss tells
+// what happens next. The keeper runs until StopHeartbeat is called or the
+// lock released. It returns a channel which receives the error the lease was
+// lost with, if nobody reads it errors are dropped. The loss also cancels the
+// Context of the lock and calls the OnLost callbacks.
+func (m *LockManager) KeepLease(lockName string, opts KeeperOptions) (<-chan error, error) {
+	m.enter(context.Background(), lockName)
+	defer m.leave(lockName)
+	info, err := m.info(context.Background(), lockName)
 	if err != nil {
 		return nil, err
 	}
+	m.stopHeartbeat(lockName)
+	lock, ttl, _ := m.lock(lockName)
+	ctx, cancel := context.WithCancel(context.Background())
+	k := &keeper{
+		manager: m,
+		name:    lockName,
+		mode:    modeOf(lock),
+		opts:    opts,
+		client:  m.client.Clone(),
+		expires: m.clock().Now().Add(info.TTL),
+		lost:    make(chan error, 1),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
 	m.Logger.Printf("client %s: Starting heartbeats for lock '%s' every %v", m.client.ID(),
-		lockName, info.TTL/2)
-	m.hb[lockName] = make(chan error)
-	go heartbeat(m.client.Clone(), m.Logger, lockName, info.TTL, m.hb[lockName])
-	return m.hb[lockName], nil
+		lockName, k.interval(ttl))
+	m.mtx.Lock()
+	m.hb[lockName] = k
+	m.mtx.Unlock()
+	go k.run(ctx)
+	return k.lost, nil
 }
 
 // StopHeartbeat will stop the background gororoutine, if any, that is heartbeating the given lock
 func (m *LockManager) StopHeartbeat(lockName string) {
-	if c, ok := m.hb[lockName]; ok {
+	m.enter(context.Background(), lockName)
+	defer m.leave(lockName)
+	m.stopHeartbeat(lockName)
+}
+
+func (m *LockManager) stopHeartbeat(lockName string) {
+	m.mtx.Lock()
+	k, ok := m.hb[lockName]
+	delete(m.hb, lockName)
+	m.mtx.Unlock()
+	if ok {
 		m.Logger.Printf("client %s: Stopping heartbeats for lock '%s'", m.client.ID(),
 			lockName)
-		c <- errors.New("")
-		close(c)
-		delete(m.hb, lockName)
+		k.stop()
+	}
+}
+
+// forget forgets the lock kept by k, which lost its lease
+func (m *LockManager) forget(ctx context.Context, k *keeper) {
+	if m.enter(ctx, k.name) != nil {
+		return
+	}
+	defer m.leave(k.name)
+	m.mtx.Lock()
+	if m.hb[k.name] == k {
+		delete(m.hb, k.name)
+	}
+	m.mtx.Unlock()
+	m.Logger.Printf("client %s: Forgetting lock '%s'", m.client.ID(), k.name)
+	m.setLock(k.name, nil, 0, "")
+}
+
+// reacquire acquires the lock kept by k again, after it lost its lease
+func (m *LockManager) reacquire(ctx context.Context, k *keeper) error {
+	if err := m.enter(ctx, k.name); err != nil {
+		return err
+	}
+	defer m.leave(k.name)
+	_, ttl, ok := m.lock(k.name)
+	if !ok {
+		return ErrInvalidLock
 	}
+	return m.acquire(ctx, k.name, AcquireOptions{TTL: ttl, Data: m.lockData(k.name)}, k.mode)
 }
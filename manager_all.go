@@ -0,0 +1,162 @@
+package glock
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// AcquireAll acquires all the locks with the given names, or none of them.
+// If the client implements MultiClient, e.g. redis, the locks are acquired
+// atomically. Otherwise they are acquired one at a time in name order, so
+// that managers acquiring overlapping sets of locks cannot deadlock, and the
+// ones acquired already are released if one cannot be acquired. The locks
+// already held by the manager are refreshed.
+// The manager waits up to MaxWait for all the locks to be free. The locks
+// acquired are refreshed, heartbeated and released one by one, like the ones
+// acquired with Acquire.
+func (m *LockManager) AcquireAll(lockNames []string, opts AcquireOptions) error {
+	return m.AcquireAllContext(context.Background(), lockNames, opts)
+}
+
+// AcquireAllContext is like AcquireAll, but stops waiting for the locks as
+// soon as ctx is done, in which case the context error is returned.
+func (m *LockManager) AcquireAllContext(ctx context.Context, lockNames []string, opts AcquireOptions) error {
+	names := sortedNames(lockNames)
+	opts = m.defaults(opts)
+	for i, n := range names {
+		if err := m.enter(ctx, n); err != nil {
+			for _, entered := range names[:i] {
+				m.leave(entered)
+			}
+			return err
+		}
+	}
+	defer func() {
+		for _, n := range names {
+			m.leave(n)
+		}
+	}()
+
+	var free []string
+	for _, n := range names {
+		if _, _, ok := m.lock(n); !ok {
+			free = append(free, n)
+			continue
+		}
+		if err := m.acquireEntered(ctx, n, opts, exclusive); err != nil {
+			return err
+		}
+	}
+	if len(free) == 0 {
+		return nil
+	}
+	if multi, ok := m.client.(MultiClient); ok {
+		return m.acquireAtomic(ctx, multi, free, opts)
+	}
+	return m.acquireOrdered(ctx, free, opts)
+}
+
+// sortedNames returns the given names sorted, without duplicates
+func sortedNames(lockNames []string) []string {
+	names := make([]string, 0, len(lockNames))
+	seen := make(map[string]bool)
+	for _, n := range lockNames {
+		if !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *LockManager) acquireAtomic(ctx context.Context, multi MultiClient, lockNames []string, opts AcquireOptions) error {
+	var waited time.Duration
+	locks := make([]Lock, len(lockNames))
+	for i, n := range lockNames {
+		locks[i] = multi.NewLock(n)
+		locks[i].SetData(opts.Data)
+	}
+	clock := m.clock()
+	for {
+		init := clock.Now()
+		err := multi.AcquireAllContext(ctx, locks, opts.TTL)
+		if err == nil {
+			break
+		}
+		if err == ErrNotSupported {
+			// the locks are not the client's own, e.g. it is wrapped
+			return m.acquireOrdered(ctx, lockNames, opts)
+		}
+		if err != ErrLockHeldByOtherClient {
+			m.Logger.Printf("client %s: Cannot acquire locks %v: %s",
+				m.client.ID(), lockNames, err.Error())
+			return err
+		}
+		if waited >= opts.MaxWait {
+			m.Logger.Printf("client %s: Cannot acquire locks %v after %v",
+				m.client.ID(), lockNames, waited)
+			return ErrLockHeldByOtherClient
+		}
+
+		// all the locks must be free: wait for the one that expires last
+		var wait time.Duration
+		for _, lock := range locks {
+			info, err := lock.InfoContext(ctx)
+			if err != nil {
+				return err
+			}
+			if info.TTL > wait {
+				wait = info.TTL
+			}
+		}
+		wait -= clock.Now().Sub(init)
+		if wait < time.Millisecond {
+			wait = time.Millisecond
+		}
+		if waited+wait > opts.MaxWait {
+			wait = opts.MaxWait - waited
+		}
+
+		if !sleep(ctx, clock, wait) {
+			m.Logger.Printf("client %s: Gave up waiting for locks %v after %v: %s",
+				m.client.ID(), lockNames, waited+clock.Now().Sub(init), ctx.Err())
+			return ctx.Err()
+		}
+		waited = waited + wait
+	}
+
+	m.Logger.Printf("client %s: Acquired locks %v for %v", m.client.ID(), lockNames, opts.TTL)
+	for i, n := range lockNames {
+		m.setLock(n, locks[i], opts.TTL, opts.Data)
+	}
+	return nil
+}
+
+func (m *LockManager) acquireOrdered(ctx context.Context, lockNames []string, opts AcquireOptions) error {
+	start := m.clock().Now()
+	for i, n := range lockNames {
+		o := opts
+		if o.MaxWait -= m.clock().Now().Sub(start); o.MaxWait < 0 {
+			o.MaxWait = 0
+		}
+		err := m.acquireEntered(ctx, n, o, exclusive)
+		if err == nil {
+			continue
+		}
+		for _, acquired := range lockNames[:i] {
+			lock, _, _ := m.lock(acquired)
+			m.Logger.Printf("client %s: Releasing lock '%s', cannot acquire lock '%s'",
+				m.client.ID(), acquired, n)
+			// released even if ctx is done, not to leave the lock behind
+			if rerr := lock.ReleaseContext(context.Background()); rerr != nil {
+				m.Logger.Printf("client %s: Cannot release lock '%s': %s",
+					m.client.ID(), acquired, rerr)
+			}
+			m.setLock(acquired, nil, 0, "")
+		}
+		return err
+	}
+	return nil
+}
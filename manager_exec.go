@@ -18,6 +18,13 @@ type PreExecHook func(log *log.Logger) error
 type ExecOptions struct {
 	Options AcquireOptions
 	PreExec PreExecHook
+	// Slots, if > 0, makes Exec acquire a slot of the semaphore with the given
+	// number of slots instead of the lock.
+	Slots int
+	// Locks, if not empty, are more locks to acquire together with the lock,
+	// with AcquireAll. They are all kept until the command exits, and the
+	// command is terminated if any of them is lost. It cannot be used with Slots.
+	Locks []string
 }
 
 // Exec executes the command only if the lock can be acquired
@@ -28,17 +35,30 @@ type ExecOptions struct {
 func (manager *LockManager) Exec(lock string, command *exec.Cmd, opts ExecOptions) (int, error) {
 	var err error
 	client := manager.Client()
-	err = manager.Acquire(lock, opts.Options)
+	names := append([]string{lock}, opts.Locks...)
+	switch {
+	case opts.Slots > 0 && len(opts.Locks) > 0:
+		err = ErrNotSupported
+	case opts.Slots > 0:
+		err = manager.AcquireSlot(lock, opts.Slots, opts.Options)
+	case len(opts.Locks) > 0:
+		names = sortedNames(names)
+		err = manager.AcquireAll(names, opts.Options)
+	default:
+		err = manager.Acquire(lock, opts.Options)
+	}
 
 	if err != nil {
-		manager.Logger.Printf("Exec (%s); Cannot acquire lock '%s': %s", client.ID(), lock, err.Error())
+		manager.Logger.Printf("Exec (%s); Cannot acquire locks %v: %s", client.ID(), names, err.Error())
 		return -1, err
 	}
 
 	defer func() {
-		err = manager.Release(lock)
-		if err != nil {
-			manager.Logger.Printf("Exec (%s); Cannot release lock '%s': %s", client.ID(), lock, err.Error())
+		for _, name := range names {
+			err = manager.Release(name)
+			if err != nil {
+				manager.Logger.Printf("Exec (%s); Cannot release lock '%s': %s", client.ID(), name, err.Error())
+			}
 		}
 	}()
 
@@ -58,11 +78,21 @@ func (manager *LockManager) Exec(lock string, command *exec.Cmd, opts ExecOption
 		return -1, err
 	}
 
-	control, err := manager.StartHeartbeat(lock)
-	if err != nil {
-		manager.Logger.Printf("Exec (%s); Cannot start heartbeats for lock '%s': %s", client.ID(), lock, err.Error())
-		kill(command)
-		return -1, err
+	control := make(chan error, len(names))
+	for _, name := range names {
+		if _, err = manager.StartHeartbeat(name); err == nil {
+			err = manager.OnLost(name, func(err error) {
+				select {
+				case control <- err:
+				default:
+				}
+			})
+		}
+		if err != nil {
+			manager.Logger.Printf("Exec (%s); Cannot start heartbeats for lock '%s': %s", client.ID(), name, err.Error())
+			kill(command)
+			return -1, err
+		}
 	}
 
 	done := make(chan error, 1)
@@ -90,7 +120,7 @@ func (manager *LockManager) Exec(lock string, command *exec.Cmd, opts ExecOption
 			// do not return here, it will arrive a message on the done channel
 
 		case refreshError := <-control:
-			manager.Logger.Printf("Exec(%s); Cannot refresh lock '%s', killing process: %s", client.ID(), lock, refreshError.Error())
+			manager.Logger.Printf("Exec(%s); Cannot refresh locks %v, killing process: %s", client.ID(), names, refreshError.Error())
 			kill(command)
 			return -1, refreshError
 
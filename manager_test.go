@@ -1,6 +1,8 @@
 package glock
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -70,7 +72,9 @@ func testManagerAcquireWait(t *testing.T, cfun newClientFunc, scale time.Duratio
 		err2 = m2.Acquire(lockName, options(scale, ttl, 4*ttl, defData))
 	}()
 
-	time.Sleep(time.Duration(3 * ttl))
+	// Waiting acquisitions are woken up by releases: release once the first
+	// acquire of m2, waiting 2 units of scale, gave up
+	time.Sleep(5 * scale)
 	err = m1.Release(lockName)
 	wg.Wait()
 
@@ -187,3 +191,726 @@ func testManagerAcquire(t *testing.T, cfun newClientFunc, scale time.Duration) {
 		t.Fatalf("Info on non-existing lock should return '%s', got '%s'", ErrInvalidLock, err)
 	}
 }
+
+func testManagerAcquireContext(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	m1, m2 := managers(cfun(t), cfun(t), scale)
+	defer m1.ReleaseAll()
+	defer m2.ReleaseAll()
+
+	err := m1.Acquire(lockName, AcquireOptions{})
+	if err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+
+	// Waiting for the lock must stop at the context deadline, well before MaxWait
+	maxWait := time.Duration(ttlLength*10) * scale
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ttlLength/3)*scale)
+	defer cancel()
+	start := time.Now()
+	err = m2.AcquireContext(ctx, lockName, AcquireOptions{MaxWait: maxWait})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Wanted: '%s', got: '%s'", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed >= maxWait {
+		t.Errorf("AcquireContext waited %v, past the context deadline", elapsed)
+	}
+	if _, err = m2.Info(lockName); err != ErrInvalidLock {
+		t.Errorf("Lock should not be held by manager2, Info returned '%s'", err)
+	}
+}
+
+func testManagerConcurrent(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	// leave time to all the goroutines to run before the locks expire
+	opts := AcquireOptions{TTL: time.Duration(ttlLength*10) * scale, Data: defData}
+	m1, m2 := NewLockManager(cfun(t), opts), NewLockManager(cfun(t), opts)
+	defer m1.ReleaseAll()
+	defer m2.ReleaseAll()
+
+	const goroutines = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, 10*goroutines)
+	tokens := make(chan uint64, goroutines)
+
+	// Concurrent acquisitions of the same lock from one manager: the first
+	// acquires it, the others refresh it
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m1.Acquire(lockName, AcquireOptions{}); err != nil {
+				errs <- err
+				return
+			}
+			if err := m1.SetData(lockName, defData); err != nil {
+				errs <- err
+			}
+			if err := m1.Refresh(lockName); err != nil {
+				errs <- err
+			}
+			if _, err := m1.Info(lockName); err != nil {
+				errs <- err
+			}
+			token, err := m1.Token(lockName)
+			if err != nil {
+				errs <- err
+			}
+			tokens <- token
+		}()
+	}
+
+	// Locks with different names are independent, heartbeats included
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if err := m1.Acquire(name, AcquireOptions{}); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := m1.StartHeartbeat(name); err != nil {
+				errs <- err
+			}
+			m1.StopHeartbeat(name)
+			if err := m1.Release(name); err != nil {
+				errs <- err
+			}
+		}(fmt.Sprintf("%s-%d", lockName, i))
+	}
+
+	wg.Wait()
+	close(errs)
+	close(tokens)
+
+	for err := range errs {
+		t.Errorf("Concurrent call failed: %s", err)
+	}
+	res := info(t, m1)
+	if !res.Acquired || res.Owner != m1.Client().ID() {
+		t.Errorf("info: %+v -- expected Acquired: true and Owner: %s", res, m1.Client().ID())
+	}
+	for token := range tokens {
+		if token != res.Token {
+			t.Errorf("The lock should have been acquired once, got tokens %d and %d", token, res.Token)
+		}
+	}
+	if err := m2.Acquire(lockName, AcquireOptions{}); err != ErrLockHeldByOtherClient {
+		t.Errorf("Wanted: '%s', got: '%s'", ErrLockHeldByOtherClient, err)
+	}
+
+	// Concurrent releases: the lock is released once
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m1.ReleaseAll()
+		}()
+	}
+	wg.Wait()
+	if _, err := m1.Info(lockName); err != ErrInvalidLock {
+		t.Errorf("Lock should have been released, Info returned '%v'", err)
+	}
+	if err := m2.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Errorf("Cannot acquire lock after release: %s", err)
+	}
+}
+
+// leaseEvents returns KeeperOptions with the given policy, refreshing the
+// lease a few times per ttl, and a channel receiving the events of the keeper
+func leaseEvents(policy LossPolicy, ttl time.Duration) (KeeperOptions, chan LeaseEvent) {
+	events := make(chan LeaseEvent, 100)
+	opts := KeeperOptions{
+		Interval: ttl / 4,
+		OnLoss:   policy,
+		OnEvent: func(ev LeaseEvent) {
+			select {
+			case events <- ev:
+			default:
+			}
+		},
+	}
+	return opts, events
+}
+
+// waitEvent waits for an event of the given type, skipping the others
+func waitEvent(t *testing.T, events chan LeaseEvent, typ LeaseEventType, timeout time.Duration) LeaseEvent {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == typ {
+				return ev
+			}
+		case <-deadline:
+			t.Fatalf("No '%s' lease event after %v", typ, timeout)
+		}
+	}
+}
+
+func testManagerKeeper(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	m1, m2 := managers(c1, cfun(t), scale)
+	defer m1.ReleaseAll()
+	defer m2.ReleaseAll()
+	ttl := time.Duration(ttlLength) * scale
+
+	if _, err := m1.KeepLease(lockName, KeeperOptions{}); err != ErrInvalidLock {
+		t.Fatalf("KeepLease, expected: '%s', got: '%s'", ErrInvalidLock, err)
+	}
+
+	// The lease is kept past its TTL, and the keeper saves the new data
+	if err := m1.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	opts, events := leaseEvents(StopOnLoss, ttl)
+	lost, err := m1.KeepLease(lockName, opts)
+	if err != nil {
+		t.Fatalf("Cannot keep lease: %s", err)
+	}
+	if err = m1.SetData(lockName, "kept"); err != nil {
+		t.Fatalf("Got error while setting data: '%s'", err)
+	}
+	time.Sleep(ttl * 2)
+	ev := waitEvent(t, events, LeaseRefreshed, ttl)
+	if ev.Lock != lockName || ev.Err != nil || !ev.Expires.After(ev.Time) {
+		t.Errorf("Invalid refreshed event: %+v", ev)
+	}
+	res := info(t, m1)
+	if !res.Acquired || res.Owner != c1.ID() || res.Data != "kept" {
+		t.Errorf("info: %+v -- expected Acquired: true, Owner: %s and Data: kept", res, c1.ID())
+	}
+
+	// Losing the lock stops the keeper, nobody has to read the lost channel
+	if err = c1.NewLock(lockName).Release(); err != nil {
+		t.Fatalf("Error in release: %s", err)
+	}
+	ev = waitEvent(t, events, LeaseLost, ttl*2)
+	if ev.Err != ErrLockNotOwned {
+		t.Errorf("Lease should be lost with '%s', got '%s'", ErrLockNotOwned, ev.Err)
+	}
+	if err = <-lost; err != ErrLockNotOwned {
+		t.Errorf("Lost channel should receive '%s', got '%s'", ErrLockNotOwned, err)
+	}
+	if err = m2.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lost lock: %s", err)
+	}
+	if err = m1.Release(lockName); err != ErrLockNotOwned {
+		t.Errorf("Releasing a lost lock should return '%s', got '%s'", ErrLockNotOwned, err)
+	}
+	if err = m2.Release(lockName); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+
+	// With ForgetOnLoss, the manager forgets the lock
+	if err = m1.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	opts, events = leaseEvents(ForgetOnLoss, ttl)
+	if _, err = m1.KeepLease(lockName, opts); err != nil {
+		t.Fatalf("Cannot keep lease: %s", err)
+	}
+	if err = c1.NewLock(lockName).Release(); err != nil {
+		t.Fatalf("Error in release: %s", err)
+	}
+	waitEvent(t, events, LeaseLost, ttl*2)
+	for deadline := time.Now().Add(ttl); ; {
+		if _, err = m1.Info(lockName); err == ErrInvalidLock {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Lost lock should be forgotten, Info returned '%v'", err)
+		}
+		time.Sleep(scale)
+	}
+
+	// With ReacquireOnLoss, the lock is acquired again
+	if err = m1.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	token, _ := m1.Token(lockName)
+	opts, events = leaseEvents(ReacquireOnLoss, ttl)
+	if _, err = m1.KeepLease(lockName, opts); err != nil {
+		t.Fatalf("Cannot keep lease: %s", err)
+	}
+	if err = c1.NewLock(lockName).Release(); err != nil {
+		t.Fatalf("Error in release: %s", err)
+	}
+	waitEvent(t, events, LeaseLost, ttl*2)
+	waitEvent(t, events, LeaseReacquired, ttl*2)
+	res = info(t, m1)
+	if !res.Acquired || res.Owner != c1.ID() {
+		t.Errorf("info: %+v -- expected Acquired: true and Owner: %s", res, c1.ID())
+	}
+	if newToken, _ := m1.Token(lockName); newToken <= token || newToken != res.Token {
+		t.Errorf("Reacquired lock should have a new token: %d, was %d, store has %d", newToken, token, res.Token)
+	}
+	if err = m1.Release(lockName); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+}
+
+func waitDone(t *testing.T, ctx context.Context, timeout time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(timeout):
+		t.Fatalf("Context of the lease should be done after %v", timeout)
+	}
+}
+
+func testManagerLease(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	m1, _ := managers(c1, cfun(t), scale)
+	defer m1.ReleaseAll()
+	ttl := time.Duration(ttlLength) * scale
+
+	if _, err := m1.Context(lockName); err != ErrInvalidLock {
+		t.Errorf("Context, expected: '%s', got: '%s'", ErrInvalidLock, err)
+	}
+	if err := m1.OnLost(lockName, func(error) {}); err != ErrInvalidLock {
+		t.Errorf("OnLost, expected: '%s', got: '%s'", ErrInvalidLock, err)
+	}
+	if err := m1.OnReleased(lockName, func() {}); err != ErrInvalidLock {
+		t.Errorf("OnReleased, expected: '%s', got: '%s'", ErrInvalidLock, err)
+	}
+
+	// Releasing the lock cancels its context
+	if err := m1.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	ctx, err := m1.Context(lockName)
+	if err != nil {
+		t.Fatalf("Cannot get context of lock: %s", err)
+	}
+	released := make(chan struct{}, 1)
+	lost := make(chan error, 1)
+	m1.OnReleased(lockName, func() { released <- struct{}{} })
+	m1.OnLost(lockName, func(err error) { lost <- err })
+	if err = m1.Refresh(lockName); err != nil {
+		t.Fatalf("Cannot refresh lock: %s", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("Context should not be done while the lock is held: %s", ctx.Err())
+	}
+	if err = m1.Release(lockName); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+	waitDone(t, ctx, scale)
+	select {
+	case <-released:
+	case <-time.After(ttl):
+		t.Errorf("OnReleased callback not called")
+	}
+	select {
+	case err = <-lost:
+		t.Errorf("OnLost callback called for a released lock: %s", err)
+	default:
+	}
+	if _, err = m1.Context(lockName); err != ErrInvalidLock {
+		t.Errorf("Context of released lock, expected: '%s', got: '%s'", ErrInvalidLock, err)
+	}
+
+	// The keeper cancels the context when the lease is lost, and the
+	// reacquired lock has a new one
+	if err = m1.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	ctx, _ = m1.Context(lockName)
+	m1.OnLost(lockName, func(err error) { lost <- err })
+	opts, events := leaseEvents(ReacquireOnLoss, ttl)
+	if _, err = m1.KeepLease(lockName, opts); err != nil {
+		t.Fatalf("Cannot keep lease: %s", err)
+	}
+	if err = c1.NewLock(lockName).Release(); err != nil {
+		t.Fatalf("Error in release: %s", err)
+	}
+	waitDone(t, ctx, ttl*2)
+	select {
+	case err = <-lost:
+		if err != ErrLockNotOwned {
+			t.Errorf("Lease should be lost with '%s', got '%s'", ErrLockNotOwned, err)
+		}
+	case <-time.After(ttl):
+		t.Errorf("OnLost callback not called")
+	}
+	waitEvent(t, events, LeaseReacquired, ttl*2)
+	newCtx, err := m1.Context(lockName)
+	if err != nil || newCtx == ctx || newCtx.Err() != nil {
+		t.Errorf("Reacquired lock should have a new context, got %v (%v)", newCtx, err)
+	}
+	if err = m1.Release(lockName); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+	waitDone(t, newCtx, scale)
+
+	// A refresh which finds the lock not owned ends the lease too, and
+	// callbacks registered afterwards are called at once
+	if err = m1.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	ctx, _ = m1.Context(lockName)
+	if err = c1.NewLock(lockName).Release(); err != nil {
+		t.Fatalf("Error in release: %s", err)
+	}
+	if err = m1.Refresh(lockName); err != ErrLockNotOwned {
+		t.Errorf("Refreshing a lost lock should return '%s', got '%s'", ErrLockNotOwned, err)
+	}
+	waitDone(t, ctx, scale)
+	m1.OnLost(lockName, func(err error) { lost <- err })
+	select {
+	case err = <-lost:
+		if err != ErrLockNotOwned {
+			t.Errorf("Lease should be lost with '%s', got '%s'", ErrLockNotOwned, err)
+		}
+	case <-time.After(ttl):
+		t.Errorf("OnLost callback not called for a lost lease")
+	}
+	if err = m1.Release(lockName); err != ErrLockNotOwned {
+		t.Errorf("Releasing a lost lock should return '%s', got '%s'", ErrLockNotOwned, err)
+	}
+
+	// A release which fails keeps the lock, and one which finds the lock
+	// not owned ends the lease as lost, without OnReleased callbacks
+	if err = m1.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	ctx, _ = m1.Context(lockName)
+	m1.OnReleased(lockName, func() { released <- struct{}{} })
+	m1.OnLost(lockName, func(err error) { lost <- err })
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = m1.ReleaseContext(done, lockName); err != context.Canceled {
+		t.Errorf("Release with a done context, expected '%s', got '%v'", context.Canceled, err)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("Context should not be done after a failed release: %s", ctx.Err())
+	}
+	if err = c1.NewLock(lockName).Release(); err != nil {
+		t.Fatalf("Error in release: %s", err)
+	}
+	if err = m1.Release(lockName); err != ErrLockNotOwned {
+		t.Errorf("Releasing a lost lock should return '%s', got '%s'", ErrLockNotOwned, err)
+	}
+	waitDone(t, ctx, scale)
+	select {
+	case err = <-lost:
+		if err != ErrLockNotOwned {
+			t.Errorf("Lease should be lost with '%s', got '%s'", ErrLockNotOwned, err)
+		}
+	case <-time.After(ttl):
+		t.Errorf("OnLost callback not called")
+	}
+	select {
+	case <-released:
+		t.Errorf("OnReleased callback called for a lost lock")
+	case <-time.After(scale):
+	}
+	if _, err = m1.Context(lockName); err != ErrInvalidLock {
+		t.Errorf("Context of lost lock, expected: '%s', got: '%s'", ErrInvalidLock, err)
+	}
+}
+
+func testManagerAcquireAll(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1, c2 := cfun(t), cfun(t)
+	m1, m2 := managers(c1, c2, scale)
+	defer m1.ReleaseAll()
+	defer m2.ReleaseAll()
+	ttl := time.Duration(ttlLength) * scale
+	a, b, c, d := lockName+"-a", lockName+"-b", lockName+"-c", lockName+"-d"
+
+	held := func(name string) *LockInfo {
+		res, err := c2.NewLock(name).Info()
+		if err != nil {
+			t.Fatalf("Error while getting lock info: '%s'", err)
+		}
+		return res
+	}
+
+	if err := m1.AcquireAll([]string{c, a, b, a}, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire locks: %s", err)
+	}
+	for _, n := range []string{a, b, c} {
+		if res := held(n); !res.Acquired || res.Owner != c1.ID() || res.Data != defData {
+			t.Errorf("info of '%s': %+v -- expected Acquired: true, Owner: %s, Data: %s", n, res, c1.ID(), defData)
+		}
+	}
+
+	// None of the locks is acquired if one is held
+	if err := m2.AcquireAll([]string{d, c}, AcquireOptions{}); err != ErrLockHeldByOtherClient {
+		t.Fatalf("Wanted: '%s', got: '%s'", ErrLockHeldByOtherClient, err)
+	}
+	if res := held(d); res.Acquired {
+		t.Errorf("Lock '%s' should not be acquired, got %+v", d, res)
+	}
+	if _, err := m2.Info(d); err != ErrInvalidLock {
+		t.Errorf("Lock '%s' should not be held by the manager, got '%v'", d, err)
+	}
+
+	// Locks held already are refreshed
+	if err := m1.AcquireAll([]string{a, d}, AcquireOptions{Data: "refreshed"}); err != nil {
+		t.Fatalf("Cannot acquire locks: %s", err)
+	}
+	if res := held(a); res.Data != "refreshed" {
+		t.Errorf("info of '%s': %+v -- expected Data: refreshed", a, res)
+	}
+	if errs := m1.ReleaseAll(); len(errs) != 0 {
+		t.Fatalf("Cannot release locks: %v", errs)
+	}
+
+	// Managers acquiring the same locks in a different order wait for each
+	// other, without deadlocks
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for i := 0; i < 3; i++ {
+		for _, try := range []struct {
+			m     *LockManager
+			names []string
+		}{{m1, []string{a, b, c}}, {m2, []string{c, b, a}}} {
+			wg.Add(1)
+			go func(m *LockManager, names []string) {
+				defer wg.Done()
+				if err := m.AcquireAll(names, AcquireOptions{MaxWait: ttl * 40}); err != nil {
+					errs <- err
+					return
+				}
+				for _, n := range names {
+					if err := m.Release(n); err != nil {
+						errs <- fmt.Errorf("release of '%s': %s", n, err)
+						return
+					}
+				}
+			}(try.m, try.names)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent call failed: %s", err)
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan LockEvent, timeout time.Duration) LockEvent {
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatalf("Events channel closed")
+		}
+		return ev
+	case <-time.After(timeout):
+		t.Fatalf("No event after %v", timeout)
+	}
+	return LockEvent{}
+}
+
+func testManagerWatch(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	m1, m2 := managers(c1, cfun(t), scale)
+	defer m1.ReleaseAll()
+	defer m2.ReleaseAll()
+	m2.PollInterval = scale
+	ttl := time.Duration(ttlLength) * scale
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := m2.Watch(ctx, lockName)
+	if err != nil {
+		t.Fatalf("Cannot watch lock: %s", err)
+	}
+
+	if err = m1.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	token, _ := m1.Token(lockName)
+	ev := nextEvent(t, events, ttl/2)
+	if ev.Type != LockAcquired || ev.Lock != lockName || ev.Owner != c1.ID() || ev.Token != token {
+		t.Errorf("Expected acquired event of %s with token %d, got %+v", c1.ID(), token, ev)
+	}
+	if err = m1.Release(lockName); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+	ev = nextEvent(t, events, ttl/2)
+	if ev.Type != LockReleased || ev.Owner != c1.ID() || ev.Token != token {
+		t.Errorf("Expected released event of %s with token %d, got %+v", c1.ID(), token, ev)
+	}
+
+	if err = c1.NewLock(lockName).Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	if ev = nextEvent(t, events, ttl/2); ev.Type != LockAcquired {
+		t.Errorf("Expected acquired event, got %+v", ev)
+	}
+	// consul does not tell how much of the TTL of a session is left
+	_, sessions := c1.(*ConsulClient)
+	ev = nextEvent(t, events, ttl*2)
+	if ev.Type != LockExpired && !(sessions && ev.Type == LockReleased) || ev.Owner != c1.ID() {
+		t.Errorf("Expected expired event of %s, got %+v", c1.ID(), ev)
+	}
+
+	cancel()
+	for range events {
+	}
+
+	// A waiting Acquire wakes up as soon as the lock is released
+	if err = m1.Acquire(lockName, AcquireOptions{TTL: ttl * 10, MaxWait: ttl}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	go func() {
+		time.Sleep(ttl / 2)
+		m1.Release(lockName)
+	}()
+	start := time.Now()
+	if err = m2.Acquire(lockName, AcquireOptions{MaxWait: ttl * 20}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > ttl*3 {
+		t.Errorf("Acquire should wake up when the lock is released, waited %v", elapsed)
+	}
+}
+
+func testManagerFair(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	ttl := time.Duration(ttlLength) * scale
+	holder := NewLockManager(cfun(t), AcquireOptions{TTL: ttl * 10, Data: "holder"})
+	defer holder.ReleaseAll()
+	if err := holder.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+
+	// Managers in fair mode wait in the queue, and get the lock in arrival order
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	var order []int
+	for i := 0; i < 2; i++ {
+		c := cfun(t)
+		m := NewLockManager(c, AcquireOptions{TTL: ttl, MaxWait: ttl * 20, Fair: true})
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := m.Acquire(lockName, AcquireOptions{}); err != nil {
+				t.Errorf("Manager %d cannot acquire lock: %s", i, err)
+				return
+			}
+			mtx.Lock()
+			order = append(order, i)
+			mtx.Unlock()
+			time.Sleep(scale)
+			m.Release(lockName)
+		}(i)
+		queuedPosition(t, c.NewLock(lockName), i+1, ttl)
+	}
+
+	// Managers not in fair mode wait for the queue to drain, without spinning
+	unfair := NewLockManager(cfun(t), AcquireOptions{TTL: ttl, MaxWait: ttl * 20})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := unfair.Acquire(lockName, AcquireOptions{}); err != nil {
+			t.Errorf("Manager not in fair mode cannot acquire lock: %s", err)
+			return
+		}
+		mtx.Lock()
+		order = append(order, 2)
+		mtx.Unlock()
+		unfair.Release(lockName)
+	}()
+
+	if err := holder.Release(lockName); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+	wg.Wait()
+	if fmt.Sprint(order) != "[0 1 2]" {
+		t.Errorf("Managers should get the lock in arrival order, got %v", order)
+	}
+}
+
+func testManagerStolen(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	m1, _ := managers(c1, cfun(t), scale)
+	defer m1.ReleaseAll()
+	admin := cfun(t).(AdminClient)
+	ttl := time.Duration(ttlLength) * scale
+
+	if err := m1.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	opts, events := leaseEvents(StopOnLoss, ttl)
+	lost, err := m1.KeepLease(lockName, opts)
+	if err != nil {
+		t.Fatalf("Cannot keep lease: %s", err)
+	}
+
+	// The keeper reports the lock as stolen
+	stolen, err := admin.Steal(lockName, ttl, "wedged")
+	if err != nil {
+		t.Fatalf("Cannot steal lock: %s", err)
+	}
+	defer stolen.Release()
+	ev := waitEvent(t, events, LeaseLost, ttl*2)
+	if ev.Err != ErrLockStolen {
+		t.Errorf("Lease should be lost with '%s', got '%s'", ErrLockStolen, ev.Err)
+	}
+	if err = <-lost; err != ErrLockStolen {
+		t.Errorf("Lost channel should receive '%s', got '%s'", ErrLockStolen, err)
+	}
+	if err = m1.Release(lockName); err != ErrLockStolen {
+		t.Errorf("Releasing a stolen lock should return '%s', got '%s'", ErrLockStolen, err)
+	}
+}
+
+func testManagerReentrant(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	m1, m2 := managers(cfun(t), cfun(t), scale)
+	defer m1.ReleaseAll()
+	defer m2.ReleaseAll()
+	reentrant := AcquireOptions{Reentrant: true}
+
+	// Acquiring a held lock again in reentrant mode adds a hold
+	for i := 1; i <= 3; i++ {
+		if err := m1.Acquire(lockName, reentrant); err != nil {
+			t.Fatalf("Cannot acquire lock: %s", err)
+		}
+		if res := info(t, m1); res.Holds != i {
+			t.Errorf("Expected %d holds, got %d", i, res.Holds)
+		}
+	}
+	if err := m1.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	if res := info(t, m1); res.Holds != 3 {
+		t.Errorf("Acquiring without reentrant mode should only refresh, got %d holds", res.Holds)
+	}
+
+	// Only the outermost release releases the lock in the store
+	for i := 2; i >= 1; i-- {
+		if err := m1.Release(lockName); err != nil {
+			t.Fatalf("Cannot release lock: %s", err)
+		}
+		if res := info(t, m1); !res.Acquired || res.Holds != i {
+			t.Errorf("info: %+v -- expected Acquired: true, Holds: %d", res, i)
+		}
+		if err := m2.Acquire(lockName, AcquireOptions{}); err != ErrLockHeldByOtherClient {
+			t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+		}
+	}
+	if err := m1.Release(lockName); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+	if _, err := m1.Info(lockName); err != ErrInvalidLock {
+		t.Errorf("Info, expected: '%s', got: '%s'", ErrInvalidLock, err)
+	}
+	if err := m2.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire released lock: %s", err)
+	}
+	if err := m2.Release(lockName); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+
+	// ReleaseAll releases the locks however many times they are held
+	for i := 0; i < 2; i++ {
+		if err := m1.Acquire(lockName, reentrant); err != nil {
+			t.Fatalf("Cannot acquire lock: %s", err)
+		}
+	}
+	if errs := m1.ReleaseAll(); len(errs) != 0 {
+		t.Errorf("Cannot release all the locks: %v", errs)
+	}
+	if err := m2.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire released lock: %s", err)
+	}
+}
@@ -0,0 +1,164 @@
+package glock
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func rwLock(t *testing.T, c Client) RWLock {
+	rwc, ok := c.(RWClient)
+	if !ok {
+		t.Fatalf("Client %T does not implement RWClient", c)
+	}
+	return rwc.NewRWLock(lockName)
+}
+
+func testRWLock(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1, c2, c3 := cfun(t), cfun(t), cfun(t)
+	r1, r2, w := rwLock(t, c1), rwLock(t, c2), rwLock(t, c3)
+	ttl := time.Duration(ttlLength) * scale
+
+	if err := r1.AcquireShared(ttl); err != nil {
+		t.Fatalf("Cannot acquire shared lock: %s", err)
+	}
+	if err := r2.AcquireShared(ttl); err != nil {
+		t.Fatalf("Cannot acquire shared lock held by another reader: %s", err)
+	}
+	if r1.Token() != 0 {
+		t.Errorf("Shared acquisitions should not get a token, got %d", r1.Token())
+	}
+
+	// Writers, with both RWLock and plain Lock, are excluded
+	if err := w.AcquireExclusive(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+	if err := c3.NewLock(lockName).Acquire(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+
+	info, err := w.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	holders := []string{c1.ID(), c2.ID()}
+	sort.Strings(holders)
+	if !info.Acquired || !info.Shared || info.Owner != "" {
+		t.Errorf("info: %+v -- expected Acquired: true, Shared: true and no Owner", info)
+	}
+	if !reflect.DeepEqual(info.Holders, holders) {
+		t.Errorf("info.Holders: expected %v, got %v", holders, info.Holders)
+	}
+	if info.TTL <= 0 || info.TTL > ttl {
+		t.Errorf("Invalid TTL returned by lock.Info() : %v", info.TTL)
+	}
+
+	// Refresh and Release act on the shared lease of the client
+	if err = w.Refresh(); err != ErrLockNotOwned {
+		t.Errorf("Refreshing a lock not held should return '%s', got: '%s'", ErrLockNotOwned, err)
+	}
+	if err = r1.RefreshTTL(ttl * 2); err != nil {
+		t.Errorf("Cannot refresh shared lock: %s", err)
+	}
+	if err = r1.Release(); err != nil {
+		t.Fatalf("Cannot release shared lock: %s", err)
+	}
+	if err = r1.Release(); err != ErrLockNotOwned {
+		t.Errorf("Releasing a lock twice should return '%s', got: '%s'", ErrLockNotOwned, err)
+	}
+	info, err = w.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if !reflect.DeepEqual(info.Holders, []string{c2.ID()}) {
+		t.Errorf("info.Holders: expected [%s], got %v", c2.ID(), info.Holders)
+	}
+	if err = r2.Release(); err != nil {
+		t.Fatalf("Cannot release shared lock: %s", err)
+	}
+
+	// Once all readers are gone, writers get in and keep readers out
+	if err = w.AcquireExclusive(ttl); err != nil {
+		t.Fatalf("Cannot acquire exclusive lock: %s", err)
+	}
+	if w.Token() == 0 {
+		t.Errorf("Exclusive acquisitions should get a token")
+	}
+	if err = r1.AcquireShared(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+	info, err = r1.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Shared || info.Owner != c3.ID() || len(info.Holders) != 0 {
+		t.Errorf("info: %+v -- expected Shared: false and Owner: %s", info, c3.ID())
+	}
+	if err = w.Release(); err != nil {
+		t.Fatalf("Cannot release exclusive lock: %s", err)
+	}
+
+	// Shared leases expire on their own
+	if err = r1.AcquireShared(scale * 2); err != nil {
+		t.Fatalf("Cannot acquire shared lock: %s", err)
+	}
+	time.Sleep(scale * 5)
+	info, err = r1.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: %s", err)
+	}
+	if info.Acquired {
+		t.Errorf("Shared lock should be expired, got %+v", info)
+	}
+	if err = r1.Refresh(); err != ErrLockNotOwned {
+		t.Errorf("Lock should be expired but refresh returned: '%s'", err)
+	}
+}
+
+func testManagerShared(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	m1, m2 := managers(cfun(t), cfun(t), scale)
+	m3 := NewLockManager(cfun(t), options(scale, ttlLength, 0, defData))
+	defer m1.ReleaseAll()
+	defer m2.ReleaseAll()
+	defer m3.ReleaseAll()
+
+	if err := m1.AcquireShared(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire shared lock: %s", err)
+	}
+	if err := m2.AcquireShared(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire shared lock: %s", err)
+	}
+	// A lock held in shared mode is not turned into an exclusive one
+	if err := m1.Acquire(lockName, AcquireOptions{}); err != ErrModeMismatch {
+		t.Errorf("Wanted: '%s', got: '%s'", ErrModeMismatch, err)
+	}
+	if _, err := m1.StartHeartbeat(lockName); err != nil {
+		t.Fatalf("Cannot start heartbeats: '%s'", err)
+	}
+
+	// m2 lease expires, while m1 is kept alive by heartbeats
+	time.Sleep(time.Duration(ttlLength) * scale * 2)
+	res := info(t, m1)
+	if !res.Shared || !reflect.DeepEqual(res.Holders, []string{m1.Client().ID()}) {
+		t.Errorf("info: %+v -- expected Shared: true and Holders: [%s]", res, m1.Client().ID())
+	}
+
+	err := m3.Acquire(lockName, AcquireOptions{MaxWait: time.Duration(ttlLength) * scale})
+	if err != ErrLockHeldByOtherClient {
+		t.Errorf("Wanted: '%s', got: '%s'", ErrLockHeldByOtherClient, err)
+	}
+	if err = m1.Release(lockName); err != nil {
+		t.Fatalf("Cannot release shared lock: %s", err)
+	}
+	if err = m3.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock after readers are gone: %s", err)
+	}
+	// nor an exclusive lock into a shared one
+	if err = m3.AcquireShared(lockName, AcquireOptions{}); err != ErrModeMismatch {
+		t.Errorf("Wanted: '%s', got: '%s'", ErrModeMismatch, err)
+	}
+	if res = info(t, m3); res.Shared || res.Owner != m3.Client().ID() {
+		t.Errorf("info: %+v -- expected Shared: false and Owner: %s", res, m3.Client().ID())
+	}
+}
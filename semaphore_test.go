@@ -0,0 +1,142 @@
+package glock
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func semaphore(t *testing.T, c Client, slots int) Semaphore {
+	sc, ok := c.(SemaphoreClient)
+	if !ok {
+		t.Fatalf("Client %T does not implement SemaphoreClient", c)
+	}
+	return sc.NewSemaphore(lockName, slots)
+}
+
+func testSemaphore(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1, c2, c3 := cfun(t), cfun(t), cfun(t)
+	s1, s2, s3 := semaphore(t, c1, 2), semaphore(t, c2, 2), semaphore(t, c3, 2)
+	ttl := time.Duration(ttlLength) * scale
+
+	if s1.Slots() != 2 {
+		t.Errorf("Expected 2 slots, got %d", s1.Slots())
+	}
+	if err := semaphore(t, c1, 0).Acquire(ttl); err != ErrInvalidSlots {
+		t.Errorf("Expected error '%s', got '%s'", ErrInvalidSlots, err)
+	}
+
+	if err := s1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire slot: %s", err)
+	}
+	if err := s1.Acquire(ttl); err != nil {
+		t.Errorf("Acquiring a slot twice should refresh it, got: %s", err)
+	}
+	if err := s2.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire second slot: %s", err)
+	}
+	if err := s3.Acquire(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected error '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+
+	// semaphores do not conflict with locks with the same name
+	lock := c3.NewLock(lockName)
+	if err := lock.Acquire(ttl); err != nil {
+		t.Errorf("Cannot acquire lock with the same name of a semaphore: %s", err)
+	}
+	lock.Release()
+
+	info, err := s3.Info()
+	if err != nil {
+		t.Fatalf("Error while getting semaphore info: %s", err)
+	}
+	holders := []string{c1.ID(), c2.ID()}
+	sort.Strings(holders)
+	if !info.Acquired || !info.Shared || info.Owner != "" {
+		t.Errorf("info: %+v -- expected Acquired: true, Shared: true and no Owner", info)
+	}
+	if !reflect.DeepEqual(info.Holders, holders) {
+		t.Errorf("info.Holders: expected %v, got %v", holders, info.Holders)
+	}
+	if info.TTL <= 0 || info.TTL > ttl {
+		t.Errorf("Invalid TTL returned by semaphore.Info() : %v", info.TTL)
+	}
+
+	if err = s3.Refresh(); err != ErrLockNotOwned {
+		t.Errorf("Refreshing a slot not held should return '%s', got: '%s'", ErrLockNotOwned, err)
+	}
+	if err = s1.RefreshTTL(ttl * 2); err != nil {
+		t.Errorf("Cannot refresh slot: %s", err)
+	}
+	if err = s1.Release(); err != nil {
+		t.Fatalf("Cannot release slot: %s", err)
+	}
+	if err = s1.Release(); err != ErrLockNotOwned {
+		t.Errorf("Releasing a slot twice should return '%s', got: '%s'", ErrLockNotOwned, err)
+	}
+	if err = s3.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire released slot: %s", err)
+	}
+	s2.Release()
+	s3.Release()
+
+	// slots expire on their own
+	if err = s1.Acquire(scale * 2); err != nil {
+		t.Fatalf("Cannot acquire slot: %s", err)
+	}
+	time.Sleep(scale * 5)
+	info, err = s1.Info()
+	if err != nil {
+		t.Fatalf("Error while getting semaphore info: %s", err)
+	}
+	if info.Acquired || len(info.Holders) != 0 {
+		t.Errorf("Slot should be expired, got %+v", info)
+	}
+	if err = s1.Refresh(); err != ErrLockNotOwned {
+		t.Errorf("Slot should be expired but refresh returned: '%s'", err)
+	}
+}
+
+func testManagerSemaphore(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	m1, m2 := managers(cfun(t), cfun(t), scale)
+	m3, m4 := managers(cfun(t), cfun(t), scale)
+	defer m1.ReleaseAll()
+	defer m2.ReleaseAll()
+	defer m3.ReleaseAll()
+	defer m4.ReleaseAll()
+
+	if err := m1.AcquireSlot(lockName, 0, AcquireOptions{}); err != ErrInvalidSlots {
+		t.Errorf("Wanted: '%s', got: '%s'", ErrInvalidSlots, err)
+	}
+	if err := m1.AcquireSlot(lockName, 2, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire slot: %s", err)
+	}
+	if err := m2.AcquireSlot(lockName, 2, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire slot: %s", err)
+	}
+	if err := m1.AcquireSlot(lockName, 3, AcquireOptions{}); err != ErrModeMismatch {
+		t.Errorf("Wanted: '%s', got: '%s'", ErrModeMismatch, err)
+	}
+	if err := m1.Acquire(lockName, AcquireOptions{}); err != ErrModeMismatch {
+		t.Errorf("Wanted: '%s', got: '%s'", ErrModeMismatch, err)
+	}
+	if _, err := m1.StartHeartbeat(lockName); err != nil {
+		t.Fatalf("Cannot start heartbeats: '%s'", err)
+	}
+
+	// m2 slot expires, while m1 is kept alive by heartbeats
+	time.Sleep(time.Duration(ttlLength) * scale * 2)
+	res := info(t, m1)
+	if !reflect.DeepEqual(res.Holders, []string{m1.Client().ID()}) {
+		t.Errorf("info: %+v -- expected Holders: [%s]", res, m1.Client().ID())
+	}
+
+	if err := m3.AcquireSlot(lockName, 2, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire slot freed by expiration: %s", err)
+	}
+	err := m4.AcquireSlot(lockName, 2, AcquireOptions{MaxWait: time.Duration(ttlLength) * scale / 3})
+	if err != ErrLockHeldByOtherClient {
+		t.Errorf("Wanted: '%s', got: '%s'", ErrLockHeldByOtherClient, err)
+	}
+}
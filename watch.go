@@ -0,0 +1,185 @@
+package glock
+
+import (
+	"context"
+	"time"
+)
+
+// LockEventType is the type of the changes of a watched lock
+type LockEventType int
+
+const (
+	// LockAcquired is emitted when the lock is acquired
+	LockAcquired LockEventType = iota
+	// LockReleased is emitted when the holder releases the lock
+	LockReleased
+	// LockExpired is emitted when the lock expires without being released
+	LockExpired
+)
+
+func (t LockEventType) String() string {
+	switch t {
+	case LockAcquired:
+		return "acquired"
+	case LockReleased:
+		return "released"
+	case LockExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// LockEvent describes a change of a watched lock
+type LockEvent struct {
+	Type LockEventType
+	// Lock is the name of the lock
+	Lock string
+	// Owner is the client which acquired the lock, or which held it until it
+	// was released or expired
+	Owner string
+	// Token is the fencing token of the owner
+	Token uint64
+	// Time is when the change was seen
+	Time time.Time
+}
+
+// defaultPollInterval is how often locks are polled by default, when the
+// client cannot be notified of their changes
+const defaultPollInterval = 100 * time.Millisecond
+
+// watcher reads a lock after every notification of the store, or every
+// interval without notifications, and when the lease of the holder is due
+// to expire. It turns the differences between two reads into events.
+type watcher struct {
+	name     string
+	lock     Lock
+	notify   <-chan struct{}
+	interval time.Duration
+	clock    Clock
+	last     *LockInfo
+	expires  time.Time
+}
+
+// read reads the lock, and returns the changes since the last read
+func (w *watcher) read(ctx context.Context) ([]LockEvent, error) {
+	// the lease is deemed to end no later than it does in the store
+	now := w.clock.Now()
+	info, err := w.lock.InfoContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.Shared {
+		// only exclusive holders are reported
+		info = &LockInfo{Name: info.Name}
+	}
+	var events []LockEvent
+	last := w.last
+	same := last != nil && last.Acquired && info.Acquired &&
+		last.Owner == info.Owner && last.Token == info.Token
+	if last != nil && last.Acquired && !same {
+		typ := LockReleased
+		if !now.Before(w.expires) {
+			typ = LockExpired
+		}
+		events = append(events, LockEvent{typ, w.name, last.Owner, last.Token, now})
+	}
+	if last != nil && info.Acquired && !same {
+		events = append(events, LockEvent{LockAcquired, w.name, info.Owner, info.Token, now})
+	}
+	w.last = info
+	w.expires = now.Add(info.TTL)
+	return events, nil
+}
+
+// wait waits for the next read, and returns false if ctx is done meanwhile.
+// Without notifications, or after a failed read, the lock is polled.
+func (w *watcher) wait(ctx context.Context, failed bool) bool {
+	var d time.Duration
+	if w.notify == nil || failed {
+		d = w.interval
+	}
+	if w.last != nil && w.last.Acquired {
+		left := w.expires.Sub(w.clock.Now()) + time.Millisecond
+		if left < time.Millisecond {
+			// expired already, but the store did not notice yet
+			left = time.Millisecond
+		}
+		if d <= 0 || left < d {
+			d = left
+		}
+	}
+	var timeout <-chan time.Time
+	if d > 0 {
+		t := w.clock.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C()
+	}
+	select {
+	case _, ok := <-w.notify:
+		if !ok {
+			// notifications stopped, poll from now on
+			w.notify = nil
+		}
+	case <-timeout:
+	case <-ctx.Done():
+		return false
+	}
+	return ctx.Err() == nil
+}
+
+func (w *watcher) run(ctx context.Context, events chan<- LockEvent) {
+	defer close(events)
+	failed := false
+	for w.wait(ctx, failed) {
+		changes, err := w.read(ctx)
+		failed = err != nil
+		for _, ev := range changes {
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Watch streams the changes of the lock with the given name, which does not
+// need to be held by the manager: its acquisitions, releases and expirations,
+// as seen from the time Watch is called. The channel is closed when ctx is
+// done.
+//
+// If the client implements NotifyClient, the lock is read whenever the store
+// notifies a change, otherwise it is polled every PollInterval. It is also
+// read when the lease of the holder is due to expire. Changes between two
+// reads are coalesced, so short-lived holders may go unnoticed. Shared
+// holders are not reported.
+func (m *LockManager) Watch(ctx context.Context, lockName string) (<-chan LockEvent, error) {
+	w := &watcher{
+		name:     lockName,
+		lock:     m.client.NewLock(lockName),
+		interval: m.pollInterval(),
+		clock:    m.clock(),
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	if client, ok := m.client.(NotifyClient); ok {
+		notify, err := client.Notify(ctx, lockName)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		w.notify = notify
+	}
+	// the lock is read once before returning, so that changes are reported
+	// from now on
+	if _, err := w.read(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+	events := make(chan LockEvent)
+	go func() {
+		defer cancel()
+		w.run(ctx, events)
+	}()
+	return events, nil
+}